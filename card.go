@@ -1,6 +1,7 @@
 package anki
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -74,7 +75,7 @@ func (c *Collection) GetCard(id int64) (*Card, error) {
 
 // SetDeck moves a list of cards to a different deck.
 func (c *Collection) SetDeck(cards []int64, deckID int64) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.transact(func(tx *sql.Tx) error {
 		if _, err := getDeck(tx, deckID); err != nil {
 			if !errors.Is(err, sql.ErrNoRows) {
 				return err
@@ -94,7 +95,7 @@ func (c *Collection) SetDeck(cards []int64, deckID int64) error {
 
 			card.OriginalDeckID = card.DeckID
 			card.DeckID = deckID
-			card.Modified = time.Now()
+			card.Modified = c.ids.now()
 
 			if err = updateCard(tx, card); err != nil {
 				return err
@@ -110,12 +111,24 @@ func getCard(q sqlQueryer, id int64) (*Card, error) {
 }
 
 // addCard adds a new card to the collection.
-func addCard(e sqlExecer, card *Card) error {
+// If the card's ID is zero or already taken, a new ID is allocated from ids.
+func addCard(e sqlExecer, card *Card, ids *idAllocator) error {
 	id := card.ID
 	if id == 0 {
-		id = time.Now().UnixMilli()
+		id = ids.next()
 	}
-	args := []any{
+	id, err := sqlInsert(e, addCardQuery, cardArgs(id, card)...)
+	if err == nil {
+		card.ID = id
+		ids.use(id)
+	}
+	return err
+}
+
+// cardArgs returns the values of the columns of a card, with the given ID,
+// in the order the insert queries expect them.
+func cardArgs(id int64, card *Card) []any {
+	return []any{
 		id,
 		card.NoteID,
 		card.DeckID,
@@ -135,11 +148,6 @@ func addCard(e sqlExecer, card *Card) error {
 		card.Flags,
 		card.Data,
 	}
-	id, err := sqlInsert(e, addCardQuery, args...)
-	if err == nil {
-		card.ID = id
-	}
-	return err
 }
 
 // updateCard updates a card in the collection.
@@ -179,6 +187,12 @@ func (c *Collection) ListCards(opts *ListCardsOptions) iter.Seq2[*Card, error] {
 	return listCards(c.db, opts)
 }
 
+// ListCardsContext is like ListCards, but stops the iteration with an error
+// once ctx is done.
+func (c *Collection) ListCardsContext(ctx context.Context, opts *ListCardsOptions) iter.Seq2[*Card, error] {
+	return listCards(sqlWithContext(ctx, c.db), opts)
+}
+
 // listCards lists cards with optional filtering.
 func listCards(q sqlQueryer, opts *ListCardsOptions) iter.Seq2[*Card, error] {
 	var args []any
@@ -0,0 +1,101 @@
+package anki
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. A collection takes every ID and timestamp it
+// writes from its clock, so a fixed clock makes its content reproducible.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to a Clock.
+type ClockFunc func() time.Time
+
+// Now returns f().
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// systemClock is the clock of the system.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// idAllocator allocates IDs for the rows of a collection. Like Anki's IDs,
+// they follow the time of a clock in milliseconds, but they always increase
+// and never go back past the largest ID in use, so they are unique across the
+// collection even when the clock stands still. It is safe for concurrent use.
+//
+// A nil allocator follows the system clock without keeping track of the IDs
+// in use, which is enough where IDs are given, as when a database is created
+// or upgraded.
+type idAllocator struct {
+	mu    sync.Mutex
+	clock Clock
+	last  int64
+	// guids derives the GUIDs of new notes from their content instead of
+	// picking them at random.
+	guids bool
+}
+
+// newIDAllocator creates an allocator following clock, or the system clock if
+// clock is nil.
+func newIDAllocator(clock Clock) *idAllocator {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &idAllocator{clock: clock}
+}
+
+// seed records the largest ID in use in the database.
+func (a *idAllocator) seed(q sqlQueryer) error {
+	last, err := sqlGet(q, scanValue[int64], getMaxIDQuery)
+	if err != nil {
+		return err
+	}
+	a.use(last)
+	return nil
+}
+
+// next allocates an ID.
+func (a *idAllocator) next() int64 {
+	if a == nil {
+		return time.Now().UnixMilli()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last = max(a.last+1, a.clock.Now().UnixMilli())
+	return a.last
+}
+
+// use records an ID that was assigned by other means.
+func (a *idAllocator) use(id int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last = max(a.last, id)
+}
+
+// guid returns a GUID for a new note, whose ID must be set.
+func (a *idAllocator) guid(note *Note) (string, error) {
+	if a == nil || !a.guids {
+		return randomGUID()
+	}
+	return derivedGUID(note), nil
+}
+
+// now returns the time of the clock, for the timestamps written along with
+// the IDs.
+func (a *idAllocator) now() time.Time {
+	if a == nil {
+		return time.Now()
+	}
+	return a.clock.Now()
+}
@@ -0,0 +1,68 @@
+package anki
+
+import (
+	"testing"
+	"time"
+)
+
+// TestIDAllocator tests the idAllocator type.
+func TestIDAllocator(t *testing.T) {
+	now := time.UnixMilli(1000)
+	clock := ClockFunc(func() time.Time { return now })
+
+	tests := []struct {
+		name string
+		use  int64
+		want []int64
+	}{
+		{"fixed clock", 0, []int64{1000, 1001, 1002}},
+		{"past IDs", 500, []int64{1000, 1001, 1002}},
+		{"future IDs", 2000, []int64{2001, 2002, 2003}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := newIDAllocator(clock)
+			ids.use(tt.use)
+			for _, want := range tt.want {
+				if got := ids.next(); got != want {
+					t.Errorf("next() = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestCollectionClock tests that a collection takes its IDs and timestamps
+// from its clock.
+func TestCollectionClock(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	col, err := CreateInMemory(&CreateOptions{
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	nt := &Notetype{
+		Name:      "Test",
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+		Config:    NewNotetypeConfig("", false),
+	}
+	if err = col.AddNotetype(nt); err != nil {
+		t.Fatal(err)
+	}
+	if nt.ID != now.UnixMilli() {
+		t.Errorf("notetype ID = %v, want %v", nt.ID, now.UnixMilli())
+	}
+	if id0, id1 := nt.Fields[0].Config.GetId(), nt.Fields[1].Config.GetId(); id0 == id1 {
+		t.Errorf("field config IDs are both %v", id0)
+	}
+	if !nt.Modified.Equal(now) {
+		t.Errorf("notetype modified = %v, want %v", nt.Modified, now)
+	}
+	if !col.ModTime().Equal(now) {
+		t.Errorf("ModTime() = %v, want %v", col.ModTime(), now)
+	}
+}
@@ -2,31 +2,70 @@ package anki
 
 import (
 	"archive/zip"
+	"context"
 	"database/sql"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
+
+	"github.com/lftk/anki/pb"
 )
 
 // Collection represents an Anki collection.
 type Collection struct {
-	db    *sql.DB
-	dir   string
-	temp  bool
-	props *props
+	db      *sql.DB
+	dir     string
+	path    string
+	store   MediaStore
+	temp    bool
+	profile *profile
+	props   *props
+
+	// readOnly reports whether the collection was opened read-only.
+	readOnly bool
+	// pkg holds the media files left in the package the collection was
+	// opened from, if they are read lazily.
+	pkg *packageMedia
+	// source and version describe the package the collection was opened from.
+	source  string
+	version pb.PackageMetadata_Version
+	// dirty reports whether the collection was modified since it was opened
+	// or last saved.
+	dirty atomic.Bool
+	// mem is a connection to the database of an in-memory collection, which
+	// is discarded once the collection is closed.
+	mem *sql.Conn
+	// ids allocates the IDs of new rows and tells the time of the clock of
+	// the collection.
+	ids *idAllocator
 }
 
-// newCollection creates a new collection from a database and directory.
-func newCollection(db *sql.DB, dir string, temp bool) (*Collection, error) {
+// ErrNoSource is returned by Save when the collection was not opened from a file.
+var ErrNoSource = errors.New("collection has no source file")
+
+// newCollection creates a new collection from a database and directory,
+// following clock, or the system clock if clock is nil.
+func newCollection(db *sql.DB, dir string, temp bool, clock Clock) (*Collection, error) {
 	props, err := loadProps(db)
 	if err != nil {
 		return nil, err
 	}
+	ids := newIDAllocator(clock)
+	if err = ids.seed(db); err != nil {
+		return nil, err
+	}
 	return &Collection{
 		db:    db,
 		dir:   dir,
+		path:  databasePath(dir),
+		store: NewDirMediaStore(mediaDir(dir)),
 		temp:  temp,
 		props: props,
+		ids:   ids,
 	}, nil
 }
 
@@ -44,87 +83,332 @@ func inTempDir(fn func(dir string) (*Collection, error)) (*Collection, error) {
 	return col, nil
 }
 
+// CreateOptions specifies options for creating a collection.
+type CreateOptions struct {
+	// MediaStore is the store to keep media files in. If nil, they are kept
+	// in a temporary directory, or in memory with CreateInMemory.
+	MediaStore MediaStore
+	// Clock tells the time for the IDs and timestamps written to the
+	// collection. If nil, the system clock is used.
+	Clock Clock
+	// DeterministicGUIDs derives the GUIDs of new notes from their ID,
+	// notetype and fields instead of picking them at random. Along with a
+	// fixed Clock, adding the same notes then gives them the same GUIDs.
+	// Notes whose content changes get a new GUID, so set the GUIDs of notes
+	// that must be matched across builds explicitly.
+	DeterministicGUIDs bool
+}
+
 // Create creates a new, empty collection.
 func Create() (*Collection, error) {
+	return CreateWithOptions(nil)
+}
+
+// CreateWithOptions is like Create, but creates the collection with the given
+// options.
+func CreateWithOptions(opts *CreateOptions) (*Collection, error) {
 	return inTempDir(func(dir string) (*Collection, error) {
 		db, err := sqlite3Open(databasePath(dir) + "?_journal=WAL&mode=rwc")
 		if err != nil {
 			return nil, err
 		}
-
-		if err = sqlExecute(db, schemaQuery); err != nil {
+		if err = initDatabase(db); err != nil {
 			_ = db.Close()
 			return nil, err
 		}
 
-		for _, fn := range []func(sqlExecer) error{
-			initDefaultConfigs, addDefaultDeckConfig, addDefaultDeck, addDefaultNotetypes,
-		} {
-			if err = fn(db); err != nil {
-				_ = db.Close()
-				return nil, err
+		var clock Clock
+		if opts != nil {
+			clock = opts.Clock
+		}
+		col, err := newCollection(db, dir, true, clock)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		if opts != nil {
+			if opts.MediaStore != nil {
+				col.store = opts.MediaStore
 			}
+			col.ids.guids = opts.DeterministicGUIDs
 		}
-
-		return newCollection(db, dir, true)
+		return col, nil
 	})
 }
 
+// initDatabase creates the schema and default content of a new collection.
+func initDatabase(db *sql.DB) error {
+	if err := sqlExecute(db, schemaQuery); err != nil {
+		return err
+	}
+	for _, fn := range []func(sqlExecer) error{
+		initDefaultConfigs, addDefaultDeckConfig, addDefaultDeck, addDefaultNotetypes,
+	} {
+		if err := fn(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenOptions specifies options for opening a collection.
+type OpenOptions struct {
+	// ReadOnly opens the database read-only. Every method that would modify
+	// the collection returns ErrReadOnly.
+	// A directory loaded read-only with LoadDir is treated as immutable: it
+	// is neither locked nor checkpointed, so changes pending in a
+	// write-ahead log are not seen, and a collection using the legacy schema
+	// cannot be loaded since it would need to be upgraded.
+	ReadOnly bool
+	// Unpack holds the limits to enforce when unpacking a package.
+	Unpack *UnpackOptions
+	// LazyMedia extracts only the database when opening a package. Media
+	// files are read from the package on demand, and copied across without
+	// being recompressed when the collection is written in the same format.
+	// With ReadFrom, the io.ReaderAt must remain valid until the collection
+	// is closed.
+	LazyMedia bool
+	// MediaStore is the store to keep media files in. If nil, the media
+	// folder of the directory is used with LoadDir, and a temporary
+	// directory with Open and ReadFrom, which unpack media files into it.
+	// ReadFromMemory keeps them in memory.
+	MediaStore MediaStore
+	// Progress receives progress reports while the package is unpacked, if
+	// not nil.
+	Progress ProgressFunc
+	// Clock tells the time for the IDs and timestamps written to the
+	// collection. If nil, the system clock is used.
+	Clock Clock
+	// DeterministicGUIDs derives the GUIDs of new notes as with
+	// CreateOptions.
+	DeterministicGUIDs bool
+}
+
+// ErrReadOnly is returned when modifying a collection opened read-only.
+var ErrReadOnly = errors.New("collection is read-only")
+
 // Open opens a collection from a file.
+// Changes can be written back to the file with Save.
 func Open(path string) (*Collection, error) {
-	return inTempDir(func(dir string) (*Collection, error) {
-		r, err := zip.OpenReader(path)
-		if err != nil {
-			return nil, err
-		}
-		defer r.Close() //nolint:errcheck
+	return OpenWithOptions(path, nil)
+}
 
-		if err = Unpack(&r.Reader, dir); err != nil {
-			return nil, err
-		}
+// OpenWithOptions is like Open, but opens the collection with the given
+// options.
+func OpenWithOptions(path string, opts *OpenOptions) (*Collection, error) {
+	return OpenContext(context.Background(), path, opts)
+}
 
-		return loadDir(dir, true)
-	})
+// OpenContext is like OpenWithOptions, but stops unpacking the file once ctx
+// is done.
+func OpenContext(ctx context.Context, path string, opts *OpenOptions) (*Collection, error) {
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		return nil, err
+	}
+	col, err := openPackage(ctx, &r.Reader, r, opts)
+	if err != nil || col.pkg == nil {
+		_ = r.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	col.source = source
+	return col, nil
 }
 
 // ReadFrom reads a collection from an io.ReaderAt.
 func ReadFrom(r io.ReaderAt, size int64) (*Collection, error) {
+	return ReadFromWithOptions(r, size, nil)
+}
+
+// ReadFromWithOptions is like ReadFrom, but reads the collection with the
+// given options.
+func ReadFromWithOptions(r io.ReaderAt, size int64, opts *OpenOptions) (*Collection, error) {
+	return ReadFromContext(context.Background(), r, size, opts)
+}
+
+// ReadFromContext is like ReadFromWithOptions, but stops unpacking once ctx
+// is done.
+func ReadFromContext(ctx context.Context, r io.ReaderAt, size int64, opts *OpenOptions) (*Collection, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return openPackage(ctx, zr, nil, opts)
+}
+
+// openPackage is an internal helper to open a collection from a zip file.
+// If media files are read lazily, the collection closes closer when it is
+// closed itself.
+func openPackage(ctx context.Context, r *zip.Reader, closer io.Closer, opts *OpenOptions) (*Collection, error) {
 	return inTempDir(func(dir string) (*Collection, error) {
-		zr, err := zip.NewReader(r, size)
+		if opts == nil || !opts.LazyMedia {
+			meta, err := unpack(ctx, r, dir, unpackOptions(opts))
+			if err != nil {
+				return nil, err
+			}
+			col, err := loadDir(dir, true, opts)
+			if err != nil {
+				return nil, err
+			}
+			col.version = meta.Version
+			return col, nil
+		}
+
+		pkg, err := unpackLazy(ctx, r, dir, unpackOptions(opts))
 		if err != nil {
 			return nil, err
 		}
-
-		if err = Unpack(zr, dir); err != nil {
+		col, err := loadDir(dir, true, opts)
+		if err != nil {
 			return nil, err
 		}
-
-		return loadDir(dir, true)
+		pkg.closer = closer
+		col.pkg = pkg
+		col.version = pkg.meta.Version
+		return col, nil
 	})
 }
 
+// unpackOptions returns the unpack options held in opts, if any, directing
+// media files to the media store of opts and progress reports to its progress
+// function.
+func unpackOptions(opts *OpenOptions) *UnpackOptions {
+	if opts == nil {
+		return nil
+	}
+	if opts.MediaStore == nil && opts.Progress == nil {
+		return opts.Unpack
+	}
+	var unpackOpts UnpackOptions
+	if opts.Unpack != nil {
+		unpackOpts = *opts.Unpack
+	}
+	if opts.MediaStore != nil {
+		unpackOpts.MediaStore = opts.MediaStore
+	}
+	if opts.Progress != nil {
+		unpackOpts.Progress = opts.Progress
+	}
+	return &unpackOpts
+}
+
 // LoadDir loads a collection from a directory
 func LoadDir(dir string) (*Collection, error) {
-	return loadDir(dir, false)
+	return LoadDirWithOptions(dir, nil)
+}
+
+// LoadDirWithOptions is like LoadDir, but loads the collection with the given
+// options.
+func LoadDirWithOptions(dir string, opts *OpenOptions) (*Collection, error) {
+	return loadDir(dir, false, opts)
 }
 
 // loadDir is an internal helper to load a collection from a directory.
-func loadDir(dir string, temp bool) (*Collection, error) {
-	db, err := sqlite3Open(databasePath(dir) + "?_journal=WAL")
+func loadDir(dir string, temp bool, opts *OpenOptions) (*Collection, error) {
+	var clock Clock
+	if opts != nil {
+		clock = opts.Clock
+	}
+	readOnly := opts != nil && opts.ReadOnly
+	db, err := openDatabase(databasePath(dir), temp, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	col, err := newCollection(db, dir, temp, clock)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	col.readOnly = readOnly
+	if opts != nil {
+		if opts.MediaStore != nil {
+			col.store = opts.MediaStore
+		}
+		col.ids.guids = opts.DeterministicGUIDs
+	}
+	return col, nil
+}
+
+// openDatabase opens the database of a collection, upgrading it to the
+// current schema if required.
+func openDatabase(path string, temp, readOnly bool) (*sql.DB, error) {
+	if !readOnly {
+		db, err := sqlite3Open(path + "?_journal=WAL")
+		if err != nil {
+			return nil, err
+		}
+		if err = upgradeSchema(db); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+
+	if temp {
+		// The database is a private copy, so it can be upgraded before it
+		// is reopened read-only.
+		if err := upgradeDatabase(path); err != nil {
+			return nil, err
+		}
+	}
+	dsn, err := readOnlyDSN(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sqlite3Open(dsn)
 	if err != nil {
 		return nil, err
 	}
-	return newCollection(db, dir, temp)
+	if err = checkSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// WriteOptions specifies options for writing a collection as a package.
+type WriteOptions struct {
+	// Version is the package format to write. The zero value selects
+	// pb.PackageMetadata_VERSION_LATEST.
+	Version pb.PackageMetadata_Version
+	// Progress receives progress reports, if not nil.
+	Progress ProgressFunc
+	// Deterministic writes the package in the deterministic mode of
+	// PackOptions.
+	Deterministic bool
 }
 
 // WriteTo writes the collection to an io.Writer.
 func (c *Collection) WriteTo(w io.Writer) (int64, error) {
+	return c.WritePackage(w, nil)
+}
+
+// WritePackage writes the collection to an io.Writer using the given options.
+func (c *Collection) WritePackage(w io.Writer, opts *WriteOptions) (int64, error) {
+	return c.WritePackageContext(context.Background(), w, opts)
+}
+
+// WritePackageContext is like WritePackage, but stops writing once ctx is
+// done.
+func (c *Collection) WritePackageContext(ctx context.Context, w io.Writer, opts *WriteOptions) (int64, error) {
 	if err := c.flush(); err != nil {
 		return 0, err
 	}
+	var packOpts PackOptions
+	if opts != nil {
+		packOpts.Version = opts.Version
+		packOpts.Progress = opts.Progress
+		packOpts.Deterministic = opts.Deterministic
+	}
 	sw := &statsWriter{w: w}
 	zw := zip.NewWriter(sw)
-	if err := Pack(zw, c.dir); err != nil {
+	if err := pack(ctx, zw, c.database(), c.store, c.pkg, &packOpts); err != nil {
 		return sw.n, err
 	}
 	return sw.n, zw.Close()
@@ -143,23 +427,111 @@ func (sw *statsWriter) Write(p []byte) (int, error) {
 }
 
 // SaveAs saves the collection to a file.
+// The file is replaced atomically, so it is never left partially written.
 func (c *Collection) SaveAs(path string) error {
-	f, err := os.Create(path)
+	return c.SavePackage(path, nil)
+}
+
+// SavePackage saves the collection to a file using the given options.
+// The file is replaced atomically, so it is never left partially written.
+func (c *Collection) SavePackage(path string, opts *WriteOptions) error {
+	return c.SavePackageContext(context.Background(), path, opts)
+}
+
+// SavePackageContext is like SavePackage, but stops writing once ctx is done,
+// leaving the file untouched.
+func (c *Collection) SavePackageContext(ctx context.Context, path string, opts *WriteOptions) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := c.WritePackageContext(ctx, w, opts)
+		return err
+	})
+}
+
+// Save writes the collection back to the file it was opened from, in the
+// format it was read in. Nothing is written if the collection has not been
+// modified since it was opened or last saved.
+// Collections loaded with LoadDir or OpenProfile are modified in place, so
+// Save only flushes them to disk. Collections that were created in memory or
+// read from an io.ReaderAt have no source file and return ErrNoSource.
+func (c *Collection) Save() error {
+	if c.source == "" {
+		if c.temp || c.mem != nil {
+			return ErrNoSource
+		}
+		return c.flush()
+	}
+	if !c.dirty.Swap(false) {
+		return nil
+	}
+	err := c.SavePackage(c.source, &WriteOptions{Version: c.version})
+	if err != nil {
+		c.dirty.Store(true)
+	}
+	return err
+}
+
+// writeFileAtomic writes a file through fn by writing a temporary file in the
+// same directory, syncing it and renaming it over path.
+func writeFileAtomic(path string, fn func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer f.Close() //nolint:errcheck
+	defer os.Remove(f.Name()) //nolint:errcheck
+	defer f.Close()           //nolint:errcheck
 
-	_, err = c.WriteTo(f)
-	return err
+	mode := fs.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+
+	if err = fn(f); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory so that the rename survives a crash. This is not
+	// supported on every platform, so failures are ignored.
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// DumpOptions specifies options for dumping a collection to a directory.
+type DumpOptions struct {
+	// Progress receives progress reports, if not nil.
+	Progress ProgressFunc
 }
 
 // DumpTo dumps the collection to a directory.
 func (c *Collection) DumpTo(dir string) error {
+	return c.DumpToWithOptions(dir, nil)
+}
+
+// DumpToWithOptions is like DumpTo, but dumps the collection with the given
+// options.
+func (c *Collection) DumpToWithOptions(dir string, opts *DumpOptions) error {
 	if err := c.flush(); err != nil {
 		return err
 	}
-	return backup(c.dir, dir)
+	var prog *progress
+	if opts != nil {
+		prog = newProgress(opts.Progress)
+	}
+	return backup(c.database(), c.store, c.pkg, dir, prog)
 }
 
 // Close closes the collection and cleans up temporary files.
@@ -169,11 +541,62 @@ func (c *Collection) Close() error {
 			_ = os.RemoveAll(c.dir)
 		}
 	}()
-	return c.db.Close()
+	var err error
+	if c.mem != nil {
+		err = c.mem.Close()
+	}
+	err = errors.Join(err, c.db.Close())
+	if c.profile != nil {
+		err = errors.Join(err, c.profile.close())
+	}
+	if c.pkg != nil {
+		err = errors.Join(err, c.pkg.close())
+	}
+	return err
+}
+
+// transact runs fn in a transaction, bumping the modification time of the
+// collection and marking it as modified if fn succeeds.
+// It returns ErrReadOnly if the collection was opened read-only.
+func (c *Collection) transact(fn func(tx *sql.Tx) error) error {
+	return c.transactContext(context.Background(), fn)
+}
+
+// transactContext is like transact, but rolls the transaction back once ctx
+// is done.
+func (c *Collection) transactContext(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	mod := c.ids.now()
+	err := sqlTransact(ctx, c.db, func(tx *sql.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return sqlExecute(tx, setColModQuery, mod.UnixMilli())
+	})
+	if err != nil {
+		return err
+	}
+	c.props.mod = mod
+	c.dirty.Store(true)
+	return nil
+}
+
+// database returns the database of the collection, for it to be copied.
+func (c *Collection) database() databaseSource {
+	if c.mem != nil {
+		return memoryDatabase{conn: c.mem}
+	}
+	return fileDatabase(c.path)
 }
 
-// flush flushes the database write-ahead log.
+// flush flushes the database write-ahead log. Read-only and in-memory
+// collections have nothing to flush.
 func (c *Collection) flush() error {
+	if c.readOnly || c.mem != nil {
+		return nil
+	}
 	return sqlExecute(c.db, "PRAGMA wal_checkpoint(FULL)")
 }
 
@@ -203,13 +626,27 @@ type props struct {
 	scm time.Time
 	ls  time.Time
 	usn int64
+	// crt is the creation time of the collection, from which the days of
+	// the scheduler are counted.
+	crt time.Time
+}
+
+// daysElapsed returns the number of days elapsed between the creation of the
+// collection and t, which the scheduler uses as the number of today.
+func (p *props) daysElapsed(t time.Time) int64 {
+	return int64(t.Sub(p.crt) / (24 * time.Hour))
+}
+
+// dayCutoff returns the time the day of t ends at.
+func (p *props) dayCutoff(t time.Time) time.Time {
+	return p.crt.Add(time.Duration(p.daysElapsed(t)+1) * 24 * time.Hour)
 }
 
 // loadProps loads the properties of a collection from the database.
 func loadProps(db *sql.DB) (*props, error) {
 	fn := func(_ sqlQueryer, row sqlRow) (*props, error) {
-		var mod, scm, ls, usn int64
-		if err := row.Scan(&mod, &scm, &ls, &usn); err != nil {
+		var mod, scm, ls, usn, crt int64
+		if err := row.Scan(&mod, &scm, &ls, &usn, &crt); err != nil {
 			return nil, err
 		}
 		return &props{
@@ -217,6 +654,7 @@ func loadProps(db *sql.DB) (*props, error) {
 			scm: time.UnixMilli(scm),
 			ls:  time.UnixMilli(ls),
 			usn: usn,
+			crt: time.Unix(crt, 0),
 		}, nil
 	}
 	return sqlGet(db, fn, getColQuery)
@@ -0,0 +1,211 @@
+package anki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSave tests saving a collection back to the file it was opened from.
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "collection.colpkg")
+	src, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close() //nolint:errcheck
+	if err = src.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+
+	col, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	// An unmodified collection is not written.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err = os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.ModTime().Equal(old) {
+		t.Errorf("file of an unmodified collection was written")
+	}
+
+	// A failed write leaves the file as it was.
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = col.AddDeck(&Deck{Name: "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = col.SavePackageContext(ctx, path, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SavePackageContext() error = %v, want context.Canceled", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("file changed by a failed write: %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %d entries after a failed write, want 1: %v", len(entries), err)
+	}
+
+	if err = col.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.Close(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saved.Close() //nolint:errcheck
+	found := false
+	for deck, err := range saved.ListDecks(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = found || deck.Name == "Test"
+	}
+	if !found {
+		t.Error("saved deck not found")
+	}
+}
+
+// TestReadOnly tests that the methods modifying a collection opened read-only
+// return ErrReadOnly.
+func TestReadOnly(t *testing.T) {
+	src, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = src.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+	if err = src.AddNote(1, note); err != nil {
+		t.Fatal(err)
+	}
+	if err = src.SetTag(&Tag{Name: "tag"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = src.WriteMedia("a.png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	var card *Card
+	for c, err := range src.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		card = c
+	}
+	var buf bytes.Buffer
+	if _, err = src.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	mediaPath := filepath.Join(t.TempDir(), "b.png")
+	if err = os.WriteFile(mediaPath, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	col, err := ReadFromWithOptions(bytes.NewReader(buf.Bytes()), int64(buf.Len()), &OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	media, err := col.GetMedia("a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{name: "AddDeck", fn: func() error { return col.AddDeck(&Deck{Name: "Deck"}) }},
+		{name: "AddDeckConfig", fn: func() error {
+			return col.AddDeckConfig(&DeckConfig{Name: "Config", Config: DefaultDeckConfig()})
+		}},
+		{name: "DeleteDeckConfig", fn: func() error { return col.DeleteDeckConfig(1) }},
+		{name: "SetDeck", fn: func() error { return col.SetDeck([]int64{card.ID}, 1) }},
+		{name: "AddNotetype", fn: func() error {
+			nt := *notetype
+			nt.ID, nt.Name = 0, "Copy"
+			return col.AddNotetype(&nt)
+		}},
+		{name: "UpdateNotetype", fn: func() error { return col.UpdateNotetype(notetype) }},
+		{name: "DeleteNotetype", fn: func() error { return col.DeleteNotetype(notetype.ID) }},
+		{name: "AddNote", fn: func() error {
+			return col.AddNote(1, &Note{NotetypeID: notetype.ID, Fields: []string{"a", "b"}})
+		}},
+		{name: "AddNotes", fn: func() error {
+			return col.AddNotes(1, []*Note{{NotetypeID: notetype.ID, Fields: []string{"a", "b"}}})
+		}},
+		{name: "NewNoteWriter", fn: func() error {
+			_, err := col.NewNoteWriter(1)
+			return err
+		}},
+		{name: "UpdateNote", fn: func() error { return col.UpdateNote(note) }},
+		{name: "DeleteNote", fn: func() error { return col.DeleteNote(note.ID) }},
+		{name: "SetTag", fn: func() error { return col.SetTag(&Tag{Name: "other"}) }},
+		{name: "DeleteTag", fn: func() error { return col.DeleteTag("tag") }},
+		{name: "SetConfig", fn: func() error { return col.SetConfig(&Config{Key: "key", Value: []byte("1")}) }},
+		{name: "DeleteConfig", fn: func() error { return col.DeleteConfig("curModel") }},
+		{name: "AddMedia", fn: func() error { return col.AddMedia("b.png", mediaPath) }},
+		{name: "WriteMedia", fn: func() error { return col.WriteMedia("b.png", []byte("png")) }},
+		{name: "CopyMedia", fn: func() error { return col.CopyMedia(media) }},
+		{name: "CreateMedia", fn: func() error {
+			_, err := col.CreateMedia("b.png")
+			return err
+		}},
+		{name: "DeleteMedia", fn: func() error { return col.DeleteMedia("a.png") }},
+		{name: "AddReviewLog", fn: func() error {
+			return col.AddReviewLog(&ReviewLog{CardID: card.ID, Ease: ReviewEaseGood})
+		}},
+		{name: "DeleteReviewLogs", fn: func() error { return col.DeleteReviewLogs([]int64{1}) }},
+		{name: "AnswerCard", fn: func() error { return col.AnswerCard(card.ID, ReviewEaseGood, time.Second) }},
+		{name: "EnableFSRS", fn: col.EnableFSRS},
+		{name: "DisableFSRS", fn: col.DisableFSRS},
+		{name: "EnableFullTextSearch", fn: col.EnableFullTextSearch},
+		{name: "DisableFullTextSearch", fn: col.DisableFullTextSearch},
+		{name: "OptimizeFSRS", fn: func() error {
+			_, err := col.OptimizeFSRS(1, nil)
+			return err
+		}},
+		{name: "Import", fn: func() error {
+			_, err := col.Import(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("%s() error = %v, want ErrReadOnly", tt.name, err)
+			}
+		})
+	}
+
+	if _, err = col.GetNote(note.ID); err != nil {
+		t.Errorf("GetNote() error = %v", err)
+	}
+}
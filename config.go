@@ -1,8 +1,12 @@
 package anki
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"iter"
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -16,7 +20,9 @@ type Config struct {
 
 // SetConfig sets a configuration entry.
 func (c *Collection) SetConfig(config *Config) error {
-	return setConfig(c.db, config)
+	return c.transact(func(tx *sql.Tx) error {
+		return setConfig(tx, config)
+	})
 }
 
 // setConfig sets a configuration entry.
@@ -37,7 +43,25 @@ func (c *Collection) GetConfig(key string) (*Config, error) {
 
 // DeleteConfig deletes a configuration entry by key.
 func (c *Collection) DeleteConfig(key string) error {
-	return sqlExecute(c.db, deleteConfigQuery, key)
+	return c.transact(func(tx *sql.Tx) error {
+		return sqlExecute(tx, deleteConfigQuery, key)
+	})
+}
+
+// getConfigValue gets the JSON value of a configuration entry, or def if the
+// entry is missing.
+func getConfigValue[T any](q sqlQueryer, key string, def T) (T, error) {
+	config, err := sqlGet(q, scanConfig, getConfigQuery+" WHERE key = ?", key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return def, nil
+	} else if err != nil {
+		return def, err
+	}
+	var val T
+	if err = json.Unmarshal(config.Value, &val); err != nil {
+		return def, err
+	}
+	return val, nil
 }
 
 // ListConfigsOptions specifies options for listing configuration entries.
@@ -61,7 +85,7 @@ func scanConfig(_ sqlQueryer, row sqlRow) (*Config, error) {
 
 // initDefaultConfigs initializes default configuration entries.
 func initDefaultConfigs(e sqlExecer) error {
-	for key, value := range map[string]any{
+	defaults := map[string]any{
 		"activeDecks":    []int64{1},
 		"curDeck":        int64(1),
 		"newSpread":      int64(0),
@@ -78,8 +102,11 @@ func initDefaultConfigs(e sqlExecer) error {
 		"schedVer":       int64(2),
 		"creationOffset": int64(0),
 		"sched2021":      true,
-	} {
-		b, err := json.Marshal(value)
+	}
+	// The entries are inserted in a fixed order, so that the database does
+	// not depend on the iteration order of the map.
+	for _, key := range slices.Sorted(maps.Keys(defaults)) {
+		b, err := json.Marshal(defaults[key])
 		if err != nil {
 			return err
 		}
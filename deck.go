@@ -6,6 +6,7 @@ import (
 	"iter"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lftk/anki/pb"
 	"google.golang.org/protobuf/proto"
@@ -73,10 +74,17 @@ func (dn DeckName) HumanString() string {
 
 const deckNameSeparator = "\x1f"
 
+// isDescendantOf reports whether the deck is a subdeck of parent, at any
+// depth. Names are compared case-insensitively, like in the decks table.
+func (dn DeckName) isDescendantOf(parent DeckName) bool {
+	fold := func(s string) string { return strings.Map(unicode.ToLower, s) }
+	return strings.HasPrefix(fold(string(dn)), fold(string(parent)+deckNameSeparator))
+}
+
 // AddDeck adds a new deck to the collection.
 // If the parent decks do not exist, they will be created automatically.
 func (c *Collection) AddDeck(deck *Deck) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.transact(func(tx *sql.Tx) error {
 		var query = getDeckQuery + " WHERE name = ?"
 
 		// Ensure all parent decks exist.
@@ -95,25 +103,26 @@ func (c *Collection) AddDeck(deck *Deck) error {
 			parent := &Deck{
 				ID:       0, // Let the database assign an ID.
 				Name:     name,
-				Modified: time.Now(),
+				Modified: c.ids.now(),
 				USN:      deck.USN,
 				Common:   deck.Common,
 				Kind:     deck.Kind,
 			}
-			if err := addDeck(tx, parent); err != nil {
+			if err := addDeck(tx, parent, c.ids); err != nil {
 				return err
 			}
 		}
 
-		return addDeck(tx, deck)
+		return addDeck(tx, deck, c.ids)
 	})
 }
 
 // addDeck is a helper function to add a deck to the database.
-func addDeck(e sqlExecer, deck *Deck) error {
+// If the deck's ID is zero or already taken, a new ID is allocated from ids.
+func addDeck(e sqlExecer, deck *Deck, ids *idAllocator) error {
 	id := deck.ID
 	if id == 0 {
-		id = time.Now().UnixMilli()
+		id = ids.next()
 	}
 
 	if deck.Common == nil {
@@ -143,6 +152,7 @@ func addDeck(e sqlExecer, deck *Deck) error {
 	id, err = sqlInsert(e, addDeckQuery, args...)
 	if err == nil {
 		deck.ID = id
+		ids.use(id)
 	}
 	return err
 }
@@ -216,5 +226,5 @@ func addDefaultDeck(e sqlExecer) error {
 		USN:      0,
 		Common:   DefaultDeckCommon(),
 		Kind:     NormalDeckKind(1), // Use default deck config ID.
-	})
+	}, nil)
 }
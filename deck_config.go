@@ -1,6 +1,7 @@
 package anki
 
 import (
+	"database/sql"
 	"iter"
 	"time"
 
@@ -69,14 +70,17 @@ func DefaultDeckConfig() *pb.DeckConfig {
 
 // AddDeckConfig adds a new deck configuration to the collection.
 func (c *Collection) AddDeckConfig(config *DeckConfig) error {
-	return addDeckConfig(c.db, config)
+	return c.transact(func(tx *sql.Tx) error {
+		return addDeckConfig(tx, config, c.ids)
+	})
 }
 
 // addDeckConfig is a helper function to add a deck configuration to the database.
-func addDeckConfig(e sqlExecer, config *DeckConfig) error {
+// If the configuration's ID is zero, a new ID is allocated from ids.
+func addDeckConfig(e sqlExecer, config *DeckConfig, ids *idAllocator) error {
 	id := config.ID
 	if id == 0 {
-		id = time.Now().UnixMilli()
+		id = ids.next()
 	}
 
 	if config.Config == nil {
@@ -97,18 +101,26 @@ func addDeckConfig(e sqlExecer, config *DeckConfig) error {
 	id, err = sqlInsert(e, addDeckConfigQuery, args...)
 	if err == nil {
 		config.ID = id
+		ids.use(id)
 	}
 	return err
 }
 
 // GetDeckConfig gets a deck configuration by its ID.
 func (c *Collection) GetDeckConfig(id int64) (*DeckConfig, error) {
-	return sqlGet(c.db, scanDeckConfig, getDeckConfigQuery+" WHERE id = ?", id)
+	return getDeckConfig(c.db, id)
+}
+
+// getDeckConfig gets a deck configuration by its ID.
+func getDeckConfig(q sqlQueryer, id int64) (*DeckConfig, error) {
+	return sqlGet(q, scanDeckConfig, getDeckConfigQuery+" WHERE id = ?", id)
 }
 
 // DeleteDeckConfig deletes a deck configuration by its ID.
 func (c *Collection) DeleteDeckConfig(id int64) error {
-	return sqlExecute(c.db, deleteDeckConfigQuery, id)
+	return c.transact(func(tx *sql.Tx) error {
+		return sqlExecute(tx, deleteDeckConfigQuery, id)
+	})
 }
 
 // ListDeckConfigsOptions specifies options for listing deck configurations.
@@ -143,5 +155,5 @@ func addDefaultDeckConfig(e sqlExecer) error {
 		Modified: timeZero(),
 		USN:      0,
 		Config:   DefaultDeckConfig(),
-	})
+	}, nil)
 }
@@ -0,0 +1,309 @@
+package anki
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/lftk/anki/pb"
+)
+
+// ExportDeckOptions specifies options for exporting a deck.
+type ExportDeckOptions struct {
+	// IncludeChildren also exports the subdecks of the deck.
+	IncludeChildren bool
+	// ResetScheduling resets the exported cards to new and leaves out the
+	// review log, so the recipient starts studying from scratch.
+	ResetScheduling bool
+	// ExcludeMedia leaves out the media files referenced by the exported notes.
+	ExcludeMedia bool
+	// Version is the package format to write. The zero value selects
+	// pb.PackageMetadata_VERSION_LATEST.
+	Version pb.PackageMetadata_Version
+	// Deterministic writes the package in the deterministic mode of
+	// PackOptions.
+	Deterministic bool
+}
+
+// ExportDeck writes a deck to an io.Writer as a package that can be shared.
+// The package holds only the notes and cards of the deck, along with the
+// notetypes, deck configs and media files they use.
+func (c *Collection) ExportDeck(w io.Writer, deckID int64, opts *ExportDeckOptions) (int64, error) {
+	return c.ExportDeckContext(context.Background(), w, deckID, opts)
+}
+
+// ExportDeckContext is like ExportDeck, but stops exporting once ctx is done.
+func (c *Collection) ExportDeckContext(ctx context.Context, w io.Writer, deckID int64, opts *ExportDeckOptions) (int64, error) {
+	if opts == nil {
+		opts = &ExportDeckOptions{}
+	}
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+
+	dir, err := os.MkdirTemp("", "anki-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	if err = copyDatabase(c.database(), databasePath(dir), nil); err != nil {
+		return 0, err
+	}
+
+	media, err := exportDeckDatabase(ctx, databasePath(dir), deckID, opts, c.ids)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = os.Mkdir(mediaDir(dir), 0755); err != nil {
+		return 0, err
+	}
+	store := NewDirMediaStore(mediaDir(dir))
+	for _, name := range media {
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+		if !validMediaName(name) {
+			continue
+		}
+		m, err := c.GetMedia(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		w, err := store.Create(name)
+		if err != nil {
+			return 0, err
+		}
+		if err = copyMedia(w, m); err != nil {
+			return 0, err
+		}
+	}
+
+	sw := &statsWriter{w: w}
+	zw := zip.NewWriter(sw)
+	if err = PackContext(ctx, zw, dir, &PackOptions{Version: opts.Version, Deterministic: opts.Deterministic}); err != nil {
+		return sw.n, err
+	}
+	return sw.n, zw.Close()
+}
+
+// exportDeckDatabase strips everything that does not belong to the exported
+// decks from a copy of the database, returning the names of the media files
+// the remaining notes reference. Missing parent decks are created with IDs
+// allocated from ids.
+func exportDeckDatabase(ctx context.Context, path string, deckID int64, opts *ExportDeckOptions, ids *idAllocator) ([]string, error) {
+	db, err := sqlite3Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+
+	var media []string
+	err = sqlTransact(ctx, db, func(tx *sql.Tx) error {
+		err := selectExportDecks(tx, deckID, opts.IncludeChildren, ids)
+		if err != nil {
+			return err
+		}
+
+		if err = sqlExecute(tx, exportDeckQuery); err != nil {
+			return err
+		}
+		if err = sqlExecute(tx, dropNoteIndexQuery); err != nil {
+			return err
+		}
+		if opts.ResetScheduling {
+			if err = sqlExecute(tx, resetSchedulingQuery); err != nil {
+				return err
+			}
+		}
+
+		if err = removeUnusedDeckConfigs(tx); err != nil {
+			return err
+		}
+		if err = removeUnusedTags(tx); err != nil {
+			return err
+		}
+
+		if !opts.ExcludeMedia {
+			media, err = referencedMedia(tx)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sqlExecute(db, "VACUUM"); err != nil {
+		return nil, err
+	}
+	return media, db.Close()
+}
+
+// selectExportDecks records the decks whose cards are exported, and their
+// parents, which are exported without their cards. Parents missing from the
+// collection are created, as AddDeck does.
+func selectExportDecks(tx *sql.Tx, deckID int64, children bool, ids *idAllocator) error {
+	deck, err := getDeck(tx, deckID)
+	if err != nil {
+		return err
+	}
+	if err = sqlExecute(tx, createExportDecksQuery); err != nil {
+		return err
+	}
+
+	deckIDs := []int64{deck.ID}
+	if children {
+		for child, err := range sqlSelectSeq(tx, scanDeck, getDeckQuery) {
+			if err != nil {
+				return err
+			}
+			if child.Name.isDescendantOf(deck.Name) {
+				deckIDs = append(deckIDs, child.ID)
+			}
+		}
+	}
+	for _, id := range deckIDs {
+		if err = sqlExecute(tx, addExportDeckQuery, id); err != nil {
+			return err
+		}
+	}
+
+	for name := deck.Name.Parent(); name != ""; name = name.Parent() {
+		parent, err := sqlGet(tx, scanDeck, getDeckQuery+" WHERE name = ?", name)
+		if errors.Is(err, sql.ErrNoRows) {
+			parent = &Deck{Name: name, Modified: ids.now()}
+			err = addDeck(tx, parent, ids)
+		}
+		if err != nil {
+			return err
+		}
+		if err = sqlExecute(tx, addExportParentDeckQuery, parent.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUnusedDeckConfigs removes the deck configs that no remaining deck
+// uses. The default deck config is always kept.
+func removeUnusedDeckConfigs(tx *sql.Tx) error {
+	configIDs := []int64{1}
+	for deck, err := range sqlSelectSeq(tx, scanDeck, getDeckQuery) {
+		if err != nil {
+			return err
+		}
+		if normal := deck.Kind.GetNormal(); normal != nil {
+			configIDs = append(configIDs, normal.ConfigId)
+		}
+	}
+	configs, err := sqlSelect(tx, scanDeckConfig, getDeckConfigQuery)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		if !slices.Contains(configIDs, config.ID) {
+			if err = sqlExecute(tx, deleteDeckConfigQuery, config.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeUnusedTags removes the tags that no remaining note uses.
+func removeUnusedTags(tx *sql.Tx) error {
+	used := make(map[string]struct{})
+	for note, err := range listNotes(tx, nil) {
+		if err != nil {
+			return err
+		}
+		for _, tag := range note.Tags {
+			used[strings.ToLower(tag)] = struct{}{}
+		}
+	}
+	tags, err := sqlSelect(tx, scanTag, getTagQuery)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, ok := used[strings.ToLower(tag.Name)]; !ok {
+			if err = sqlExecute(tx, deleteTagQuery, tag.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// referencedMedia returns the names of the media files referenced by notes,
+// and the files starting with an underscore referenced by their notetypes.
+func referencedMedia(q sqlQueryer) ([]string, error) {
+	var names []string
+	for note, err := range listNotes(q, nil) {
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range note.Fields {
+			names = append(names, mediaReferences(field)...)
+		}
+	}
+	for nt, err := range sqlSelectSeq(q, scanNotetype, getNotetypeQuery) {
+		if err != nil {
+			return nil, err
+		}
+		texts := []string{nt.Config.GetCss()}
+		for _, t := range nt.Templates {
+			texts = append(texts, t.Config.GetQFormat(), t.Config.GetAFormat())
+		}
+		for _, text := range texts {
+			names = append(names, notetypeMediaRe.FindAllString(text, -1)...)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+var (
+	soundTagRe      = regexp.MustCompile(`\[sound:(.+?)\]`)
+	notetypeMediaRe = regexp.MustCompile(`\b_[^\s"'()<>\\/]+\.\w+`)
+)
+
+// mediaReferences returns the names of the media files referenced in a field.
+func mediaReferences(field string) []string {
+	var names []string
+	for _, m := range htmlMediaTagRe.FindAllStringSubmatch(field, -1) {
+		names = append(names, m[1]+m[2]+m[3])
+	}
+	for _, m := range soundTagRe.FindAllStringSubmatch(field, -1) {
+		names = append(names, m[1])
+	}
+	for i, name := range names {
+		names[i] = unescapeMediaName(name)
+	}
+	return slices.DeleteFunc(names, func(name string) bool {
+		return name == "" || strings.Contains(name, "://")
+	})
+}
+
+// unescapeMediaName decodes the HTML and URL escapes in a media file name as
+// written in a field.
+func unescapeMediaName(name string) string {
+	name = html.UnescapeString(name)
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
@@ -0,0 +1,157 @@
+package anki
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// TestMediaReferences tests the mediaReferences function.
+func TestMediaReferences(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  []string
+	}{
+		{
+			name:  "no media",
+			field: "plain text",
+			want:  nil,
+		},
+		{
+			name:  "image and sound",
+			field: `<img src="a.png"> [sound:b.mp3]`,
+			want:  []string{"a.png", "b.mp3"},
+		},
+		{
+			name:  "escaped names",
+			field: `<img src="a%20b.png"><img src='c&amp;d.jpg'>`,
+			want:  []string{"a b.png", "c&d.jpg"},
+		},
+		{
+			name:  "remote image",
+			field: `<img src="https://example.com/a.png">`,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mediaReferences(tt.field)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("mediaReferences(%q) = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExportDeck tests exporting a deck with and without its subdecks, and
+// with a parent deck missing from the collection.
+func TestExportDeck(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	// The names of the decks look alike to LIKE patterns, which treat
+	// underscores as wildcards, and subdecks may name their parents in
+	// another case.
+	for _, name := range []DeckName{
+		JoinDeckName("A", "B_C", "D"),
+		JoinDeckName("A", "BxC", "E"),
+		JoinDeckName("a", "b_c", "F"),
+	} {
+		if err = col.AddDeck(&Deck{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decks := make(map[string]int64)
+	for deck, err := range col.ListDecks(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		decks[deck.Name.HumanString()] = deck.ID
+	}
+	for name, id := range decks {
+		note := &Note{NotetypeID: notetype.ID, Fields: []string{name, ""}}
+		if err = col.AddNote(id, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts *ExportDeckOptions
+		// deleteParent deletes the parent deck A from the collection first.
+		deleteParent bool
+		wantNotes    []string
+		wantDecks    []string
+	}{
+		{
+			name:      "deck only",
+			wantNotes: []string{"A::B_C"},
+			wantDecks: []string{"A", "A::B_C", "Default"},
+		},
+		{
+			name:      "with children",
+			opts:      &ExportDeckOptions{IncludeChildren: true},
+			wantNotes: []string{"A::B_C", "A::B_C::D", "a::b_c::F"},
+			wantDecks: []string{"A", "A::B_C", "A::B_C::D", "Default", "a::b_c::F"},
+		},
+		{
+			name:         "missing parent",
+			deleteParent: true,
+			wantNotes:    []string{"A::B_C"},
+			wantDecks:    []string{"A", "A::B_C", "Default"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.deleteParent {
+				if err := sqlExecute(col.db, "DELETE FROM decks WHERE id = ?", decks["A"]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var buf bytes.Buffer
+			if _, err := col.ExportDeck(&buf, decks["A::B_C"], tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadFromMemory(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close() //nolint:errcheck
+
+			var notes, deckNames []string
+			for note, err := range got.ListNotes(nil) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				notes = append(notes, note.Fields[0])
+			}
+			for deck, err := range got.ListDecks(nil) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				deckNames = append(deckNames, deck.Name.HumanString())
+			}
+			slices.Sort(notes)
+			slices.Sort(deckNames)
+			if !slices.Equal(notes, tt.wantNotes) {
+				t.Errorf("notes = %q, want %q", notes, tt.wantNotes)
+			}
+			if !slices.Equal(deckNames, tt.wantDecks) {
+				t.Errorf("decks = %q, want %q", deckNames, tt.wantDecks)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package anki
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// withoutCombining removes the combining marks of s after decomposing it, like
+// Anki does, so that accented letters match their base letters.
+func withoutCombining(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
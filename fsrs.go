@@ -0,0 +1,449 @@
+package anki
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/lftk/anki/pb"
+)
+
+// fsrsConfigKey is the configuration entry that enables FSRS, as in Anki.
+const fsrsConfigKey = "fsrs"
+
+// EnableFSRS makes the collection schedule cards with FSRS instead of SM-2.
+// Cards are then scheduled from their memory state, with the parameters and
+// desired retention of their deck configuration.
+func (c *Collection) EnableFSRS() error {
+	return c.setFSRS(true)
+}
+
+// DisableFSRS makes the collection schedule cards with SM-2 again.
+func (c *Collection) DisableFSRS() error {
+	return c.setFSRS(false)
+}
+
+// FSRSEnabled reports whether the collection schedules cards with FSRS.
+func (c *Collection) FSRSEnabled() (bool, error) {
+	return getConfigValue(c.db, fsrsConfigKey, false)
+}
+
+func (c *Collection) setFSRS(enabled bool) error {
+	return c.transact(func(tx *sql.Tx) error {
+		config := &Config{
+			Key:      fsrsConfigKey,
+			Value:    []byte("false"),
+			USN:      -1,
+			Modified: c.ids.now(),
+		}
+		if enabled {
+			config.Value = []byte("true")
+		}
+		return setConfig(tx, config)
+	})
+}
+
+// CardRetrievability returns the probability of recalling a card now, as
+// estimated by FSRS from the memory state of the card and the parameters of
+// its deck configuration. Cards without a memory state are estimated from
+// their reviews or, failing that, from their SM-2 schedule. Cards that were
+// never reviewed have a retrievability of 0.
+func (c *Collection) CardRetrievability(cardID int64) (float64, error) {
+	card, err := getCard(c.db, cardID)
+	if err != nil {
+		return 0, err
+	}
+	config, err := cardDeckConfig(c.db, card)
+	if err != nil {
+		return 0, err
+	}
+	w := newFSRSParams(config.Config)
+	memory, days, err := cardMemoryState(c.db, card, w, config.Config, c.props, c.ids.now())
+	if err != nil || memory == nil {
+		return 0, err
+	}
+	return w.retrievability(float64(days), memory.stability), nil
+}
+
+// Bounds of the memory state in FSRS.
+const (
+	fsrsMinStability  = 0.001
+	fsrsMaxStability  = 36500
+	fsrsMinDifficulty = 1
+	fsrsMaxDifficulty = 10
+)
+
+// memoryState is the memory state of a card in FSRS: its stability, the
+// number of days after which it is recalled with a probability of 90%, and
+// its difficulty, from 1 to 10.
+type memoryState struct {
+	stability  float64
+	difficulty float64
+}
+
+// fsrsParams are the 21 parameters of the FSRS-6 model.
+type fsrsParams []float64
+
+// defaultFSRSParams are the default parameters of FSRS-6.
+var defaultFSRSParams = fsrsParams{
+	0.212, 1.2931, 2.3065, 8.2956, 6.4133, 0.8334, 3.0194, 0.001, 1.8722, 0.1666, 0.796,
+	1.4835, 0.0614, 0.2629, 1.6483, 0.6014, 1.8729, 0.5425, 0.0912, 0.0658, 0.1542,
+}
+
+// newFSRSParams returns the parameters of a deck configuration: its FSRS-6
+// parameters or, failing that, its FSRS-5 or FSRS-4.5 ones, or the defaults.
+func newFSRSParams(config *pb.DeckConfig) fsrsParams {
+	for _, params := range [][]float32{
+		config.GetFsrsParams_6(),
+		config.GetFsrsParams_5(),
+		config.GetFsrsParams_4(),
+	} {
+		if len(params) > 0 {
+			return convertFSRSParams(params)
+		}
+	}
+	return defaultFSRSParams
+}
+
+// convertFSRSParams converts FSRS-4.5, FSRS-5 or FSRS-6 parameters to FSRS-6
+// ones, as the FSRS library of Anki does. FSRS-5 has no short-term stability
+// exponent, and a fixed decay of 0.5; FSRS-4.5 also has a linear initial
+// difficulty and no short-term stability at all. Invalid parameters fall
+// back to the defaults.
+func convertFSRSParams(params []float32) fsrsParams {
+	w := make(fsrsParams, len(params), len(defaultFSRSParams))
+	for i, p := range params {
+		w[i] = float64(p)
+	}
+	switch len(w) {
+	case 17:
+		w[4] += 2 * w[5]
+		w[5] = math.Log(3*w[5]+1) / 3
+		w[6] += 0.5
+		w = append(w, 0, 0, 0, 0.5)
+	case 19:
+		w = append(w, 0, 0.5)
+	case 21:
+	default:
+		return defaultFSRSParams
+	}
+	return w
+}
+
+// decay returns the decay of the forgetting curve.
+func (w fsrsParams) decay() float64 {
+	return w[20]
+}
+
+// factor returns the factor of the forgetting curve, such that the
+// retrievability is 90% after as many days as the stability.
+func (w fsrsParams) factor() float64 {
+	return math.Pow(0.9, -1/w.decay()) - 1
+}
+
+// retrievability returns the probability of recalling a card days after its
+// last review.
+func (w fsrsParams) retrievability(days, stability float64) float64 {
+	return math.Pow(1+w.factor()*days/stability, -w.decay())
+}
+
+// interval returns the number of days after which a card is recalled with
+// the desired retention.
+func (w fsrsParams) interval(stability, retention float64) float64 {
+	return stability / w.factor() * (math.Pow(retention, -1/w.decay()) - 1)
+}
+
+// initialDifficulty returns the difficulty of a new card answered with a
+// rating.
+func (w fsrsParams) initialDifficulty(rating ReviewEase) float64 {
+	return w[4] - math.Exp(w[5]*float64(rating-1)) + 1
+}
+
+// initialState returns the memory state of a new card answered with a rating.
+func (w fsrsParams) initialState(rating ReviewEase) memoryState {
+	return memoryState{
+		stability:  clampStability(w[rating-1]),
+		difficulty: clampDifficulty(w.initialDifficulty(rating)),
+	}
+}
+
+// nextState returns the memory state of a card answered with a rating days
+// after its last review.
+func (w fsrsParams) nextState(m memoryState, days float64, rating ReviewEase) memoryState {
+	s, d := m.stability, m.difficulty
+	var next float64
+	switch {
+	case days == 0:
+		// Reviews on the same day only affect the short-term stability.
+		inc := math.Exp(w[17]*(float64(rating)-3+w[18])) * math.Pow(s, -w[19])
+		if rating >= ReviewEaseGood {
+			inc = max(inc, 1)
+		}
+		next = s * inc
+	case rating == ReviewEaseAgain:
+		r := w.retrievability(days, s)
+		next = w[11] * math.Pow(d, -w[12]) * (math.Pow(s+1, w[13]) - 1) * math.Exp((1-r)*w[14])
+		next = min(next, s/math.Exp(w[17]*w[18]))
+	default:
+		r := w.retrievability(days, s)
+		bonus := 1.0
+		if rating == ReviewEaseHard {
+			bonus = w[15]
+		} else if rating == ReviewEaseEasy {
+			bonus = w[16]
+		}
+		next = s * (math.Exp(w[8])*(11-d)*math.Pow(s, -w[9])*(math.Exp((1-r)*w[10])-1)*bonus + 1)
+	}
+
+	// The difficulty changes less as it grows, and reverts to the initial
+	// difficulty of an easy card.
+	delta := -w[6] * float64(rating-3)
+	d += delta * (10 - d) / 9
+	d = w[7]*w.initialDifficulty(ReviewEaseEasy) + (1-w[7])*d
+
+	return memoryState{
+		stability:  clampStability(next),
+		difficulty: clampDifficulty(d),
+	}
+}
+
+// sm2State returns the memory state of a card scheduled by SM-2 with an ease
+// factor and an interval, given the retention achieved with SM-2.
+func (w fsrsParams) sm2State(easeFactor, interval, retention float64) memoryState {
+	s := max(interval, fsrsMinStability) * w.factor() / (math.Pow(retention, -1/w.decay()) - 1)
+	d := 11 - (easeFactor-1)/(math.Exp(w[8])*math.Pow(s, -w[9])*math.Expm1((1-retention)*w[10]))
+	return memoryState{
+		stability:  clampStability(s),
+		difficulty: clampDifficulty(d),
+	}
+}
+
+// replay returns the memory state of a card after its reviews, as selected by
+// fsrsReviews. If the reviews do not start from the first learning step of
+// the card, the memory state starts from the SM-2 schedule of the card before
+// the first review. It returns nil if there are no reviews.
+func (w fsrsParams) replay(logs []*ReviewLog, p *props, retention float64) *memoryState {
+	var m *memoryState
+	var last int64
+	for _, log := range logs {
+		day := p.daysElapsed(log.Time())
+		var next memoryState
+		switch {
+		case m != nil:
+			next = w.nextState(*m, float64(max(day-last, 0)), log.Ease)
+		case log.Type == ReviewTypeLearn:
+			next = w.initialState(log.Ease)
+		default:
+			ease := float64(log.Factor) / 1000
+			if ease == 0 {
+				ease = 2.5
+			}
+			interval := float64(max(log.LastInterval, 0))
+			next = w.nextState(w.sm2State(ease, interval, retention), interval, log.Ease)
+		}
+		m, last = &next, day
+	}
+	return m
+}
+
+// fsrsReviews selects the reviews FSRS learns from: the answers to a card
+// since it was last reset, and not before ignoreBefore. Manual changes to the
+// schedule, and reviews in filtered decks that do not reschedule cards, are
+// left out.
+func fsrsReviews(logs []*ReviewLog, ignoreBefore time.Time) []*ReviewLog {
+	var reviews []*ReviewLog
+	for _, log := range logs {
+		if log.Type == ReviewTypeManual && log.Interval == 0 {
+			// The card was reset to a new card.
+			reviews = reviews[:0]
+			continue
+		}
+		if log.Ease < ReviewEaseAgain || log.Ease > ReviewEaseEasy ||
+			log.Type == ReviewTypeManual || log.Type == ReviewTypeRescheduled ||
+			log.Type == ReviewTypeFiltered && log.Factor == 0 ||
+			log.Time().Before(ignoreBefore) {
+			continue
+		}
+		reviews = append(reviews, log)
+	}
+	return reviews
+}
+
+// ignoreRevlogsBefore returns the date before which a deck configuration
+// ignores reviews, or the zero time.
+func ignoreRevlogsBefore(config *pb.DeckConfig) time.Time {
+	t, err := time.ParseInLocation(time.DateOnly, config.GetIgnoreRevlogsBeforeDate(), time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// cardMemoryState returns the memory state of a card at time now, and the
+// number of days since its last review. The memory state is read from the
+// card data, or computed from the reviews or the SM-2 schedule of the card.
+// It is nil for cards that were never reviewed.
+func cardMemoryState(q sqlQueryer, card *Card, w fsrsParams, config *pb.DeckConfig, p *props, now time.Time) (*memoryState, int64, error) {
+	data := parseCardData(card.Data)
+	memory, hasMemory := data.memoryState()
+	last, hasLast := data.lastReview()
+
+	if !hasMemory || !hasLast {
+		logs, err := sqlSelect(q, scanReviewLog, getReviewLogQuery+" WHERE cid = ? ORDER BY id", card.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		logs = fsrsReviews(logs, ignoreRevlogsBefore(config))
+		if !hasMemory {
+			retention := float64(config.GetHistoricalRetention())
+			if memory = w.replay(logs, p, retention); memory == nil && card.Interval > 0 &&
+				(card.Type == CardTypeReview || card.Type == CardTypeRelearn) {
+				m := w.sm2State(float64(card.Factor)/1000, float64(card.Interval), retention)
+				memory = &m
+			}
+		}
+		if !hasLast {
+			if len(logs) > 0 {
+				last = logs[len(logs)-1].Time()
+			} else if card.Type == CardTypeReview {
+				due := card.Due
+				if card.OriginalDeckID != 0 {
+					due = card.OriginalDue
+				}
+				last = p.crt.Add(time.Duration(due-card.Interval) * 24 * time.Hour)
+			} else {
+				last = now
+			}
+		}
+	}
+
+	return memory, max(p.daysElapsed(now)-p.daysElapsed(last), 0), nil
+}
+
+// fsrsStates are the memory states of a card after each rating, and the
+// intervals they lead to, in days.
+type fsrsStates struct {
+	params    fsrsParams
+	retention float64
+	memory    [4]memoryState
+	intervals [4]float64
+}
+
+// newFSRSStates computes the next states of a card answered at time now.
+func newFSRSStates(q sqlQueryer, card *Card, config *pb.DeckConfig, p *props, now time.Time) (*fsrsStates, error) {
+	w := newFSRSParams(config)
+	memory, days, err := cardMemoryState(q, card, w, config, p, now)
+	if err != nil {
+		return nil, err
+	}
+	states := &fsrsStates{
+		params:    w,
+		retention: float64(config.GetDesiredRetention()),
+	}
+	for rating := ReviewEaseAgain; rating <= ReviewEaseEasy; rating++ {
+		next := w.initialState(rating)
+		if memory != nil {
+			next = w.nextState(*memory, float64(days), rating)
+		}
+		states.memory[rating-1] = next
+		states.intervals[rating-1] = w.interval(next.stability, states.retention)
+	}
+	return states, nil
+}
+
+// interval returns the interval of a card answered with a rating.
+func (s *fsrsStates) interval(rating ReviewEase) float64 {
+	return s.intervals[rating-1]
+}
+
+func clampStability(s float64) float64 {
+	return min(max(s, fsrsMinStability), fsrsMaxStability)
+}
+
+func clampDifficulty(d float64) float64 {
+	return min(max(d, fsrsMinDifficulty), fsrsMaxDifficulty)
+}
+
+// cardData is the data of a card, a JSON object where Anki keeps the memory
+// state of the card among other things.
+type cardData map[string]json.RawMessage
+
+// parseCardData parses the data of a card, which is empty if invalid.
+func parseCardData(s string) cardData {
+	data := make(cardData)
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &data); err != nil {
+			return make(cardData)
+		}
+	}
+	return data
+}
+
+// memoryState returns the memory state in the data, if any.
+func (d cardData) memoryState() (*memoryState, bool) {
+	var m memoryState
+	if !d.get("s", &m.stability) || !d.get("d", &m.difficulty) || m.stability <= 0 {
+		return nil, false
+	}
+	return &m, true
+}
+
+// setMemoryState sets the memory state in the data, along with the desired
+// retention and decay it was scheduled with, or removes them if m is nil.
+func (d cardData) setMemoryState(m *memoryState, retention, decay float64) {
+	if m == nil {
+		for _, key := range []string{"s", "d", "dr", "decay"} {
+			delete(d, key)
+		}
+		return
+	}
+	d.set("s", roundTo(m.stability, 4))
+	d.set("d", roundTo(m.difficulty, 3))
+	d.set("dr", roundTo(retention, 2))
+	d.set("decay", roundTo(decay, 4))
+}
+
+// lastReview returns the time of the last review in the data, if any.
+func (d cardData) lastReview() (time.Time, bool) {
+	var secs int64
+	if !d.get("lrt", &secs) {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// setLastReview sets the time of the last review in the data.
+func (d cardData) setLastReview(t time.Time) {
+	d.set("lrt", t.Unix())
+}
+
+func (d cardData) get(key string, v any) bool {
+	b, ok := d[key]
+	return ok && json.Unmarshal(b, v) == nil
+}
+
+func (d cardData) set(key string, v any) {
+	if b, err := json.Marshal(v); err == nil {
+		d[key] = b
+	}
+}
+
+// String returns the data as stored in a card, which is empty rather than an
+// empty object.
+func (d cardData) String() string {
+	if len(d) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(map[string]json.RawMessage(d))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// roundTo rounds x to a number of decimal places.
+func roundTo(x float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(x*p) / p
+}
@@ -0,0 +1,361 @@
+package anki
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// ErrNotEnoughReviews is returned when there are too few reviews to optimize
+// FSRS parameters.
+var ErrNotEnoughReviews = errors.New("not enough reviews")
+
+// OptimizeFSRSOptions specifies options for optimizing FSRS parameters.
+type OptimizeFSRSOptions struct {
+	// Progress receives progress reports while the parameters are trained,
+	// if not nil.
+	Progress ProgressFunc
+}
+
+// OptimizeFSRSResult is the result of optimizing FSRS parameters.
+type OptimizeFSRSResult struct {
+	// Params are the FSRS-6 parameters of the deck configuration.
+	Params []float32
+	// LogLoss is the mean log loss of the recalls predicted by the
+	// parameters, and RMSE the root mean square error between the predicted
+	// and actual recall rates, over reviews binned by predicted recall.
+	LogLoss float64
+	RMSE    float64
+	// Reviews is the number of reviews the parameters were evaluated on.
+	Reviews int
+	// Updated reports whether the parameters were updated, which they are
+	// not if the current parameters predict the reviews as well.
+	Updated bool
+}
+
+// Settings of the training of FSRS parameters.
+const (
+	fsrsMinReviews    = 8
+	fsrsEpochs        = 5
+	fsrsBatchSize     = 512
+	fsrsLearningRate  = 0.04
+	fsrsGradientDelta = 1e-5
+	fsrsRMSEBins      = 20
+)
+
+// fsrsParamBounds are the bounds of the parameters of FSRS-6 while training.
+var fsrsParamBounds = [21][2]float64{
+	{0.001, 100}, {0.001, 100}, {0.001, 100}, {0.001, 100},
+	{1, 10}, {0.001, 4}, {0.001, 4}, {0.001, 0.75},
+	{0, 4.5}, {0, 0.8}, {0.001, 3.5},
+	{0.001, 5}, {0.001, 0.25}, {0.001, 0.9}, {0, 4},
+	{0, 1}, {1, 6},
+	{0, 2}, {0, 2}, {0, 0.8}, {0.1, 0.8},
+}
+
+// OptimizeFSRS trains FSRS parameters on the reviews of the cards selected by
+// a deck configuration, as the Optimize button of Anki does, and saves them
+// as the FSRS-6 parameters of the configuration.
+//
+// The cards are selected by the search of the configuration or, if empty,
+// by preset:name -is:suspended. Reviews before the configuration ignores
+// reviews are left out, as are cards whose first learning step is not in
+// their reviews. Training starts from the default parameters, and the
+// current parameters are kept if they predict the reviews as well.
+func (c *Collection) OptimizeFSRS(configID int64, opts *OptimizeFSRSOptions) (*OptimizeFSRSResult, error) {
+	return c.OptimizeFSRSContext(context.Background(), configID, opts)
+}
+
+// OptimizeFSRSContext is like OptimizeFSRS, but stops the training with an
+// error once ctx is done.
+func (c *Collection) OptimizeFSRSContext(ctx context.Context, configID int64, opts *OptimizeFSRSOptions) (*OptimizeFSRSResult, error) {
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+	var prog *progress
+	if opts != nil {
+		prog = newProgress(opts.Progress)
+	}
+
+	q := sqlWithContext(ctx, c.db)
+	config, err := getDeckConfig(q, configID)
+	if err != nil {
+		return nil, err
+	}
+	search := config.Config.GetParamSearch()
+	if search == "" {
+		search = fmt.Sprintf(`preset:"%s" -is:suspended`, escapeSearch(config.Name))
+	}
+	histories, err := loadFSRSHistories(q, search, ignoreRevlogsBefore(config.Config), c.props, c.ids.now())
+	if err != nil {
+		return nil, err
+	}
+	if n := countFSRSPredictions(histories); n < fsrsMinReviews {
+		return nil, fmt.Errorf("%w: %d", ErrNotEnoughReviews, n)
+	}
+
+	w, err := trainFSRS(ctx, histories, prog)
+	if err != nil {
+		return nil, err
+	}
+	result := evaluateFSRS(w, histories)
+	if current := evaluateFSRS(newFSRSParams(config.Config), histories); current.LogLoss <= result.LogLoss {
+		return current, nil
+	}
+
+	err = c.transactContext(ctx, func(tx *sql.Tx) error {
+		config.Config.FsrsParams_6 = slices.Clone(result.Params)
+		config.USN = -1
+		config.Modified = c.ids.now()
+		return addDeckConfig(tx, config, c.ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Updated = true
+	return result, nil
+}
+
+// fsrsHistory is the history of a card, from its first learning step: its
+// ratings, and the number of days elapsed before each one.
+type fsrsHistory struct {
+	ratings []ReviewEase
+	days    []float64
+}
+
+// loadFSRSHistories loads the histories of the cards matching a search, from
+// the reviews made at or after ignoreBefore.
+func loadFSRSHistories(q sqlQueryer, search string, ignoreBefore time.Time, p *props, now time.Time) ([]*fsrsHistory, error) {
+	where, args, err := compileSearch(q, search, p, now)
+	if err != nil {
+		return nil, err
+	}
+	query := getReviewLogQuery + " WHERE cid IN (" + searchCardIDsQuery + " " + where + ") ORDER BY cid, id"
+	logs, err := sqlSelect(q, scanReviewLog, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var histories []*fsrsHistory
+	for len(logs) > 0 {
+		n := 1
+		for n < len(logs) && logs[n].CardID == logs[0].CardID {
+			n++
+		}
+		reviews := fsrsReviews(logs[:n], ignoreBefore)
+		logs = logs[n:]
+		if len(reviews) == 0 || reviews[0].Type != ReviewTypeLearn {
+			continue
+		}
+
+		h := new(fsrsHistory)
+		last := p.daysElapsed(reviews[0].Time())
+		for _, log := range reviews {
+			day := p.daysElapsed(log.Time())
+			h.ratings = append(h.ratings, log.Ease)
+			h.days = append(h.days, float64(max(day-last, 0)))
+			last = day
+		}
+		histories = append(histories, h)
+	}
+	return histories, nil
+}
+
+// countFSRSPredictions counts the reviews whose recall FSRS predicts: those
+// made on a later day than the previous review of their card.
+func countFSRSPredictions(histories []*fsrsHistory) int {
+	n := 0
+	for _, h := range histories {
+		for _, days := range h.days[1:] {
+			if days > 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// predict calls fn with the recall predicted for each review of a history,
+// and whether the card was actually recalled.
+func (w fsrsParams) predict(h *fsrsHistory, fn func(r float64, recalled bool)) {
+	m := w.initialState(h.ratings[0])
+	for i := 1; i < len(h.ratings); i++ {
+		if h.days[i] > 0 {
+			fn(w.retrievability(h.days[i], m.stability), h.ratings[i] > ReviewEaseAgain)
+		}
+		m = w.nextState(m, h.days[i], h.ratings[i])
+	}
+}
+
+// logLoss returns the total log loss of the recalls predicted for histories,
+// and the number of predictions.
+func (w fsrsParams) logLoss(histories []*fsrsHistory) (float64, int) {
+	loss, n := 0.0, 0
+	for _, h := range histories {
+		w.predict(h, func(r float64, recalled bool) {
+			r = min(max(r, 1e-4), 1-1e-4)
+			if recalled {
+				loss -= math.Log(r)
+			} else {
+				loss -= math.Log(1 - r)
+			}
+			n++
+		})
+	}
+	return loss, n
+}
+
+// trainFSRS trains FSRS parameters on histories with Adam, in mini-batches
+// of cards over a few epochs, once the initial stabilities are pretrained.
+// Gradients are estimated by central differences of the log loss.
+func trainFSRS(ctx context.Context, histories []*fsrsHistory, prog *progress) (fsrsParams, error) {
+	// Batches hold as many cards as make about fsrsBatchSize predictions.
+	size := max(len(histories)*fsrsBatchSize/max(countFSRSPredictions(histories), 1), 1)
+	batches := (len(histories) + size - 1) / size
+	steps := fsrsEpochs * batches
+	prog.phase(ProgressOptimize, steps)
+
+	const beta1, beta2, epsilon = 0.9, 0.999, 1e-8
+	w := slices.Clone(defaultFSRSParams)
+	pretrainFSRS(w, histories)
+	mean := make([]float64, len(w))
+	variance := make([]float64, len(w))
+	grad := make([]float64, len(w))
+	shuffled := slices.Clone(histories)
+	rng := rand.New(rand.NewPCG(uint64(len(histories)), 0))
+	step := 0
+	for range fsrsEpochs {
+		rng.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		for b := range batches {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			batch := shuffled[b*size : min((b+1)*size, len(shuffled))]
+			_, n := w.logLoss(batch)
+			for j := range w {
+				p := w[j]
+				delta := fsrsGradientDelta * max(math.Abs(p), 1)
+				w[j] = p + delta
+				up, _ := w.logLoss(batch)
+				w[j] = p - delta
+				down, _ := w.logLoss(batch)
+				w[j] = p
+				grad[j] = (up - down) / (2 * delta * float64(max(n, 1)))
+			}
+
+			// The learning rate decreases along a cosine curve.
+			rate := fsrsLearningRate * (1 + math.Cos(math.Pi*float64(step)/float64(steps))) / 2
+			step++
+			for j := range w {
+				mean[j] = beta1*mean[j] + (1-beta1)*grad[j]
+				variance[j] = beta2*variance[j] + (1-beta2)*grad[j]*grad[j]
+				m := mean[j] / (1 - math.Pow(beta1, float64(step)))
+				v := variance[j] / (1 - math.Pow(beta2, float64(step)))
+				w[j] -= rate * m / (math.Sqrt(v) + epsilon)
+				w[j] = min(max(w[j], fsrsParamBounds[j][0]), fsrsParamBounds[j][1])
+			}
+			prog.step()
+		}
+	}
+
+	// The parameters are rounded as they are saved.
+	for j := range w {
+		w[j] = float64(float32(roundTo(w[j], 4)))
+	}
+	return w, nil
+}
+
+// pretrainFSRS fits the initial stability after each rating to the second
+// reviews of the cards first answered with it, when there are enough of them.
+// Initial stabilities do not decrease from one rating to the next.
+func pretrainFSRS(w fsrsParams, histories []*fsrsHistory) {
+	type review struct {
+		days     float64
+		recalled bool
+	}
+	var reviews [4][]review
+	for _, h := range histories {
+		if len(h.days) > 1 && h.days[1] > 0 {
+			r := h.ratings[0] - 1
+			reviews[r] = append(reviews[r], review{h.days[1], h.ratings[1] > ReviewEaseAgain})
+		}
+	}
+
+	for r := range reviews {
+		if len(reviews[r]) >= fsrsMinReviews {
+			loss := func(s float64) float64 {
+				total := 0.0
+				for _, review := range reviews[r] {
+					p := min(max(w.retrievability(review.days, s), 1e-4), 1-1e-4)
+					if review.recalled {
+						total -= math.Log(p)
+					} else {
+						total -= math.Log(1 - p)
+					}
+				}
+				return total
+			}
+			// The loss is minimized by a golden-section search over the
+			// logarithm of the stability.
+			lo, hi := math.Log(fsrsParamBounds[r][0]), math.Log(fsrsParamBounds[r][1])
+			ratio := (math.Sqrt(5) - 1) / 2
+			for hi-lo > 1e-4 {
+				a, b := hi-ratio*(hi-lo), lo+ratio*(hi-lo)
+				if loss(math.Exp(a)) < loss(math.Exp(b)) {
+					hi = b
+				} else {
+					lo = a
+				}
+			}
+			w[r] = math.Exp((lo + hi) / 2)
+		}
+		if r > 0 {
+			w[r] = max(w[r], w[r-1])
+		}
+	}
+}
+
+// evaluateFSRS measures how well parameters predict the recalls of
+// histories.
+func evaluateFSRS(w fsrsParams, histories []*fsrsHistory) *OptimizeFSRSResult {
+	var bins [fsrsRMSEBins]struct {
+		n                   int
+		predicted, recalled float64
+	}
+	loss, n := w.logLoss(histories)
+	for _, h := range histories {
+		w.predict(h, func(r float64, recalled bool) {
+			b := &bins[min(int(r*fsrsRMSEBins), fsrsRMSEBins-1)]
+			b.n++
+			b.predicted += r
+			if recalled {
+				b.recalled++
+			}
+		})
+	}
+
+	sum := 0.0
+	for _, b := range bins {
+		if b.n > 0 {
+			diff := (b.predicted - b.recalled) / float64(b.n)
+			sum += float64(b.n) * diff * diff
+		}
+	}
+	params := make([]float32, len(w))
+	for i, p := range w {
+		params[i] = float32(p)
+	}
+	return &OptimizeFSRSResult{
+		Params:  params,
+		LogLoss: loss / float64(max(n, 1)),
+		RMSE:    math.Sqrt(sum / float64(max(n, 1))),
+		Reviews: n,
+	}
+}
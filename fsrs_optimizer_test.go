@@ -0,0 +1,120 @@
+package anki
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestOptimizeFSRS tests training FSRS parameters on simulated reviews.
+func TestOptimizeFSRS(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	notes := make([]*Note, 300)
+	for i := range notes {
+		notes[i] = &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+	}
+	if err = col.AddNotes(1, notes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reviews are simulated with parameters remembering cards for longer
+	// than the defaults.
+	truth := slices.Clone(defaultFSRSParams)
+	for i := range 4 {
+		truth[i] *= 4
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	i := int64(0)
+	for card, err := range col.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := truth.initialState(ReviewEaseGood)
+		day, typ := int64(0), ReviewTypeLearn
+		rating := ReviewEaseGood
+		for range 6 {
+			i++
+			log := &ReviewLog{
+				ID:     col.props.crt.Add(time.Duration(day)*24*time.Hour+time.Hour).UnixMilli() + i,
+				CardID: card.ID,
+				Ease:   rating,
+				Factor: 2500,
+				Type:   typ,
+			}
+			if err = addReviewLog(col.db, log, col.ids); err != nil {
+				t.Fatal(err)
+			}
+			days := max(math.Round(defaultFSRSParams.interval(m.stability, 0.9)*(0.5+rng.Float64())), 1)
+			rating = ReviewEaseAgain
+			if rng.Float64() < truth.retrievability(days, m.stability) {
+				rating = ReviewEaseGood
+			}
+			m = truth.nextState(m, days, rating)
+			day += int64(days)
+			typ = ReviewTypeReview
+		}
+	}
+
+	var last Progress
+	result, err := col.OptimizeFSRS(1, &OptimizeFSRSOptions{
+		Progress: func(p Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Phase != ProgressOptimize || last.Done == 0 || last.Done != last.Total {
+		t.Errorf("last progress = %+v", last)
+	}
+	if !result.Updated || len(result.Params) != 21 || result.Reviews != 300*5 {
+		t.Fatalf("result = %+v", result)
+	}
+	histories, err := loadFSRSHistories(col.db, "", time.Time{}, col.props, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := evaluateFSRS(defaultFSRSParams, histories)
+	if result.LogLoss >= defaults.LogLoss || result.RMSE >= defaults.RMSE {
+		t.Errorf("log loss and RMSE = %v and %v, want less than %v and %v with the defaults",
+			result.LogLoss, result.RMSE, defaults.LogLoss, defaults.RMSE)
+	}
+	config, err := col.GetDeckConfig(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(config.Config.FsrsParams_6, result.Params) {
+		t.Errorf("saved params = %v, want %v", config.Config.FsrsParams_6, result.Params)
+	}
+
+	// Optimizing again keeps the parameters, which predict the reviews as well.
+	if result, err = col.OptimizeFSRS(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated {
+		t.Error("parameters updated again")
+	}
+
+	config = &DeckConfig{Name: "Unused", Config: DefaultDeckConfig()}
+	if err = col.AddDeckConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = col.OptimizeFSRS(config.ID, nil); !errors.Is(err, ErrNotEnoughReviews) {
+		t.Errorf("OptimizeFSRS() error = %v, want ErrNotEnoughReviews", err)
+	}
+}
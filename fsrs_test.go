@@ -0,0 +1,162 @@
+package anki
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestFSRSParams tests the forgetting curve of the FSRS versions.
+func TestFSRSParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []float32
+		decay  float64
+	}{
+		{name: "default", decay: defaultFSRSParams.decay()},
+		{name: "FSRS-4.5", params: make([]float32, 17), decay: 0.5},
+		{name: "FSRS-5", params: make([]float32, 19), decay: 0.5},
+		{name: "FSRS-6", params: append(make([]float32, 20), 0.2), decay: 0.2},
+		{name: "invalid", params: make([]float32, 3), decay: defaultFSRSParams.decay()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultDeckConfig()
+			config.FsrsParams_6 = tt.params
+			w := newFSRSParams(config)
+			if len(w) != 21 || math.Abs(w.decay()-tt.decay) > 1e-6 {
+				t.Fatalf("got %d params with decay %v, want 21 with decay %v", len(w), w.decay(), tt.decay)
+			}
+			if r := w.retrievability(10, 10); math.Abs(r-0.9) > 1e-9 {
+				t.Errorf("retrievability after the stability = %v, want 0.9", r)
+			}
+			if i := w.interval(10, 0.9); math.Abs(i-10) > 1e-9 {
+				t.Errorf("interval for 90%% retention = %v, want 10", i)
+			}
+			if w.interval(10, 0.8) <= w.interval(10, 0.95) {
+				t.Error("interval does not grow as the desired retention decreases")
+			}
+		})
+	}
+}
+
+// TestAnswerCardFSRS tests answering cards with FSRS.
+func TestAnswerCardFSRS(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	col, err := CreateInMemory(&CreateOptions{
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	if err = col.EnableFSRS(); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := col.FSRSEnabled(); err != nil || !enabled {
+		t.Fatalf("FSRSEnabled() = %v, %v", enabled, err)
+	}
+	config := DefaultDeckConfig()
+	config.LearnSteps = []float32{10}
+	config.RelearnSteps = []float32{10}
+	if err = col.AddDeckConfig(&DeckConfig{ID: 1, Name: "Default", Config: config}); err != nil {
+		t.Fatal(err)
+	}
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	addCard := func() *Card {
+		t.Helper()
+		note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+		if err := col.AddNote(1, note); err != nil {
+			t.Fatal(err)
+		}
+		var card *Card
+		for c, err := range col.ListCards(&ListCardsOptions{NoteID: &note.ID}) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			card = c
+		}
+		return card
+	}
+	retrievability := func(card *Card) float64 {
+		t.Helper()
+		r, err := col.CardRetrievability(card.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	card := addCard()
+	if r := retrievability(card); r != 0 {
+		t.Errorf("retrievability of a new card = %v, want 0", r)
+	}
+	if err = col.AnswerCard(card.ID, ReviewEaseGood, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	card, err = col.GetCard(card.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := parseCardData(card.Data)
+	memory, ok := data.memoryState()
+	want := defaultFSRSParams.initialState(ReviewEaseGood)
+	if !ok || math.Abs(memory.stability-want.stability) > 1e-3 || math.Abs(memory.difficulty-want.difficulty) > 1e-3 {
+		t.Errorf("memory state = %+v, want %+v", memory, want)
+	}
+	if card.Type != CardTypeReview || card.Interval != 2 {
+		t.Errorf("graduated with type %d and interval %d, want a review in 2 days", card.Type, card.Interval)
+	}
+	if r := retrievability(card); r != 1 {
+		t.Errorf("retrievability just after a review = %v, want 1", r)
+	}
+
+	now = now.Add(2 * 24 * time.Hour)
+	if r := retrievability(card); r < 0.9 || r >= 1 {
+		t.Errorf("retrievability on the due date = %v, want above 0.9", r)
+	}
+	intervals, err := col.NextIntervals(card.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intervals[ReviewEaseAgain] != 10*time.Minute {
+		t.Errorf("interval for Again = %v, want the relearning step", intervals[ReviewEaseAgain])
+	}
+	if !(intervals[ReviewEaseHard] < intervals[ReviewEaseGood] && intervals[ReviewEaseGood] < intervals[ReviewEaseEasy]) {
+		t.Errorf("intervals = %v, want them to grow with the rating", intervals)
+	}
+	if err = col.AnswerCard(card.ID, ReviewEaseGood, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	card, err = col.GetCard(card.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(card.Interval) * 24 * time.Hour; got != intervals[ReviewEaseGood] {
+		t.Errorf("interval after Good = %v, want %v", got, intervals[ReviewEaseGood])
+	}
+
+	// The memory state of a card scheduled by SM-2 is derived from its
+	// schedule, which has a retention of 90% on its due date.
+	card = addCard()
+	card.Type = CardTypeReview
+	card.Queue = CardQueueReview
+	card.Interval = 10
+	card.Due = col.props.daysElapsed(now)
+	card.Factor = 2500
+	if err = updateCard(col.db, card); err != nil {
+		t.Fatal(err)
+	}
+	if r := retrievability(card); math.Abs(r-0.9) > 1e-3 {
+		t.Errorf("retrievability of a SM-2 card on its due date = %v, want 0.9", r)
+	}
+}
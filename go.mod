@@ -1,11 +1,12 @@
 module github.com/lftk/anki
 
-go 1.23
+go 1.23.0
 
 require (
 	github.com/alexkappa/mustache v1.0.0
 	github.com/google/uuid v1.6.0
 	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/text v0.25.0
 	google.golang.org/protobuf v1.36.6
 )
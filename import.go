@@ -0,0 +1,551 @@
+package anki
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ImportUpdatePolicy controls what happens to notes that already exist in the
+// collection, as identified by their GUID.
+type ImportUpdatePolicy int
+
+const (
+	// ImportUpdateIfNewer updates existing notes if the imported note was
+	// modified more recently.
+	ImportUpdateIfNewer ImportUpdatePolicy = iota
+	// ImportUpdateAlways always updates existing notes.
+	ImportUpdateAlways
+	// ImportUpdateNever never updates existing notes.
+	ImportUpdateNever
+)
+
+// ImportOptions specifies options for importing a package.
+type ImportOptions struct {
+	// UpdateNotes controls what happens to notes that already exist.
+	UpdateNotes ImportUpdatePolicy
+	// ResetScheduling imports the cards of new notes as new cards,
+	// discarding their scheduling information. Otherwise, their reviews are
+	// imported along with them.
+	ResetScheduling bool
+	// Unpack holds the limits to enforce when unpacking the package.
+	Unpack *UnpackOptions
+}
+
+// ImportReport describes the changes made by an import.
+type ImportReport struct {
+	// NotesAdded holds the IDs of the notes added to the collection.
+	NotesAdded []int64
+	// NotesUpdated holds the IDs of the existing notes that were updated.
+	NotesUpdated []int64
+	// NotesSkipped holds the IDs of the existing notes that were left as is,
+	// either because of the update policy or because their notetype differs.
+	NotesSkipped []int64
+	// NotetypesAdded holds the IDs of the notetypes added to the collection.
+	NotetypesAdded []int64
+	// DecksAdded holds the IDs of the decks added to the collection.
+	DecksAdded []int64
+	// MediaAdded holds the names of the media files added to the collection.
+	MediaAdded []string
+	// MediaRenamed maps the names of imported media files that clashed with
+	// different existing files to the names they were stored under.
+	MediaRenamed map[string]string
+}
+
+// Import merges a package into the collection.
+// Notes are matched by GUID, and notetypes by ID, original ID and schema.
+// Decks are matched by name. Decks and notetypes whose IDs are already taken
+// are given new IDs, and media files whose names clash with different
+// existing files are renamed, with references in the imported notes updated.
+func (c *Collection) Import(pkg io.ReaderAt, size int64, opts *ImportOptions) (*ImportReport, error) {
+	return c.ImportContext(context.Background(), pkg, size, opts)
+}
+
+// ImportContext is like Import, but stops importing once ctx is done.
+// Notetypes, decks and notes are imported in a single transaction, which is
+// rolled back if ctx is done before it commits. Media files are copied once
+// it has committed, regardless of ctx, so that the notes are not left
+// without them.
+func (c *Collection) ImportContext(ctx context.Context, pkg io.ReaderAt, size int64, opts *ImportOptions) (*ImportReport, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	src, err := ReadFromContext(ctx, pkg, size, &OpenOptions{ReadOnly: true, Unpack: opts.Unpack})
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close() //nolint:errcheck
+
+	imp := &importer{
+		src:       src,
+		dst:       c,
+		opts:      opts,
+		notetypes: make(map[int64]*Notetype),
+		decks:     make(map[int64]int64),
+		report: &ImportReport{
+			MediaRenamed: make(map[string]string),
+		},
+	}
+	if err = imp.prepareMedia(); err != nil {
+		return nil, err
+	}
+	err = c.transactContext(ctx, func(tx *sql.Tx) error {
+		for _, fn := range []func(*sql.Tx) error{
+			imp.importNotetypes, imp.importDecks, imp.importNotes,
+		} {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = imp.copyMedia(); err != nil {
+		return nil, err
+	}
+	return imp.report, nil
+}
+
+// importer holds the state of an import.
+type importer struct {
+	src  *Collection
+	dst  *Collection
+	opts *ImportOptions
+
+	// notetypes maps source notetype IDs to notetypes in the collection.
+	notetypes map[int64]*Notetype
+	// decks maps source deck IDs to deck IDs in the collection.
+	decks map[int64]int64
+	// media maps the names of source media files to copy to their new names.
+	media map[string]string
+
+	report *ImportReport
+}
+
+// prepareMedia decides which media files to copy and under which names.
+func (imp *importer) prepareMedia() error {
+	imp.media = make(map[string]string)
+	for m, err := range imp.src.ListMedia(nil) {
+		if err != nil {
+			return err
+		}
+		name := m.Name()
+		srcHash, err := imp.src.mediaChecksum(name)
+		if err != nil {
+			return err
+		}
+		dstHash, err := imp.dst.mediaChecksum(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			imp.media[name] = name
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if dstHash == srcHash {
+			continue
+		}
+
+		newName := addHashSuffix(name, srcHash)
+		imp.report.MediaRenamed[name] = newName
+		dstHash, err = imp.dst.mediaChecksum(newName)
+		if err == nil && dstHash == srcHash {
+			continue
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		imp.media[name] = newName
+	}
+	return nil
+}
+
+// copyMedia copies the media files chosen by prepareMedia.
+func (imp *importer) copyMedia() error {
+	names := make([]string, 0, len(imp.media))
+	for name := range imp.media {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		m, err := imp.src.GetMedia(name)
+		if err != nil {
+			return err
+		}
+		newName := imp.media[name]
+		if err = imp.dst.CopyMedia(&renamedMedia{Media: m, name: newName}); err != nil {
+			return err
+		}
+		imp.report.MediaAdded = append(imp.report.MediaAdded, newName)
+	}
+	return nil
+}
+
+// renamedMedia is a media file exposed under a different name.
+type renamedMedia struct {
+	Media
+	name string
+}
+
+func (m *renamedMedia) Name() string {
+	return m.name
+}
+
+// importNotetypes maps each source notetype to a notetype in the collection,
+// adding the ones that have no match.
+func (imp *importer) importNotetypes(tx *sql.Tx) error {
+	existing, err := sqlSelect(tx, scanNotetype, getNotetypeQuery)
+	if err != nil {
+		return err
+	}
+	for nt, err := range imp.src.ListNotetypes(nil) {
+		if err != nil {
+			return err
+		}
+		if match := matchNotetype(nt, existing); match != nil {
+			imp.notetypes[nt.ID] = match
+			continue
+		}
+
+		srcID := nt.ID
+		if nt.Config.OriginalId == nil {
+			nt.Config.OriginalId = &srcID
+		}
+		if slices.ContainsFunc(existing, func(e *Notetype) bool { return e.ID == nt.ID }) {
+			nt.ID = 0
+		}
+		nt.Name = uniqueNotetypeName(nt.Name, existing)
+		if err = addNotetype(tx, nt, imp.dst.ids); err != nil {
+			return err
+		}
+		existing = append(existing, nt)
+		imp.notetypes[srcID] = nt
+		imp.report.NotetypesAdded = append(imp.report.NotetypesAdded, nt.ID)
+	}
+	return nil
+}
+
+// matchNotetype finds an existing notetype with the same schema as nt that is
+// either the same notetype or shares its origin.
+func matchNotetype(nt *Notetype, existing []*Notetype) *Notetype {
+	related := func(e *Notetype) bool {
+		if e.ID == nt.ID {
+			return true
+		}
+		srcOrig, dstOrig := nt.Config.OriginalId, e.Config.OriginalId
+		switch {
+		case srcOrig != nil && *srcOrig == e.ID:
+			return true
+		case dstOrig != nil && *dstOrig == nt.ID:
+			return true
+		case srcOrig != nil && dstOrig != nil && *srcOrig == *dstOrig:
+			return true
+		}
+		return false
+	}
+	for _, e := range existing {
+		if related(e) && sameNotetypeSchema(nt, e) {
+			return e
+		}
+	}
+	return nil
+}
+
+// sameNotetypeSchema checks if two notetypes have the same kind, fields and
+// templates, so that notes of one can be stored as notes of the other.
+func sameNotetypeSchema(nt1, nt2 *Notetype) bool {
+	return nt1.Config.GetKind() == nt2.Config.GetKind() &&
+		slices.EqualFunc(nt1.Fields, nt2.Fields, func(f1, f2 *Field) bool {
+			return f1.Name == f2.Name
+		}) &&
+		slices.EqualFunc(nt1.Templates, nt2.Templates, func(t1, t2 *Template) bool {
+			return t1.Name == t2.Name
+		})
+}
+
+// uniqueNotetypeName returns name, with a "+" appended as often as needed to
+// make it unique among the existing notetypes.
+func uniqueNotetypeName(name string, existing []*Notetype) string {
+	for slices.ContainsFunc(existing, func(e *Notetype) bool { return e.Name == name }) {
+		name += "+"
+	}
+	return name
+}
+
+// importDecks maps each source deck to a deck in the collection with the same
+// name, adding the ones that do not exist. Filtered decks are not imported;
+// their cards are moved back to their home decks instead.
+func (imp *importer) importDecks(tx *sql.Tx) error {
+	decks, err := sqlSelect(imp.src.db, scanDeck, getDeckQuery+" ORDER BY name")
+	if err != nil {
+		return err
+	}
+	configs := make(map[int64]int64)
+	for _, deck := range decks {
+		if deck.Kind.GetFiltered() != nil {
+			continue
+		}
+
+		existing, err := sqlGet(tx, scanDeck, getDeckQuery+" WHERE name = ?", deck.Name)
+		if err == nil {
+			imp.decks[deck.ID] = existing.ID
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if normal := deck.Kind.GetNormal(); normal != nil {
+			configID, ok := configs[normal.ConfigId]
+			if !ok {
+				configID, err = imp.importDeckConfig(tx, normal.ConfigId)
+				if err != nil {
+					return err
+				}
+				configs[normal.ConfigId] = configID
+			}
+			normal.ConfigId = configID
+		}
+
+		srcID := deck.ID
+		deck.USN = -1
+		if err = addDeck(tx, deck, imp.dst.ids); err != nil {
+			return err
+		}
+		imp.decks[srcID] = deck.ID
+		imp.report.DecksAdded = append(imp.report.DecksAdded, deck.ID)
+	}
+	return nil
+}
+
+// importDeckConfig maps a source deck config to a deck config in the
+// collection with the same name, adding it if it does not exist.
+func (imp *importer) importDeckConfig(tx *sql.Tx, id int64) (int64, error) {
+	config, err := imp.src.GetDeckConfig(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	existing, err := sqlSelect(tx, scanDeckConfig, getDeckConfigQuery)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range existing {
+		if strings.EqualFold(e.Name, config.Name) {
+			return e.ID, nil
+		}
+	}
+	if slices.ContainsFunc(existing, func(e *DeckConfig) bool { return e.ID == config.ID }) {
+		config.ID = 0
+	}
+	config.USN = -1
+	if err = addDeckConfig(tx, config, imp.dst.ids); err != nil {
+		return 0, err
+	}
+	return config.ID, nil
+}
+
+// importNotes adds the source notes that do not exist in the collection, and
+// updates the ones that do according to the update policy.
+func (imp *importer) importNotes(tx *sql.Tx) error {
+	pos, err := sqlGet(tx, scanValue[int64], getMaxNewPositionQuery)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]struct{})
+	for note, err := range imp.src.ListNotes(nil) {
+		if err != nil {
+			return err
+		}
+		notetype, ok := imp.notetypes[note.NotetypeID]
+		if !ok {
+			continue
+		}
+		for i, field := range note.Fields {
+			note.Fields[i] = replaceMediaReferences(field, imp.report.MediaRenamed)
+		}
+
+		existing, err := sqlGet(tx, scanNote, getNoteQuery+" WHERE guid = ?", note.GUID)
+		if err == nil {
+			if err = imp.updateNote(tx, existing, note, notetype, tags); err != nil {
+				return err
+			}
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		srcID := note.ID
+		note.NotetypeID = notetype.ID
+		note.USN = -1
+		if err = insertNote(tx, note, notetype, imp.dst.ids); err != nil {
+			return err
+		}
+		for _, tag := range note.Tags {
+			tags[tag] = struct{}{}
+		}
+		imp.report.NotesAdded = append(imp.report.NotesAdded, note.ID)
+
+		opts := &ListCardsOptions{NoteID: &srcID}
+		for card, err := range imp.src.ListCards(opts) {
+			if err != nil {
+				return err
+			}
+			if card.OriginalDeckID != 0 {
+				card.DeckID = card.OriginalDeckID
+				card.Due = card.OriginalDue
+				card.OriginalDeckID = 0
+				card.OriginalDue = 0
+			}
+			deckID, ok := imp.decks[card.DeckID]
+			if !ok {
+				deckID = 1
+			}
+			srcCardID := card.ID
+			card.NoteID = note.ID
+			card.DeckID = deckID
+			card.USN = -1
+			if imp.opts.ResetScheduling {
+				pos++
+				resetCardToNew(card, pos)
+			}
+			if err = addCard(tx, card, imp.dst.ids); err != nil {
+				return err
+			}
+			if !imp.opts.ResetScheduling {
+				if err = imp.importReviewLogs(tx, srcCardID, card.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for tag := range tags {
+		if err := sqlExecute(tx, addTagQuery, tag, -1, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importReviewLogs adds the reviews of a source card to the card it was
+// imported as. Reviews whose IDs are taken are skipped, as in Anki, since they
+// were most likely imported before.
+func (imp *importer) importReviewLogs(tx *sql.Tx, srcCardID, cardID int64) error {
+	for log, err := range imp.src.ListReviewLogs(&ListReviewLogsOptions{CardID: &srcCardID}) {
+		if err != nil {
+			return err
+		}
+		args := []any{
+			log.ID,
+			cardID,
+			-1,
+			log.Ease,
+			log.Interval,
+			log.LastInterval,
+			log.Factor,
+			log.TimeTaken.Milliseconds(),
+			log.Type,
+		}
+		if err = sqlExecute(tx, addReviewLogIfUniqueQuery, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateNote updates an existing note from an imported one, according to the
+// update policy, adding the tags of updated notes to tags.
+func (imp *importer) updateNote(tx *sql.Tx, existing, note *Note, notetype *Notetype, tags map[string]struct{}) error {
+	update := existing.NotetypeID == notetype.ID
+	switch imp.opts.UpdateNotes {
+	case ImportUpdateIfNewer:
+		update = update && note.Modified.After(existing.Modified)
+	case ImportUpdateNever:
+		update = false
+	}
+	if !update {
+		imp.report.NotesSkipped = append(imp.report.NotesSkipped, existing.ID)
+		return nil
+	}
+
+	existing.Fields = note.Fields
+	existing.Tags = note.Tags
+	if err := updateNote(tx, existing, notetype, imp.dst.ids); err != nil {
+		return err
+	}
+	for _, tag := range existing.Tags {
+		tags[tag] = struct{}{}
+	}
+	imp.report.NotesUpdated = append(imp.report.NotesUpdated, existing.ID)
+	return nil
+}
+
+// resetCardToNew resets a card to a new card at the given position.
+func resetCardToNew(card *Card, pos int64) {
+	card.Type = CardTypeNew
+	card.Queue = CardQueueNew
+	card.Due = pos
+	card.Interval = 0
+	card.Factor = 0
+	card.Repetitions = 0
+	card.Lapses = 0
+	card.Left = 0
+	card.OriginalDue = 0
+	card.OriginalDeckID = 0
+	card.Data = ""
+}
+
+// mediaChecksum returns the hex-encoded SHA-1 hash of a media file.
+func (c *Collection) mediaChecksum(name string) (string, error) {
+	r, err := c.OpenMedia(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close() //nolint:errcheck
+
+	return sha1Hex(r)
+}
+
+// addHashSuffix adds a hash to the stem of a file name, as Anki does when
+// importing a media file whose name is taken by a different file.
+func addHashSuffix(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + hash + ext
+}
+
+// replaceMediaReferences replaces references to renamed media files in a field.
+func replaceMediaReferences(field string, renamed map[string]string) string {
+	if len(renamed) == 0 {
+		return field
+	}
+	field = htmlMediaTagRe.ReplaceAllStringFunc(field, func(tag string) string {
+		m := htmlMediaTagRe.FindStringSubmatch(tag)
+		raw := m[1] + m[2] + m[3]
+		newName, ok := renamed[unescapeMediaName(raw)]
+		if !ok {
+			return tag
+		}
+		i := strings.LastIndex(tag, raw)
+		return tag[:i] + html.EscapeString(newName) + tag[i+len(raw):]
+	})
+	return soundTagRe.ReplaceAllStringFunc(field, func(tag string) string {
+		m := soundTagRe.FindStringSubmatch(tag)
+		if newName, ok := renamed[m[1]]; ok {
+			return "[sound:" + newName + "]"
+		}
+		return tag
+	})
+}
@@ -0,0 +1,320 @@
+package anki
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestReplaceMediaReferences tests the replaceMediaReferences function.
+func TestReplaceMediaReferences(t *testing.T) {
+	renamed := map[string]string{
+		"a.png":   "a-1.png",
+		"b c.mp3": "b c-1.mp3",
+	}
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{
+			name:  "image",
+			field: `<img class="x" src="a.png">`,
+			want:  `<img class="x" src="a-1.png">`,
+		},
+		{
+			name:  "sound",
+			field: `[sound:b c.mp3]`,
+			want:  `[sound:b c-1.mp3]`,
+		},
+		{
+			name:  "unrelated",
+			field: `<img src="d.png"> [sound:a.mp3] a.png`,
+			want:  `<img src="d.png"> [sound:a.mp3] a.png`,
+		},
+		{
+			name:  "escaped name",
+			field: `<img src='a%2Epng'>`,
+			want:  `<img src='a-1.png'>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceMediaReferences(tt.field, renamed); got != tt.want {
+				t.Errorf("replaceMediaReferences(%q) = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestImport tests importing a package whose IDs collide with the ones of the
+// collection, with each update policy.
+func TestImport(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	newCollection := func(t *testing.T) *Collection {
+		t.Helper()
+		col, err := CreateInMemory(&CreateOptions{
+			Clock: ClockFunc(func() time.Time { return now }),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = col.Close() })
+		return col
+	}
+	newNotetype := func(fields ...string) *Notetype {
+		nt := &Notetype{
+			Name:      "Basic",
+			Config:    NewNotetypeConfig("", false),
+			Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+		}
+		for _, name := range fields {
+			nt.Fields = append(nt.Fields, NewField(name))
+		}
+		return nt
+	}
+	writePackage := func(t *testing.T, col *Collection) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := col.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	importPackage := func(t *testing.T, col *Collection, pkg []byte, opts *ImportOptions) *ImportReport {
+		t.Helper()
+		report, err := col.Import(bytes.NewReader(pkg), int64(len(pkg)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	readMedia := func(t *testing.T, col *Collection, name string) string {
+		t.Helper()
+		r, err := col.OpenMedia(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close() //nolint:errcheck
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// The package and the collections are built at the same time and in the
+	// same steps, so that they are given the same IDs.
+	src := newCollection(t)
+	srcNotetype := newNotetype("Front", "Back", "Extra")
+	if err := src.AddNotetype(srcNotetype); err != nil {
+		t.Fatal(err)
+	}
+	deck := &Deck{Name: "Imported"}
+	if err := src.AddDeck(deck); err != nil {
+		t.Fatal(err)
+	}
+	srcNote := &Note{NotetypeID: srcNotetype.ID, Fields: []string{"one", `<img src="a.png">`, ""}}
+	if err := src.AddNote(deck.ID, srcNote); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.WriteMedia("a.png", []byte("imported")); err != nil {
+		t.Fatal(err)
+	}
+	// The first review takes the ID of a review in the collection.
+	takenReview, srcReview := start.Add(-2*time.Hour).UnixMilli(), start.Add(-time.Hour).UnixMilli()
+	for _, id := range []int64{takenReview, srcReview} {
+		log := &ReviewLog{ID: id, CardID: firstCardID(t, src, srcNote.ID), Ease: ReviewEaseGood, Type: ReviewTypeLearn}
+		if err := src.AddReviewLog(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := writePackage(t, src)
+
+	newTarget := func(t *testing.T) (*Collection, *ImportReport) {
+		t.Helper()
+		now = start
+		col := newCollection(t)
+		notetype := newNotetype("Front", "Back", "Hint")
+		if err := col.AddNotetype(notetype); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddDeck(&Deck{Name: "Local"}); err != nil {
+			t.Fatal(err)
+		}
+		note := &Note{NotetypeID: notetype.ID, Fields: []string{"local", "", ""}}
+		if err := col.AddNote(1, note); err != nil {
+			t.Fatal(err)
+		}
+		if notetype.ID != srcNotetype.ID || note.ID != srcNote.ID {
+			t.Fatal("the IDs of the collection and the package do not collide")
+		}
+		if err := col.WriteMedia("a.png", []byte("local")); err != nil {
+			t.Fatal(err)
+		}
+		log := &ReviewLog{ID: takenReview, CardID: firstCardID(t, col, note.ID), Ease: ReviewEaseHard, Type: ReviewTypeLearn}
+		if err := col.AddReviewLog(log); err != nil {
+			t.Fatal(err)
+		}
+		return col, importPackage(t, col, base, nil)
+	}
+
+	t.Run("collisions", func(t *testing.T) {
+		col, report := newTarget(t)
+		if len(report.NotetypesAdded) != 1 || report.NotetypesAdded[0] == srcNotetype.ID {
+			t.Fatalf("notetypes added = %v, want one with a new ID", report.NotetypesAdded)
+		}
+		notetype, err := col.GetNotetype(report.NotetypesAdded[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notetype.Name != "Basic+" || notetype.Fields[2].Name != "Extra" {
+			t.Errorf("notetype added as %q, want Basic+ with the fields of the package", notetype.Name)
+		}
+		if len(report.DecksAdded) != 1 {
+			t.Fatalf("decks added = %v, want one", report.DecksAdded)
+		}
+		if len(report.NotesAdded) != 1 || report.NotesAdded[0] == srcNote.ID {
+			t.Fatalf("notes added = %v, want one with a new ID", report.NotesAdded)
+		}
+		note, err := col.GetNote(report.NotesAdded[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if note.NotetypeID != notetype.ID {
+			t.Errorf("note added with notetype %d, want %d", note.NotetypeID, notetype.ID)
+		}
+		if local, err := col.GetNote(srcNote.ID); err != nil || local.Fields[0] != "local" {
+			t.Errorf("note with the colliding ID = %v, %v, want it left as is", local, err)
+		}
+
+		var cards []*Card
+		for card, err := range col.ListCards(nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			cards = append(cards, card)
+		}
+		if len(cards) != 2 || cards[0].ID == cards[1].ID {
+			t.Fatalf("cards = %d with IDs colliding, want 2 distinct", len(cards))
+		}
+		for _, card := range cards {
+			if card.NoteID == note.ID && card.DeckID != report.DecksAdded[0] {
+				t.Errorf("card added to deck %d, want %d", card.DeckID, report.DecksAdded[0])
+			}
+		}
+
+		renamed, ok := report.MediaRenamed["a.png"]
+		if !ok || renamed == "a.png" {
+			t.Fatalf("renamed media = %v, want a.png renamed", report.MediaRenamed)
+		}
+		if want := `<img src="` + renamed + `">`; note.Fields[1] != want {
+			t.Errorf("field = %q, want %q", note.Fields[1], want)
+		}
+		if got := readMedia(t, col, "a.png"); got != "local" {
+			t.Errorf("a.png = %q, want the local file", got)
+		}
+		if got := readMedia(t, col, renamed); got != "imported" {
+			t.Errorf("%s = %q, want the imported file", renamed, got)
+		}
+
+		// The review whose ID is taken is skipped.
+		reviews := make(map[int64]int64)
+		for log, err := range col.ListReviewLogs(nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			reviews[log.ID] = log.CardID
+		}
+		importedCard := firstCardID(t, col, note.ID)
+		if want := map[int64]int64{takenReview: firstCardID(t, col, srcNote.ID), srcReview: importedCard}; !maps.Equal(reviews, want) {
+			t.Errorf("reviews = %v, want %v", reviews, want)
+		}
+
+		// Importing the same package again adds nothing.
+		report = importPackage(t, col, base, nil)
+		if len(report.NotesAdded) != 0 || len(report.NotetypesAdded) != 0 || len(report.MediaAdded) != 0 {
+			t.Errorf("second import = %+v, want nothing added", report)
+		}
+	})
+
+	now = start.Add(time.Hour)
+	srcNote.Fields[0] = "two"
+	srcNote.Tags = []string{"updated"}
+	if err := src.UpdateNote(srcNote); err != nil {
+		t.Fatal(err)
+	}
+	updated := writePackage(t, src)
+
+	tests := []struct {
+		name   string
+		policy ImportUpdatePolicy
+		// localNewer modifies the note in the collection after the note of
+		// the package.
+		localNewer bool
+		want       string
+	}{
+		{name: "if newer", policy: ImportUpdateIfNewer, want: "two"},
+		{name: "if newer, local newer", policy: ImportUpdateIfNewer, localNewer: true, want: "local"},
+		{name: "always", policy: ImportUpdateAlways, localNewer: true, want: "two"},
+		{name: "never", policy: ImportUpdateNever, want: "one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			col, report := newTarget(t)
+			note, err := col.GetNote(report.NotesAdded[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.localNewer {
+				now = start.Add(2 * time.Hour)
+				note.Fields[0] = "local"
+				if err = col.UpdateNote(note); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report = importPackage(t, col, updated, &ImportOptions{UpdateNotes: tt.policy})
+			note, err = col.GetNote(note.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if note.Fields[0] != tt.want {
+				t.Errorf("field = %q, want %q", note.Fields[0], tt.want)
+			}
+			updated := slices.Contains(report.NotesUpdated, note.ID)
+			skipped := slices.Contains(report.NotesSkipped, note.ID)
+			wantUpdated := tt.want == "two"
+			if updated != wantUpdated || skipped == wantUpdated {
+				t.Errorf("updated = %v, skipped = %v, want updated %v", updated, skipped, wantUpdated)
+			}
+			var tags []string
+			for tag, err := range col.ListTags(nil) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				tags = append(tags, tag.Name)
+			}
+			if registered := slices.Contains(tags, "updated"); registered != wantUpdated {
+				t.Errorf("tags = %q, want the tag of the updated note registered: %v", tags, wantUpdated)
+			}
+		})
+	}
+}
+
+// firstCardID returns the ID of the first card of a note.
+func firstCardID(t *testing.T, col *Collection, noteID int64) int64 {
+	t.Helper()
+	for card, err := range col.ListCards(&ListCardsOptions{NoteID: &noteID}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return card.ID
+	}
+	t.Fatalf("note %d has no cards", noteID)
+	return 0
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package anki
+
+import (
+	"io"
+	"os"
+)
+
+// lockDir checks that the directory exists. Locking is not supported on this
+// platform.
+func lockDir(dir string) (io.Closer, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return nopCloser{}, nil
+}
+
+// nopCloser is an io.Closer that does nothing.
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
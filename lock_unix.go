@@ -0,0 +1,28 @@
+//go:build unix
+
+package anki
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockDir takes an exclusive advisory lock on a directory.
+// The directory itself is locked rather than the database file, because
+// closing any descriptor of the database would drop the locks SQLite holds.
+func lockDir(dir string) (io.Closer, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrProfileLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package anki
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestOpenProfileLocked tests that a profile cannot be opened twice at once.
+func TestOpenProfileLocked(t *testing.T) {
+	dir := newTestProfile(t)
+	col, err := OpenProfile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenProfile(dir, nil); !errors.Is(err, ErrProfileLocked) {
+		t.Errorf("OpenProfile() error = %v, want ErrProfileLocked", err)
+	}
+	if err = col.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	col, err = OpenProfile(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenProfile() after Close: %v", err)
+	}
+	_ = col.Close()
+}
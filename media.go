@@ -1,20 +1,25 @@
 package anki
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"iter"
 	"os"
-	"path/filepath"
+	"path"
 )
 
 // GetMedia gets a media file by name.
 func (c *Collection) GetMedia(name string) (Media, error) {
-	path := c.mediaPath(name)
-	if _, err := os.Stat(path); err != nil {
+	if _, err := c.store.Stat(name); err != nil {
+		if c.pkg != nil && errors.Is(err, fs.ErrNotExist) {
+			if m, ok := c.pkg.get(name); ok {
+				return m, nil
+			}
+		}
 		return nil, err
 	}
-	return &media{name: name, path: path}, nil
+	return &storeMedia{store: c.store, name: name}, nil
 }
 
 // OpenMedia opens a media file for reading.
@@ -28,7 +33,7 @@ func (c *Collection) OpenMedia(name string) (io.ReadCloser, error) {
 
 // AddMedia adds a media file from a path.
 func (c *Collection) AddMedia(name, path string) error {
-	return c.CopyMedia(&media{name: name, path: path})
+	return c.CopyMedia(&fileMedia{name: name, path: path})
 }
 
 // WriteMedia writes content to a media file.
@@ -37,69 +42,75 @@ func (c *Collection) WriteMedia(name string, content []byte) error {
 	if err != nil {
 		return err
 	}
-	defer w.Close() //nolint:errcheck
-
-	_, err = w.Write(content)
-	return err
+	if _, err = w.Write(content); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
 }
 
 // CopyMedia copies a media file to the collection.
 func (c *Collection) CopyMedia(media Media) error {
-	r, err := media.Open()
-	if err != nil {
-		return err
-	}
-	defer r.Close() //nolint:errcheck
-
 	w, err := c.CreateMedia(media.Name())
 	if err != nil {
 		return err
 	}
-	defer w.Close() //nolint:errcheck
-
-	_, err = io.Copy(w, r)
-	return err
+	return copyMedia(w, media)
 }
 
 // CreateMedia creates a new media file and returns a writer.
 func (c *Collection) CreateMedia(name string) (io.WriteCloser, error) {
-	path := c.mediaPath(name)
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return nil, err
+	if c.readOnly {
+		return nil, ErrReadOnly
 	}
-	f, err := os.Create(path)
+	w, err := c.store.Create(name)
 	if err != nil {
 		return nil, err
 	}
-	return &mediaWriteCloser{File: f, path: path}, nil
+	c.dirty.Store(true)
+	onClose := func() error {
+		if c.pkg != nil {
+			c.pkg.remove(name)
+		}
+		if c.profile != nil {
+			return c.profile.mediaAdded(c.store, name)
+		}
+		return nil
+	}
+	return &mediaWriteCloser{WriteCloser: w, onClose: onClose}, nil
 }
 
-// mediaWriteCloser is a writer that removes the file if an error occurs.
+// mediaWriteCloser is a writer that runs a function once the media file has
+// been stored.
 type mediaWriteCloser struct {
-	*os.File
-	path string
-	err  error
-}
-
-func (m *mediaWriteCloser) Write(p []byte) (int, error) {
-	n, err := m.File.Write(p)
-	if err != nil {
-		m.err = err
-	}
-	return n, err
+	io.WriteCloser
+	onClose func() error
 }
 
 func (m *mediaWriteCloser) Close() error {
-	err := m.File.Close()
-	if err != nil || m.err != nil {
-		_ = os.Remove(m.path)
+	if err := m.WriteCloser.Close(); err != nil {
+		return err
 	}
-	return err
+	return m.onClose()
 }
 
 // DeleteMedia deletes a media file.
 func (c *Collection) DeleteMedia(name string) error {
-	return os.Remove(c.mediaPath(name))
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	err := c.store.Delete(name)
+	if c.pkg != nil && c.pkg.remove(name) && errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	c.dirty.Store(true)
+	if c.profile != nil {
+		return c.profile.mediaDeleted(name)
+	}
+	return nil
 }
 
 // ListMediaOptions specifies options for listing media files.
@@ -110,47 +121,76 @@ type ListMediaOptions struct {
 
 // ListMedia lists all media files.
 func (c *Collection) ListMedia(opts *ListMediaOptions) iter.Seq2[Media, error] {
-	dir := c.mediaDir()
 	return func(yield func(Media, error) bool) {
-		fn := func(path string, d fs.DirEntry, err error) error {
-			if err != nil || d.IsDir() {
-				return err
+		for name, err := range c.store.List() {
+			if err != nil {
+				yield(nil, err)
+				return
 			}
-
-			name, err := filepath.Rel(dir, path)
+			matched, err := matchMedia(opts, name)
 			if err != nil {
-				return err
+				yield(nil, err)
+				return
 			}
-
-			if opts != nil && opts.Pattern != nil {
-				matched, err := filepath.Match(*opts.Pattern, name)
-				if err != nil {
-					return err
-				}
-				if !matched {
-					return nil
-				}
+			if matched && !yield(&storeMedia{store: c.store, name: name}, nil) {
+				return
 			}
+		}
 
-			if !yield(&media{name: name, path: path}, nil) {
-				return filepath.SkipAll
-			}
-			return nil
+		if c.pkg == nil {
+			return
 		}
-		if err := filepath.WalkDir(dir, fn); err != nil {
-			yield(nil, err)
+		for _, m := range c.pkg.list() {
+			matched, err := matchMedia(opts, m.Name())
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if matched && !yield(m, nil) {
+				return
+			}
 		}
 	}
 }
 
-// mediaDir returns the path to the media directory.
-func (c *Collection) mediaDir() string {
-	return mediaDir(c.dir)
+// matchMedia checks if a media file name matches the pattern in opts, if any.
+func matchMedia(opts *ListMediaOptions, name string) (bool, error) {
+	if opts == nil || opts.Pattern == nil {
+		return true, nil
+	}
+	return path.Match(*opts.Pattern, name)
 }
 
-// mediaPath returns the path to a media file.
-func (c *Collection) mediaPath(name string) string {
-	return filepath.Join(c.mediaDir(), name)
+// copyMedia copies the content of a media file to w, closing w.
+func copyMedia(w io.WriteCloser, m Media) error {
+	r, err := m.Open()
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	defer r.Close() //nolint:errcheck
+
+	if _, err = io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// copyMediaFiles copies media files to a store.
+func copyMediaFiles(dst MediaStore, media []Media, prog *progress) error {
+	prog.phase(ProgressMedia, len(media))
+	for _, m := range media {
+		w, err := dst.Create(m.Name())
+		if err != nil {
+			return err
+		}
+		if err = copyMedia(&progressWriter{WriteCloser: w, prog: prog}, m); err != nil {
+			return err
+		}
+		prog.step()
+	}
+	return nil
 }
 
 // Media is an interface for a media file.
@@ -159,16 +199,30 @@ type Media interface {
 	Open() (io.ReadCloser, error)
 }
 
-// media implements the Media interface.
-type media struct {
+// storeMedia is a media file held by a MediaStore.
+type storeMedia struct {
+	store MediaStore
+	name  string
+}
+
+func (m *storeMedia) Name() string {
+	return m.name
+}
+
+func (m *storeMedia) Open() (io.ReadCloser, error) {
+	return m.store.Open(m.name)
+}
+
+// fileMedia is a media file read from a path.
+type fileMedia struct {
 	name string
 	path string
 }
 
-func (m *media) Name() string {
+func (m *fileMedia) Name() string {
 	return m.name
 }
 
-func (m *media) Open() (io.ReadCloser, error) {
+func (m *fileMedia) Open() (io.ReadCloser, error) {
 	return os.Open(m.path)
 }
@@ -0,0 +1,265 @@
+package anki
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MediaStore stores the media files of a collection.
+// Names are slash-separated paths, as recorded in packages.
+type MediaStore interface {
+	// Open opens a media file for reading.
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates a media file and returns a writer.
+	// The file is stored once the writer is closed without error.
+	Create(name string) (io.WriteCloser, error)
+	// Stat returns information about a media file.
+	Stat(name string) (fs.FileInfo, error)
+	// Delete deletes a media file.
+	Delete(name string) error
+	// List lists the names of all media files.
+	List() iter.Seq2[string, error]
+}
+
+// DirMediaStore is a MediaStore that keeps media files in a directory.
+// It is the default store of collections.
+type DirMediaStore struct {
+	dir string
+}
+
+// NewDirMediaStore creates a media store backed by a directory.
+func NewDirMediaStore(dir string) *DirMediaStore {
+	return &DirMediaStore{dir: dir}
+}
+
+// path returns the path to a media file, rejecting names that would escape
+// the directory.
+func (s *DirMediaStore) path(name string) (string, error) {
+	if !validMediaName(name) {
+		return "", ErrInvalidMediaName
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
+
+// Open opens a media file for reading.
+func (s *DirMediaStore) Open(name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Create creates or truncates a media file and returns a writer.
+// The file is removed if writing to it fails.
+func (s *DirMediaStore) Create(name string) (io.WriteCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriteCloser{File: f, path: path}, nil
+}
+
+// Stat returns information about a media file.
+func (s *DirMediaStore) Stat(name string) (fs.FileInfo, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+// Delete deletes a media file.
+func (s *DirMediaStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// List lists the names of all media files, in lexical order.
+// A missing directory holds no files.
+func (s *DirMediaStore) List() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		fn := func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(s.dir, path)
+			if err != nil {
+				return err
+			}
+			if !yield(filepath.ToSlash(rel), nil) {
+				return errStopListing
+			}
+			return nil
+		}
+		err := filepath.WalkDir(s.dir, fn)
+		if err != nil && err != errStopListing && !errors.Is(err, fs.ErrNotExist) {
+			yield("", err)
+		}
+	}
+}
+
+// errStopListing stops walking a directory when iteration stops.
+var errStopListing = errors.New("stop listing")
+
+// fileWriteCloser is a writer that removes the file if an error occurs.
+type fileWriteCloser struct {
+	*os.File
+	path string
+	err  error
+}
+
+func (f *fileWriteCloser) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil {
+		f.err = err
+	}
+	return n, err
+}
+
+func (f *fileWriteCloser) Close() error {
+	err := f.File.Close()
+	if err != nil || f.err != nil {
+		_ = os.Remove(f.path)
+	}
+	if err == nil {
+		err = f.err
+	}
+	return err
+}
+
+// MemoryMediaStore is a MediaStore that keeps media files in memory.
+// It is safe for concurrent use.
+type MemoryMediaStore struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFile
+}
+
+// NewMemoryMediaStore creates an empty in-memory media store.
+func NewMemoryMediaStore() *MemoryMediaStore {
+	return &MemoryMediaStore{files: make(map[string]*memoryFile)}
+}
+
+// memoryFile is a media file held by a MemoryMediaStore.
+type memoryFile struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+// Open opens a media file for reading.
+func (s *MemoryMediaStore) Open(name string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Create creates or truncates a media file and returns a writer.
+func (s *MemoryMediaStore) Create(name string) (io.WriteCloser, error) {
+	if !validMediaName(name) {
+		return nil, ErrInvalidMediaName
+	}
+	return &memoryWriter{store: s, name: name}, nil
+}
+
+// Stat returns information about a media file.
+func (s *MemoryMediaStore) Stat(name string) (fs.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memoryFileInfo{f}, nil
+}
+
+// Delete deletes a media file.
+func (s *MemoryMediaStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+// List lists the names of all media files, in lexical order.
+func (s *MemoryMediaStore) List() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		s.mu.RLock()
+		names := make([]string, 0, len(s.files))
+		for name := range s.files {
+			names = append(names, name)
+		}
+		s.mu.RUnlock()
+
+		slices.Sort(names)
+		for _, name := range names {
+			if !yield(name, nil) {
+				return
+			}
+		}
+	}
+}
+
+// memoryWriter buffers the content of a media file until it is closed.
+type memoryWriter struct {
+	store *MemoryMediaStore
+	name  string
+	buf   bytes.Buffer
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	w.store.files[w.name] = &memoryFile{
+		name:    w.name,
+		data:    w.buf.Bytes(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// memoryFileInfo implements fs.FileInfo for a memoryFile.
+type memoryFileInfo struct {
+	f *memoryFile
+}
+
+func (fi *memoryFileInfo) Name() string       { return path.Base(fi.f.name) }
+func (fi *memoryFileInfo) Size() int64        { return int64(len(fi.f.data)) }
+func (fi *memoryFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi *memoryFileInfo) ModTime() time.Time { return fi.f.modTime }
+func (fi *memoryFileInfo) IsDir() bool        { return false }
+func (fi *memoryFileInfo) Sys() any           { return nil }
@@ -0,0 +1,85 @@
+package anki
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+// TestMediaStore tests the MediaStore implementations.
+func TestMediaStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func(t *testing.T) MediaStore
+	}{
+		{
+			name:  "dir",
+			store: func(t *testing.T) MediaStore { return NewDirMediaStore(t.TempDir()) },
+		},
+		{
+			name:  "memory",
+			store: func(t *testing.T) MediaStore { return NewMemoryMediaStore() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.store(t)
+			for _, name := range []string{"b.png", "a/c.mp3"} {
+				w, err := s.Create(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = io.WriteString(w, name); err != nil {
+					t.Fatal(err)
+				}
+				if err = w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.Create("../evil"); !errors.Is(err, ErrInvalidMediaName) {
+				t.Errorf("Create(../evil) error = %v, want %v", err, ErrInvalidMediaName)
+			}
+
+			var names []string
+			for name, err := range s.List() {
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, name)
+			}
+			if want := []string{"a/c.mp3", "b.png"}; !slices.Equal(names, want) {
+				t.Errorf("List() = %q, want %q", names, want)
+			}
+
+			fi, err := s.Stat("a/c.mp3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != int64(len("a/c.mp3")) {
+				t.Errorf("Stat().Size() = %d, want %d", fi.Size(), len("a/c.mp3"))
+			}
+
+			r, err := s.Open("b.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			_ = r.Close()
+			if err != nil || string(b) != "b.png" {
+				t.Errorf("Open() content = %q, %v, want %q", b, err, "b.png")
+			}
+
+			if err = s.Delete("b.png"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = s.Stat("b.png"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat() after Delete() error = %v, want %v", err, fs.ErrNotExist)
+			}
+			if err = s.Delete("b.png"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Delete() twice error = %v, want %v", err, fs.ErrNotExist)
+			}
+		})
+	}
+}
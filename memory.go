@@ -0,0 +1,221 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+)
+
+// CreateInMemory creates a new, empty collection held entirely in memory.
+// Media files are kept in a MemoryMediaStore, unless opts specifies another
+// store. Nothing is written to disk, and the collection has no source file,
+// so it can only be saved with WriteTo or SaveAs.
+func CreateInMemory(opts *CreateOptions) (*Collection, error) {
+	db, conn, err := openMemoryDatabase()
+	if err != nil {
+		return nil, err
+	}
+	if err = initDatabase(db); err != nil {
+		_ = closeMemoryDatabase(db, conn)
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	col, err := newMemoryCollection(db, conn, opts.MediaStore, opts.Clock)
+	if err != nil {
+		return nil, err
+	}
+	col.ids.guids = opts.DeterministicGUIDs
+	return col, nil
+}
+
+// ReadFromMemory reads a collection from an io.ReaderAt into memory.
+// The database and media files are held in memory, or in the media store of
+// opts, and nothing is written to disk. With LazyMedia, media files are left
+// in the package instead, and the io.ReaderAt must remain valid until the
+// collection is closed.
+func ReadFromMemory(r io.ReaderAt, size int64, opts *OpenOptions) (*Collection, error) {
+	return ReadFromMemoryContext(context.Background(), r, size, opts)
+}
+
+// ReadFromMemoryContext is like ReadFromMemory, but stops reading once ctx is
+// done.
+func ReadFromMemoryContext(ctx context.Context, r io.ReaderAt, size int64, opts *OpenOptions) (*Collection, error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	unpackOpts := unpackOptions(opts)
+	lim := newUnpackLimiter(unpackOpts)
+	prog := unpackProgress(unpackOpts)
+	meta, err := detectMetadata(zr)
+	if err != nil {
+		return nil, err
+	}
+	prog.phase(ProgressDatabase, 0)
+	var buf bytes.Buffer
+	if _, _, err = restoreFile(ctx, zr, meta, databaseName(meta), &buf, lim, prog); err != nil {
+		return nil, err
+	}
+
+	db, conn, err := openMemoryDatabase()
+	if err != nil {
+		return nil, err
+	}
+	// The database is a private copy, so it is upgraded even if the
+	// collection is opened read-only.
+	if err = loadMemoryDatabase(conn, buf.Bytes()); err == nil {
+		err = upgradeSchema(db)
+	}
+	if err != nil {
+		_ = closeMemoryDatabase(db, conn)
+		return nil, err
+	}
+
+	col, err := newMemoryCollection(db, conn, opts.MediaStore, opts.Clock)
+	if err != nil {
+		return nil, err
+	}
+	col.readOnly = opts.ReadOnly
+	col.version = meta.Version
+	col.ids.guids = opts.DeterministicGUIDs
+
+	if opts.LazyMedia {
+		col.pkg, err = readPackageMedia(zr, meta, lim)
+	} else {
+		err = restoreMediaEntries(ctx, zr, meta, col.store, lim, prog)
+	}
+	if err != nil {
+		_ = col.Close()
+		return nil, err
+	}
+	return col, nil
+}
+
+// newMemoryCollection creates a collection from an in-memory database,
+// keeping media files in store, or in memory if store is nil, and following
+// clock.
+func newMemoryCollection(db *sql.DB, conn *sql.Conn, store MediaStore, clock Clock) (*Collection, error) {
+	col, err := newCollection(db, "", false, clock)
+	if err != nil {
+		_ = closeMemoryDatabase(db, conn)
+		return nil, err
+	}
+	col.path = ""
+	col.mem = conn
+	col.store = store
+	if col.store == nil {
+		col.store = NewMemoryMediaStore()
+	}
+	return col, nil
+}
+
+// openMemoryDatabase opens a new in-memory database, along with a connection
+// to serialize it.
+func openMemoryDatabase() (*sql.DB, *sql.Conn, error) {
+	db, err := sqlite3OpenMemory()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+	return db, conn, nil
+}
+
+// closeMemoryDatabase closes an in-memory database, discarding its content.
+func closeMemoryDatabase(db *sql.DB, conn *sql.Conn) error {
+	_ = conn.Close()
+	return db.Close()
+}
+
+// loadMemoryDatabase loads the content of a database file into the in-memory
+// database of conn.
+func loadMemoryDatabase(conn *sql.Conn, b []byte) error {
+	// A deserialized database cannot grow past the size of its content, so
+	// the content goes through a private one.
+	src, err := privateMemoryDatabase(b)
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint:errcheck
+
+	srcConn, err := src.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close() //nolint:errcheck
+
+	return sqlite3Backup(conn, srcConn)
+}
+
+// privateMemoryDatabase opens a private in-memory database holding the
+// content of a database file.
+func privateMemoryDatabase(b []byte) (*sql.DB, error) {
+	db, err := sqlite3OpenMemory()
+	if err != nil {
+		return nil, err
+	}
+	if err = sqlite3Deserialize(db, b); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// memoryDatabase is the database of an in-memory collection.
+type memoryDatabase struct {
+	conn *sql.Conn
+}
+
+func (m memoryDatabase) open(legacy bool) (io.ReadCloser, error) {
+	b, err := sqlite3Serialize(m.conn)
+	if err != nil {
+		return nil, err
+	}
+	if legacy {
+		if b, err = downgradeMemoryDatabase(b); err != nil {
+			return nil, err
+		}
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// downgradeMemoryDatabase downgrades the content of a database file to the
+// legacy schema, in memory.
+func downgradeMemoryDatabase(b []byte) ([]byte, error) {
+	db, err := privateMemoryDatabase(b)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() //nolint:errcheck
+
+	if err = downgradeToSchema11(db); err != nil {
+		return nil, err
+	}
+	for _, query := range []string{
+		"PRAGMA temp_store = MEMORY", "VACUUM",
+	} {
+		if err = sqlExecute(db, query); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	return sqlite3Serialize(conn)
+}
@@ -0,0 +1,119 @@
+package anki
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/lftk/anki/pb"
+)
+
+// TestInMemoryRoundTrip tests writing an in-memory collection and reading it back.
+func TestInMemoryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		version pb.PackageMetadata_Version
+	}{
+		{name: "latest", version: pb.PackageMetadata_VERSION_LATEST},
+		{name: "legacy 2", version: pb.PackageMetadata_VERSION_LEGACY_2},
+		{name: "legacy 1", version: pb.PackageMetadata_VERSION_LEGACY_1},
+	}
+
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	if err = col.AddDeck(&Deck{Name: "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.WriteMedia("a.png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := col.WritePackage(&buf, &WriteOptions{Version: tt.version}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadFromMemory(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close() //nolint:errcheck
+
+			found := false
+			for deck, err := range got.ListDecks(nil) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				found = found || deck.Name == "Test"
+			}
+			if !found {
+				t.Error("deck not found")
+			}
+
+			r, err := got.OpenMedia("a.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close() //nolint:errcheck
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "png" {
+				t.Errorf("media = %q, want %q", b, "png")
+			}
+		})
+	}
+}
+
+// TestInMemoryUpdateWhileIterating tests that the rows of an in-memory
+// collection can be updated while they are iterated.
+func TestInMemoryUpdateWhileIterating(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	for _, front := range []string{"a", "b", "c"} {
+		if err = col.AddNote(1, &Note{NotetypeID: notetype.ID, Fields: []string{front, ""}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deck := &Deck{Name: "Moved"}
+	if err = col.AddDeck(deck); err != nil {
+		t.Fatal(err)
+	}
+	for card, err := range col.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = col.SetDeck([]int64{card.ID}, deck.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for card, err := range col.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if card.DeckID != deck.ID {
+			t.Errorf("card %d in deck %d, want %d", card.ID, card.DeckID, deck.ID)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package anki
 
 import (
+	"context"
 	"crypto/sha1"
 	"database/sql"
 	"encoding/binary"
@@ -35,29 +36,29 @@ func (c *Collection) GetNote(id int64) (*Note, error) {
 
 // AddNote adds a new note to the collection.
 func (c *Collection) AddNote(deckID int64, note *Note) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.transact(func(tx *sql.Tx) error {
 		notetype, err := getNotetype(tx, note.NotetypeID)
 		if err != nil {
 			return err
 		}
-		return addNote(tx, deckID, note, notetype)
+		return addNote(tx, deckID, note, notetype, c.ids)
 	})
 }
 
 // UpdateNote updates an existing note in the collection.
 func (c *Collection) UpdateNote(note *Note) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.transact(func(tx *sql.Tx) error {
 		notetype, err := getNotetype(tx, note.NotetypeID)
 		if err != nil {
 			return err
 		}
-		return updateNote(tx, note, notetype)
+		return updateNote(tx, note, notetype, c.ids)
 	})
 }
 
 // DeleteNote deletes a note from the collection by its ID.
 func (c *Collection) DeleteNote(id int64) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.transact(func(tx *sql.Tx) error {
 		return deleteNote(tx, id)
 	})
 }
@@ -81,10 +82,13 @@ func deleteNotes(e sqlExt, notetypeID int64) error {
 }
 
 // deleteNote deletes a note and its associated cards.
-func deleteNote(e sqlExecer, noteID int64) error {
+func deleteNote(e sqlExt, noteID int64) error {
 	if err := sqlExecute(e, deleteNoteQuery, noteID); err != nil {
 		return err
 	}
+	if err := deleteNoteIndex(e, noteID); err != nil {
+		return err
+	}
 	return deleteCards(e, noteID)
 }
 
@@ -98,6 +102,12 @@ func (c *Collection) ListNotes(opts *ListNotesOptions) iter.Seq2[*Note, error] {
 	return listNotes(c.db, opts)
 }
 
+// ListNotesContext is like ListNotes, but stops the iteration with an error
+// once ctx is done.
+func (c *Collection) ListNotesContext(ctx context.Context, opts *ListNotesOptions) iter.Seq2[*Note, error] {
+	return listNotes(sqlWithContext(ctx, c.db), opts)
+}
+
 func listNotes(q sqlQueryer, opts *ListNotesOptions) iter.Seq2[*Note, error] {
 	var args []any
 	var conds []string
@@ -118,29 +128,74 @@ func listNotes(q sqlQueryer, opts *ListNotesOptions) iter.Seq2[*Note, error] {
 }
 
 // addNote is an internal helper to add a note.
-func addNote(tx *sql.Tx, deckID int64, note *Note, notetype *Notetype) error {
+func addNote(tx *sql.Tx, deckID int64, note *Note, notetype *Notetype, ids *idAllocator) error {
+	if err := initNewNote(note, ids); err != nil {
+		return err
+	}
+	if err := insertNote(tx, note, notetype, ids); err != nil {
+		return err
+	}
+
+	for card, err := range generateCards(deckID, note, notetype, nil) {
+		if err != nil {
+			return err
+		}
+		if err = addCard(tx, card, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initNewNote allocates an ID from ids for a note about to be added, if it
+// has none, along with a GUID, and marks it as modified at the time of the
+// clock of ids.
+func initNewNote(note *Note, ids *idAllocator) error {
+	if note.ID == 0 {
+		note.ID = ids.next()
+	} else {
+		ids.use(note.ID)
+	}
 	if note.GUID == "" {
-		guid, err := randomGUID()
+		guid, err := ids.guid(note)
 		if err != nil {
 			return err
 		}
 		note.GUID = guid
 	}
-	note.Modified = time.Now()
+	note.Modified = ids.now()
 	note.USN = -1
+	return nil
+}
 
-	fld1, sfld, err := prepareNoteFields(note, notetype)
+// insertNote inserts a note as is, without generating cards for it.
+// If the note's ID is zero or already taken, a new ID is allocated from ids.
+func insertNote(e sqlExt, note *Note, notetype *Notetype, ids *idAllocator) error {
+	id := note.ID
+	if id == 0 {
+		id = ids.next()
+	}
+	args, err := noteArgs(id, note, notetype)
 	if err != nil {
 		return err
 	}
-	note.Checksum = fieldChecksum(fld1)
+	if note.ID, err = sqlInsert(e, addNoteQuery, args...); err != nil {
+		return err
+	}
+	ids.use(note.ID)
+	return updateNoteIndex(e, note, notetype)
+}
 
-	id := note.ID
-	if id == 0 {
-		id = time.Now().UnixMilli()
+// noteArgs computes the checksum of a note and returns the values of its
+// columns, with the given ID, in the order the insert queries expect them.
+func noteArgs(id int64, note *Note, notetype *Notetype) ([]any, error) {
+	fld1, sfld, err := prepareNoteFields(note, notetype)
+	if err != nil {
+		return nil, err
 	}
+	note.Checksum = fieldChecksum(fld1)
 
-	args := []any{
+	return []any{
 		id,
 		note.GUID,
 		note.NotetypeID,
@@ -152,25 +207,11 @@ func addNote(tx *sql.Tx, deckID int64, note *Note, notetype *Notetype) error {
 		note.Checksum,
 		note.Flags,
 		note.Data,
-	}
-	note.ID, err = sqlInsert(tx, addNoteQuery, args...)
-	if err != nil {
-		return err
-	}
-
-	for card, err := range generateCards(deckID, note, notetype, nil) {
-		if err != nil {
-			return err
-		}
-		if err = addCard(tx, card); err != nil {
-			return err
-		}
-	}
-	return nil
+	}, nil
 }
 
 // updateNote is an internal helper to update a note.
-func updateNote(tx *sql.Tx, note *Note, notetype *Notetype) error {
+func updateNote(tx *sql.Tx, note *Note, notetype *Notetype, ids *idAllocator) error {
 	oldNote, err := getNote(tx, note.ID)
 	if err != nil {
 		return err
@@ -201,20 +242,21 @@ func updateNote(tx *sql.Tx, note *Note, notetype *Notetype) error {
 			if err != nil {
 				return err
 			}
-			if err = addCard(tx, card); err != nil {
+			if err = addCard(tx, card, ids); err != nil {
 				return err
 			}
 		}
 	}
 
-	return updateNoteWithoutCards(tx, note, notetype)
+	return updateNoteWithoutCards(tx, note, notetype, ids)
 }
 
 // updateNoteWithoutCards updates the note's fields and metadata, but does not
-// handle card generation or deletion.
-func updateNoteWithoutCards(tx *sql.Tx, note *Note, notetype *Notetype) error {
+// handle card generation or deletion. The note is marked as modified at the
+// time of the clock of ids.
+func updateNoteWithoutCards(tx *sql.Tx, note *Note, notetype *Notetype, ids *idAllocator) error {
 	if note.GUID == "" {
-		guid, err := randomGUID()
+		guid, err := ids.guid(note)
 		if err != nil {
 			return err
 		}
@@ -222,7 +264,7 @@ func updateNoteWithoutCards(tx *sql.Tx, note *Note, notetype *Notetype) error {
 	}
 
 	note.USN = 0
-	note.Modified = time.Now()
+	note.Modified = ids.now()
 
 	fld1, sfld, err := prepareNoteFields(note, notetype)
 	if err != nil {
@@ -244,7 +286,10 @@ func updateNoteWithoutCards(tx *sql.Tx, note *Note, notetype *Notetype) error {
 		note.Data,
 		note.ID,
 	}
-	return sqlExecute(tx, updateNoteQuery, args...)
+	if err = sqlExecute(tx, updateNoteQuery, args...); err != nil {
+		return err
+	}
+	return updateNoteIndex(tx, note, notetype)
 }
 
 // prepareNoteFields prepares the first field and sort field for a note.
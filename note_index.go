@@ -0,0 +1,204 @@
+package anki
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// ErrNoFullTextIndex is returned when searching a collection whose full-text
+// index is not enabled.
+var ErrNoFullTextIndex = errors.New("full-text index not enabled")
+
+// EnableFullTextSearch creates a full-text index of the notes, which is then
+// kept up to date as notes are added, updated and deleted. The index holds
+// the text of the fields without HTML, and leaves out the fields excluded
+// from search in their notetype. Accents are ignored by the index, both in
+// the text and in searches.
+//
+// The index relies on the FTS5 extension of SQLite, which go-sqlite3 only
+// includes when built with the sqlite_fts5 tag. Packages in the latest
+// format keep the index, so it is still enabled once they are opened again,
+// while packages in the legacy formats and exported decks leave it out.
+func (c *Collection) EnableFullTextSearch() error {
+	return c.transact(func(tx *sql.Tx) error {
+		ok, err := hasNoteIndex(tx)
+		if err != nil || ok {
+			return err
+		}
+		if err = sqlExecute(tx, createNoteIndexQuery); err != nil {
+			if strings.Contains(err.Error(), "no such module") {
+				return fmt.Errorf("%w: build with the sqlite_fts5 tag", err)
+			}
+			return err
+		}
+
+		notetypes := make(map[int64]*Notetype)
+		for note, err := range listNotes(tx, nil) {
+			if err != nil {
+				return err
+			}
+			notetype, ok := notetypes[note.NotetypeID]
+			if !ok {
+				if notetype, err = getNotetype(tx, note.NotetypeID); err != nil {
+					return err
+				}
+				notetypes[note.NotetypeID] = notetype
+			}
+			if err = indexNote(tx, note, notetype); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DisableFullTextSearch drops the full-text index of the notes.
+func (c *Collection) DisableFullTextSearch() error {
+	return c.transact(func(tx *sql.Tx) error {
+		return sqlExecute(tx, dropNoteIndexQuery)
+	})
+}
+
+// FullTextSearchResult is a note found by a full-text search.
+type FullTextSearchResult struct {
+	NoteID int64
+	// Snippet is an excerpt of the text of the note, with the matches
+	// enclosed in <b> and </b>.
+	Snippet string
+	// Rank is the BM25 score of the note. The lower, the better it matches.
+	Rank float64
+}
+
+// FullTextSearchOptions specifies options for full-text searches.
+type FullTextSearchOptions struct {
+	// Limit is the maximum number of notes returned. Zero means no limit.
+	Limit int
+	// SnippetTokens is the maximum number of words in a snippet, 16 if zero.
+	SnippetTokens int
+}
+
+// FullTextSearch searches the full-text index of the notes, returning the
+// matching notes from best to worst. The query is written in the syntax of
+// SQLite's FTS5 extension: words, "quoted phrases", prefixes such as word*,
+// and AND, OR and NOT operators.
+func (c *Collection) FullTextSearch(query string, opts *FullTextSearchOptions) iter.Seq2[*FullTextSearchResult, error] {
+	return fullTextSearch(c.db, query, opts)
+}
+
+// FullTextSearchContext is like FullTextSearch, but stops the iteration with
+// an error once ctx is done.
+func (c *Collection) FullTextSearchContext(ctx context.Context, query string, opts *FullTextSearchOptions) iter.Seq2[*FullTextSearchResult, error] {
+	return fullTextSearch(sqlWithContext(ctx, c.db), query, opts)
+}
+
+func fullTextSearch(q sqlQueryer, query string, opts *FullTextSearchOptions) iter.Seq2[*FullTextSearchResult, error] {
+	ok, err := hasNoteIndex(q)
+	if err == nil && !ok {
+		err = ErrNoFullTextIndex
+	}
+	if err != nil {
+		return errorSeq[*FullTextSearchResult](err)
+	}
+
+	limit, tokens := -1, 16
+	if opts != nil {
+		if opts.Limit > 0 {
+			limit = opts.Limit
+		}
+		if opts.SnippetTokens > 0 {
+			// FTS5 rejects snippets of more than 64 tokens.
+			tokens = min(opts.SnippetTokens, 64)
+		}
+	}
+	return sqlSelectSeq(q, scanFullTextSearchResult, searchNoteIndexQuery, "<b>", "</b>", tokens, query, limit)
+}
+
+// scanFullTextSearchResult scans a full-text search result from a database
+// row.
+func scanFullTextSearchResult(_ sqlQueryer, row sqlRow) (*FullTextSearchResult, error) {
+	var r FullTextSearchResult
+	if err := row.Scan(&r.NoteID, &r.Snippet, &r.Rank); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// hasNoteIndex reports whether the full-text index of the notes is enabled.
+func hasNoteIndex(q sqlQueryer) (bool, error) {
+	n, err := sqlGet(q, scanValue[int], hasNoteIndexQuery)
+	return n > 0, err
+}
+
+// updateNoteIndex indexes a note, if the full-text index is enabled.
+func updateNoteIndex(e sqlExt, note *Note, notetype *Notetype) error {
+	ok, err := hasNoteIndex(e)
+	if err != nil || !ok {
+		return err
+	}
+	return indexNote(e, note, notetype)
+}
+
+// deleteNoteIndex removes a note from the full-text index, if it is enabled.
+func deleteNoteIndex(e sqlExt, noteID int64) error {
+	ok, err := hasNoteIndex(e)
+	if err != nil || !ok {
+		return err
+	}
+	return sqlExecute(e, deleteNoteIndexQuery, noteID)
+}
+
+// indexNote replaces the text of a note in the full-text index.
+func indexNote(e sqlExecer, note *Note, notetype *Notetype) error {
+	if err := sqlExecute(e, deleteNoteIndexQuery, note.ID); err != nil {
+		return err
+	}
+	return sqlExecute(e, addNoteIndexQuery, note.ID, noteIndexText(note, notetype))
+}
+
+// reindexNotes replaces the text of the notes of a notetype in the full-text
+// index, if it is enabled.
+func reindexNotes(tx *sql.Tx, notetype *Notetype) error {
+	ok, err := hasNoteIndex(tx)
+	if err != nil || !ok {
+		return err
+	}
+	opts := &ListNotesOptions{
+		NotetypeID: &notetype.ID,
+	}
+	for note, err := range listNotes(tx, opts) {
+		if err != nil {
+			return err
+		}
+		if err = indexNote(tx, note, notetype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noteIndexText returns the text of a note to index, one field per line.
+func noteIndexText(note *Note, notetype *Notetype) string {
+	var fields []string
+	for i, field := range note.Fields {
+		if i < len(notetype.Fields) && notetype.Fields[i].Config.GetExcludeFromSearch() {
+			continue
+		}
+		fields = append(fields, stripHTML(field))
+	}
+	return strings.Join(fields, "\n")
+}
+
+// excludedFieldsChanged reports whether a notetype excludes other fields
+// from search than its original, whose fields are in the same order.
+func excludedFieldsChanged(notetype, original *Notetype) bool {
+	for i, f := range notetype.Fields {
+		if f.Config.GetExcludeFromSearch() != original.Fields[i].Config.GetExcludeFromSearch() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package anki
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lftk/anki/pb"
+)
+
+// TestFullTextSearch tests the full-text index of the notes.
+func TestFullTextSearch(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	for _, err := range col.FullTextSearch("word", nil) {
+		if !errors.Is(err, ErrNoFullTextIndex) {
+			t.Fatalf("FullTextSearch() error = %v, want ErrNoFullTextIndex", err)
+		}
+	}
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back"), NewField("Notes")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	notetype.Fields[2].Config.ExcludeFromSearch = true
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	notes := []*Note{
+		{NotetypeID: notetype.ID, Fields: []string{"<b>café</b>", "coffee", "hidden"}},
+		{NotetypeID: notetype.ID, Fields: []string{"thé", "tea", "coffee"}},
+	}
+	if err = col.AddNote(1, notes[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = col.EnableFullTextSearch(); err != nil {
+		if strings.Contains(err.Error(), "sqlite_fts5") {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	}
+	if err = col.AddNotes(1, notes[1:]); err != nil {
+		t.Fatal(err)
+	}
+	notes[1].Fields[1] = "green tea"
+	if err = col.UpdateNote(notes[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string) []int64 {
+		t.Helper()
+		var ids []int64
+		for r, err := range col.FullTextSearch(query, nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, r.NoteID)
+		}
+		return ids
+	}
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{query: "cafe", want: []int64{notes[0].ID}},
+		{query: "coffee", want: []int64{notes[0].ID}},
+		{query: "b"},
+		{query: "hidden"},
+		{query: "green", want: []int64{notes[1].ID}},
+		{query: "the OR café", want: []int64{notes[0].ID, notes[1].ID}},
+	}
+	for _, tt := range tests {
+		got := search(tt.query)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("FullTextSearch(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for r, err := range col.FullTextSearch("cafe", nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "<b>café</b>\ncoffee"; r.Snippet != want {
+			t.Errorf("Snippet = %q, want %q", r.Snippet, want)
+		}
+	}
+
+	notetype, err = col.GetNotetype(notetype.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notetype.Fields[2].Config.ExcludeFromSearch = false
+	if err = col.UpdateNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := search("hidden"), []int64{notes[0].ID}; !slices.Equal(got, want) {
+		t.Errorf("FullTextSearch() after including a field = %v, want %v", got, want)
+	}
+
+	if err = col.DeleteNote(notes[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("cafe"); len(got) != 0 {
+		t.Errorf("FullTextSearch() after delete = %v, want none", got)
+	}
+}
+
+// TestFullTextSearchPackage tests which packages keep the full-text index.
+func TestFullTextSearchPackage(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	if err = col.EnableFullTextSearch(); err != nil {
+		if strings.Contains(err.Error(), "sqlite_fts5") {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version pb.PackageMetadata_Version
+		want    bool
+	}{
+		{name: "latest", version: pb.PackageMetadata_VERSION_LATEST, want: true},
+		{name: "legacy 2", version: pb.PackageMetadata_VERSION_LEGACY_2},
+		{name: "legacy 1", version: pb.PackageMetadata_VERSION_LEGACY_1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := col.WritePackage(&buf, &WriteOptions{Version: tt.version}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadFromMemory(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close() //nolint:errcheck
+			if ok, err := hasNoteIndex(got.db); err != nil || ok != tt.want {
+				t.Errorf("hasNoteIndex() = %v, %v, want %v", ok, err, tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if _, err = col.ExportDeck(&buf, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFromMemory(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close() //nolint:errcheck
+	if ok, err := hasNoteIndex(got.db); err != nil || ok {
+		t.Errorf("hasNoteIndex() of an exported deck = %v, %v, want false", ok, err)
+	}
+}
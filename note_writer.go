@@ -0,0 +1,208 @@
+package anki
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const (
+	// cardBatchSize is the number of cards inserted by a single statement.
+	cardBatchSize = 256
+	// cardColumns is the number of values of a card returned by cardArgs.
+	cardColumns = 18
+)
+
+// errNoteWriterClosed is returned when using a NoteWriter after it was closed.
+var errNoteWriterClosed = errors.New("note writer is closed")
+
+// NoteWriter adds notes to a collection in a single transaction, which is much
+// faster than adding them one by one with AddNote. Notetypes are looked up
+// once and cards are inserted in batches.
+//
+// The notes are committed when the writer is closed, and discarded if it is
+// aborted or a write fails. Other changes to the collection wait for the
+// writer to finish, or fail if they cannot. A NoteWriter is not safe for
+// concurrent use.
+type NoteWriter struct {
+	c         *Collection
+	tx        *sql.Tx
+	deckID    int64
+	notetypes map[int64]*Notetype
+	addNote   *sql.Stmt
+	addCards  *sql.Stmt
+	// indexed is whether the notes are added to the full-text index.
+	indexed bool
+	// cards holds the values of the cards waiting to be inserted.
+	cards []any
+	err   error
+}
+
+// NewNoteWriter creates a writer adding notes to the given deck.
+func (c *Collection) NewNoteWriter(deckID int64) (*NoteWriter, error) {
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	w := &NoteWriter{
+		c:         c,
+		tx:        tx,
+		deckID:    deckID,
+		notetypes: make(map[int64]*Notetype),
+	}
+	if err = w.prepare(); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return w, nil
+}
+
+// prepare prepares the statements of the writer.
+func (w *NoteWriter) prepare() error {
+	var err error
+	if w.addNote, err = w.tx.Prepare(insertNoteQuery); err != nil {
+		return err
+	}
+	if w.addCards, err = w.tx.Prepare(insertCardsSQL(cardBatchSize)); err != nil {
+		return err
+	}
+	w.indexed, err = hasNoteIndex(w.tx)
+	return err
+}
+
+// Write adds a note. If the note's ID is zero, a new ID is assigned;
+// otherwise the ID must not be taken.
+func (w *NoteWriter) Write(note *Note) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.write(note); err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// write is an internal helper to add a note.
+func (w *NoteWriter) write(note *Note) error {
+	notetype, err := w.notetype(note.NotetypeID)
+	if err != nil {
+		return err
+	}
+	if err = initNewNote(note, w.c.ids); err != nil {
+		return err
+	}
+
+	args, err := noteArgs(note.ID, note, notetype)
+	if err != nil {
+		return err
+	}
+	if _, err = w.addNote.Exec(args...); err != nil {
+		return err
+	}
+	if w.indexed {
+		if err = indexNote(w.tx, note, notetype); err != nil {
+			return err
+		}
+	}
+
+	for card, err := range generateCards(w.deckID, note, notetype, nil) {
+		if err != nil {
+			return err
+		}
+		card.ID = w.c.ids.next()
+		w.cards = append(w.cards, cardArgs(card.ID, card)...)
+		if len(w.cards) == cardBatchSize*cardColumns {
+			if _, err = w.addCards.Exec(w.cards...); err != nil {
+				return err
+			}
+			w.cards = w.cards[:0]
+		}
+	}
+	return nil
+}
+
+// notetype gets a notetype by ID, caching it for the following notes.
+func (w *NoteWriter) notetype(id int64) (*Notetype, error) {
+	if notetype, ok := w.notetypes[id]; ok {
+		return notetype, nil
+	}
+	notetype, err := getNotetype(w.tx, id)
+	if err != nil {
+		return nil, err
+	}
+	w.notetypes[id] = notetype
+	return notetype, nil
+}
+
+// Close inserts the remaining cards and commits the notes.
+func (w *NoteWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	mod := w.c.ids.now()
+	err := w.flush()
+	if err == nil {
+		err = sqlExecute(w.tx, setColModQuery, mod.UnixMilli())
+	}
+	if err != nil {
+		return w.fail(err)
+	}
+	w.err = errNoteWriterClosed
+	if err = w.tx.Commit(); err != nil {
+		return err
+	}
+	w.c.props.mod = mod
+	w.c.dirty.Store(true)
+	return nil
+}
+
+// flush inserts the cards waiting to be inserted.
+func (w *NoteWriter) flush() error {
+	if len(w.cards) == 0 {
+		return nil
+	}
+	_, err := w.tx.Exec(insertCardsSQL(len(w.cards)/cardColumns), w.cards...)
+	w.cards = w.cards[:0]
+	return err
+}
+
+// Abort discards the notes written so far.
+func (w *NoteWriter) Abort() error {
+	if w.err != nil {
+		return nil
+	}
+	w.err = errNoteWriterClosed
+	return w.tx.Rollback()
+}
+
+// fail rolls back the transaction after an error, which is returned by every
+// later call.
+func (w *NoteWriter) fail(err error) error {
+	_ = w.tx.Rollback()
+	w.err = err
+	return err
+}
+
+// AddNotes adds notes to the collection in a single transaction, using a
+// NoteWriter. Either all notes are added, or none.
+func (c *Collection) AddNotes(deckID int64, notes []*Note) error {
+	w, err := c.NewNoteWriter(deckID)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		if err = w.Write(note); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// insertCardsSQL returns a query inserting n cards at once.
+func insertCardsSQL(n int) string {
+	row := "(" + strings.Repeat("?, ", cardColumns-1) + "?)"
+	return insertCardsQuery + "\n  " + strings.Repeat(row+",\n  ", n-1) + row
+}
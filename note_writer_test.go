@@ -0,0 +1,75 @@
+package anki
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestAddNotes tests adding notes in bulk.
+func TestAddNotes(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:   "Basic (and reversed card)",
+		Config: NewNotetypeConfig("", false),
+		Fields: []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{
+			NewTemplate("Card 1", "{{Front}}", "{{Back}}"),
+			NewTemplate("Card 2", "{{Back}}", "{{Front}}"),
+		},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+
+	// More cards than fit in a batch, so that both full and partial batches
+	// are inserted.
+	notes := make([]*Note, cardBatchSize)
+	for i := range notes {
+		notes[i] = &Note{NotetypeID: notetype.ID, Fields: []string{fmt.Sprint("front ", i), "back"}}
+	}
+	if err = col.AddNotes(1, notes); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[int64]bool)
+	for _, note := range notes {
+		if ids[note.ID] {
+			t.Fatalf("note ID %d assigned twice", note.ID)
+		}
+		ids[note.ID] = true
+	}
+	cards := 0
+	for _, err := range col.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		cards++
+	}
+	if want := 2 * len(notes); cards != want {
+		t.Errorf("got %d cards, want %d", cards, want)
+	}
+
+	// A failed write discards the notes written before it.
+	err = col.AddNotes(1, []*Note{
+		{NotetypeID: notetype.ID, Fields: []string{"front", "back"}},
+		{NotetypeID: notetype.ID + 1, Fields: []string{"front", "back"}},
+	})
+	if err == nil {
+		t.Fatal("AddNotes() succeeded with an unknown notetype")
+	}
+	n := 0
+	for _, err := range col.ListNotes(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != len(notes) {
+		t.Errorf("got %d notes, want %d", n, len(notes))
+	}
+}
@@ -1,7 +1,9 @@
 package anki
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"iter"
 	"maps"
 	"slices"
@@ -44,14 +46,14 @@ type Field struct {
 }
 
 // NewField creates a new field with the given name and default configuration.
-// The ordinal is initialized to -1 and will be set when added to a notetype.
+// The ordinal is initialized to -1 and will be set when added to a notetype,
+// along with the ID of the configuration.
 func NewField(name string) *Field {
-	id := time.Now().UnixMilli()
 	return &Field{
 		Ordinal: -1,
 		Name:    name,
 		Config: &pb.FieldConfig{
-			Id:                &id,
+			Id:                nil,
 			Sticky:            false,
 			Rtl:               false,
 			PlainText:         false,
@@ -78,16 +80,16 @@ type Template struct {
 
 // NewTemplate creates a new template with the given name, question format,
 // and answer format.
-// The ordinal is initialized to -1 and will be set when added to a notetype.
+// The ordinal is initialized to -1 and will be set when added to a notetype,
+// along with the ID of the configuration.
 func NewTemplate(name, qfmt, afmt string) *Template {
-	id := time.Now().UnixMilli()
 	return &Template{
 		Ordinal:  -1,
 		Name:     name,
 		Modified: timeZero(),
 		USN:      0,
 		Config: &pb.TemplateConfig{
-			Id:      &id,
+			Id:      nil,
 			QFormat: qfmt,
 			AFormat: afmt,
 		},
@@ -101,42 +103,73 @@ func (c *Collection) GetNotetype(id int64) (*Notetype, error) {
 
 // AddNotetype adds a new notetype to the collection.
 func (c *Collection) AddNotetype(notetype *Notetype) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
-		id := notetype.ID
-		if id == 0 {
-			id = time.Now().UnixMilli()
-		}
+	return c.transact(func(tx *sql.Tx) error {
+		return addNotetype(tx, notetype, c.ids)
+	})
+}
 
-		notetype.Modified = time.Now()
-		notetype.USN = -1
+// addNotetype is an internal helper to add a notetype with its fields and templates.
+// If the notetype's ID is zero, a new ID is allocated from ids.
+func addNotetype(tx *sql.Tx, notetype *Notetype, ids *idAllocator) error {
+	id := notetype.ID
+	if id == 0 {
+		id = ids.next()
+	}
 
-		if notetype.Config == nil {
-			notetype.Config = &pb.NotetypeConfig{}
-		}
-		config, err := proto.Marshal(notetype.Config)
-		if err != nil {
-			return err
-		}
+	notetype.Modified = ids.now()
+	notetype.USN = -1
 
-		args := []any{
-			id,
-			notetype.Name,
-			timeUnix(notetype.Modified),
-			notetype.USN,
-			config,
-		}
-		notetype.ID, err = sqlInsert(tx, addNotetypeQuery, args...)
-		if err != nil {
-			return err
-		}
+	if notetype.Config == nil {
+		notetype.Config = &pb.NotetypeConfig{}
+	}
+	config, err := proto.Marshal(notetype.Config)
+	if err != nil {
+		return err
+	}
 
-		return addFieldsAndTemplates(tx, notetype)
-	})
+	args := []any{
+		id,
+		notetype.Name,
+		timeUnix(notetype.Modified),
+		notetype.USN,
+		config,
+	}
+	notetype.ID, err = sqlInsert(tx, addNotetypeQuery, args...)
+	if err != nil {
+		return err
+	}
+	ids.use(notetype.ID)
+
+	return addFieldsAndTemplates(tx, notetype, ids)
+}
+
+// UpdateNotetypeOptions specifies options for updating a notetype.
+type UpdateNotetypeOptions struct {
+	// Progress receives progress reports while the notes of the notetype are
+	// rewritten, if not nil.
+	Progress ProgressFunc
 }
 
 // UpdateNotetype updates an existing notetype in the collection.
 func (c *Collection) UpdateNotetype(notetype *Notetype) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.UpdateNotetypeWithOptions(notetype, nil)
+}
+
+// UpdateNotetypeWithOptions is like UpdateNotetype, but updates the notetype
+// with the given options.
+func (c *Collection) UpdateNotetypeWithOptions(notetype *Notetype, opts *UpdateNotetypeOptions) error {
+	return c.UpdateNotetypeContext(context.Background(), notetype, opts)
+}
+
+// UpdateNotetypeContext is like UpdateNotetypeWithOptions, but rolls back the update
+// once ctx is done, which can take a while when every note of the notetype
+// is rewritten.
+func (c *Collection) UpdateNotetypeContext(ctx context.Context, notetype *Notetype, opts *UpdateNotetypeOptions) error {
+	var prog *progress
+	if opts != nil {
+		prog = newProgress(opts.Progress)
+	}
+	return c.transactContext(ctx, func(tx *sql.Tx) error {
 		original, err := getNotetype(tx, notetype.ID)
 		if err != nil {
 			return err
@@ -149,17 +182,22 @@ func (c *Collection) UpdateNotetype(notetype *Notetype) error {
 			}
 		}
 
-		err = updateNotesForChangedFields(tx, notetype, len(original.Fields), original.Config.GetSortFieldIdx())
+		err = updateNotesForChangedFields(tx, notetype, len(original.Fields), original.Config.GetSortFieldIdx(), c.ids, prog)
 		if err != nil {
 			return err
 		}
+		if len(notetype.Fields) == len(original.Fields) && excludedFieldsChanged(notetype, original) {
+			if err = reindexNotes(tx, notetype); err != nil {
+				return err
+			}
+		}
 
-		err = updateCardsForChangedTemplates(tx, notetype, original.Templates)
+		err = updateCardsForChangedTemplates(tx, notetype, original.Templates, c.ids)
 		if err != nil {
 			return err
 		}
 
-		notetype.Modified = time.Now()
+		notetype.Modified = c.ids.now()
 		notetype.USN = -1
 
 		config, err := proto.Marshal(notetype.Config)
@@ -186,7 +224,7 @@ func (c *Collection) UpdateNotetype(notetype *Notetype) error {
 			}
 		}
 
-		return addFieldsAndTemplates(tx, notetype)
+		return addFieldsAndTemplates(tx, notetype, c.ids)
 	})
 }
 
@@ -227,26 +265,44 @@ func updateTemplatesForChangedFields(templates []*Template, fields map[string]st
 
 // updateNotesForChangedFields handles updates to notes when the notetype's field
 // structure changes (e.g., fields are added, removed, or reordered).
-func updateNotesForChangedFields(tx *sql.Tx, notetype *Notetype, previousFieldCount int, previousSortIdx uint32) error {
+func updateNotesForChangedFields(tx *sql.Tx, notetype *Notetype, previousFieldCount int, previousSortIdx uint32, ids *idAllocator, prog *progress) error {
 	ords := sliceMap(notetype.Fields, func(f *Field) int {
 		return f.Ordinal
 	})
 	changed := fieldOrdsChanged(ords, previousFieldCount)
-	if changed || notetype.Config.GetSortFieldIdx() != previousSortIdx {
-		opts := &ListNotesOptions{
-			NotetypeID: &notetype.ID,
+	if !changed {
+		sortIdx := notetype.Config.GetSortFieldIdx()
+		if sortIdx == previousSortIdx {
+			return nil
+		}
+		// Only the sort field changed, which SQLite can recompute on its own.
+		// The notes are left untouched if it is out of range, as when their
+		// fields are prepared one by one.
+		if int(sortIdx) >= len(notetype.Fields) {
+			return fmt.Errorf("sort_field_idx %d is out of bounds for notetype with %d fields", sortIdx, len(notetype.Fields))
+		}
+		args := []any{timeUnix(ids.now()), 0, sortIdx, processTextStripHTML, notetype.ID}
+		return sqlExecute(tx, updateSortFieldsQuery, args...)
+	}
+	if prog != nil {
+		total, err := sqlGet(tx, scanValue[int], countNotesQuery, notetype.ID)
+		if err != nil {
+			return err
 		}
-		for note, err := range listNotes(tx, opts) {
-			if err != nil {
-				return err
-			}
-			if changed {
-				reorderNoteFields(note, ords)
-			}
-			if err = updateNoteWithoutCards(tx, note, notetype); err != nil {
-				return err
-			}
+		prog.phase(ProgressNotes, total)
+	}
+	opts := &ListNotesOptions{
+		NotetypeID: &notetype.ID,
+	}
+	for note, err := range listNotes(tx, opts) {
+		if err != nil {
+			return err
 		}
+		reorderNoteFields(note, ords)
+		if err = updateNoteWithoutCards(tx, note, notetype, ids); err != nil {
+			return err
+		}
+		prog.step()
 	}
 	return nil
 }
@@ -276,7 +332,7 @@ func reorderNoteFields(note *Note, ords []int) {
 
 // updateCardsForChangedTemplates handles card generation, deletion, and updates
 // when the notetype's templates are modified.
-func updateCardsForChangedTemplates(tx *sql.Tx, notetype *Notetype, ordTemplates []*Template) error {
+func updateCardsForChangedTemplates(tx *sql.Tx, notetype *Notetype, ordTemplates []*Template, ids *idAllocator) error {
 	ords := sliceMap(notetype.Templates, func(t *Template) int {
 		return t.Ordinal
 	})
@@ -308,7 +364,7 @@ func updateCardsForChangedTemplates(tx *sql.Tx, notetype *Notetype, ordTemplates
 			if err != nil {
 				return err
 			}
-			card.Modified = time.Now()
+			card.Modified = ids.now()
 			card.Ordinal = moved[card.Ordinal]
 			if err = updateCard(tx, card); err != nil {
 				return err
@@ -350,7 +406,7 @@ func updateCardsForChangedTemplates(tx *sql.Tx, notetype *Notetype, ordTemplates
 				if err != nil {
 					return err
 				}
-				if err = addCard(tx, card); err != nil {
+				if err = addCard(tx, card, ids); err != nil {
 					return err
 				}
 			}
@@ -392,7 +448,7 @@ func equalTemplateFronts(tmpls1, tmpls2 []*Template) bool {
 
 // DeleteNotetype deletes a notetype by its ID.
 func (c *Collection) DeleteNotetype(id int64) error {
-	return sqlTransact(c.db, func(tx *sql.Tx) error {
+	return c.transact(func(tx *sql.Tx) error {
 		if err := sqlExecute(tx, deleteNotetypeQuery, id); err != nil {
 			return err
 		}
@@ -432,10 +488,17 @@ func getNotetype(q sqlQueryer, id int64) (*Notetype, error) {
 }
 
 // addFieldsAndTemplates adds all fields and templates from a notetype struct to the database.
-// It sets the ordinal for each field and template based on its slice index.
-func addFieldsAndTemplates(tx *sql.Tx, notetype *Notetype) error {
+// It sets the ordinal for each field and template based on its slice index,
+// and allocates an ID from ids for each configuration that has none.
+func addFieldsAndTemplates(tx *sql.Tx, notetype *Notetype, ids *idAllocator) error {
 	for i, f := range notetype.Fields {
 		f.Ordinal = i
+		if f.Config == nil {
+			f.Config = &pb.FieldConfig{}
+		}
+		if f.Config.Id == nil {
+			f.Config.Id = proto.Int64(ids.next())
+		}
 		if err := addField(tx, notetype.ID, f); err != nil {
 			return err
 		}
@@ -444,6 +507,12 @@ func addFieldsAndTemplates(tx *sql.Tx, notetype *Notetype) error {
 	for i, t := range notetype.Templates {
 		t.Ordinal = i
 		t.Modified = notetype.Modified
+		if t.Config == nil {
+			t.Config = &pb.TemplateConfig{}
+		}
+		if t.Config.Id == nil {
+			t.Config.Id = proto.Int64(ids.next())
+		}
 		if err := addTemplate(tx, notetype.ID, t); err != nil {
 			return err
 		}
@@ -454,9 +523,6 @@ func addFieldsAndTemplates(tx *sql.Tx, notetype *Notetype) error {
 
 // addField adds a field to a notetype.
 func addField(tx *sql.Tx, notetypeID int64, field *Field) error {
-	if field.Config == nil {
-		field.Config = &pb.FieldConfig{}
-	}
 	config, err := proto.Marshal(field.Config)
 	if err != nil {
 		return err
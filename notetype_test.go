@@ -0,0 +1,122 @@
+package anki
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestUpdateNotetypeSortField tests changing the sort field of a notetype.
+func TestUpdateNotetypeSortField(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "<b>back</b>"}}
+	if err = col.AddNote(1, note); err != nil {
+		t.Fatal(err)
+	}
+	sortField := func() string {
+		t.Helper()
+		sfld, err := sqlGet(col.db, scanValue[string], "SELECT sfld FROM notes WHERE id = ?", note.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sfld
+	}
+
+	tests := []struct {
+		name    string
+		idx     uint32
+		want    string
+		wantErr bool
+	}{
+		{name: "second field", idx: 1, want: "back"},
+		{name: "out of range", idx: 2, want: "back", wantErr: true},
+		{name: "first field", idx: 0, want: "front"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notetype, err := col.GetNotetype(notetype.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notetype.Config.SortFieldIdx = tt.idx
+			if err = col.UpdateNotetype(notetype); (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateNotetype() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := sortField(); got != tt.want {
+				t.Errorf("sort field = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUpdateNotetypeContext tests that cancelling an update of a notetype
+// leaves the notetype and its notes as they were.
+func TestUpdateNotetypeContext(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	notes := make([]*Note, 10)
+	for i := range notes {
+		notes[i] = &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+	}
+	if err = col.AddNotes(1, notes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The update is cancelled once the first notes were rewritten.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notetype.Fields = append(notetype.Fields, NewField("Extra"))
+	err = col.UpdateNotetypeContext(ctx, notetype, &UpdateNotetypeOptions{
+		Progress: func(p Progress) {
+			if p.Phase == ProgressNotes && p.Done == 2 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("UpdateNotetypeContext() error = %v, want context.Canceled", err)
+	}
+
+	got, err := col.GetNotetype(notetype.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Fields) != 2 {
+		t.Errorf("notetype has %d fields after a cancelled update, want 2", len(got.Fields))
+	}
+	for note, err := range col.ListNotes(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(note.Fields) != 2 {
+			t.Errorf("note has %d fields after a cancelled update, want 2", len(note.Fields))
+		}
+	}
+}
@@ -2,42 +2,238 @@ package anki
 
 import (
 	"archive/zip"
+	"bytes"
+	"cmp"
+	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/lftk/anki/pb"
 	"google.golang.org/protobuf/proto"
 )
 
+// PackOptions specifies options for packing a collection.
+type PackOptions struct {
+	// Version is the package format to write. The zero value selects
+	// pb.PackageMetadata_VERSION_LATEST. The legacy versions produce an
+	// uncompressed database at schema 11 and a JSON media map, which can be
+	// read by older clients.
+	Version pb.PackageMetadata_Version
+	// MediaStore is the store to read media files from. If nil, the media
+	// folder of the collection directory is used.
+	MediaStore MediaStore
+	// Progress receives progress reports, if not nil.
+	Progress ProgressFunc
+	// Deterministic makes the package depend on the content of the
+	// collection only, so that packing the same content twice produces the
+	// same bytes: zip headers carry a fixed time, media files are stored in
+	// the order of their names, and zstd compression uses a fixed
+	// configuration, which is slower since it runs on a single goroutine.
+	Deterministic bool
+}
+
 // Pack packs a collection into a zip file.
 func Pack(w *zip.Writer, dir string) error {
+	return PackContext(context.Background(), w, dir, nil)
+}
+
+// PackContext packs a collection into a zip file using the given options,
+// stopping once ctx is done.
+func PackContext(ctx context.Context, w *zip.Writer, dir string, opts *PackOptions) error {
+	var store MediaStore = NewDirMediaStore(mediaDir(dir))
+	if opts != nil && opts.MediaStore != nil {
+		store = opts.MediaStore
+	}
+	return pack(ctx, w, fileDatabase(databasePath(dir)), store, nil, opts)
+}
+
+// pack packs a database and the media files of a store into a zip file,
+// along with the media files left in a package, if pkg is not nil.
+func pack(ctx context.Context, w *zip.Writer, db databaseSource, store MediaStore, pkg *packageMedia, opts *PackOptions) error {
 	meta := &pb.PackageMetadata{
 		Version: pb.PackageMetadata_VERSION_LATEST,
 	}
-	if err := writeMetadata(w, meta); err != nil {
-		return err
+	if opts != nil && opts.Version != pb.PackageMetadata_VERSION_UNKNOWN {
+		meta.Version = opts.Version
+	}
+	var prog *progress
+	zw := &zipWriter{Writer: w}
+	if opts != nil {
+		prog = newProgress(opts.Progress)
+		zw.deterministic = opts.Deterministic
 	}
-	if err := writeDatabase(w, databasePath(dir)); err != nil {
+	if !isLegacyVersion(meta) {
+		if err := writeMetadata(zw, meta); err != nil {
+			return err
+		}
+	}
+	if err := writeDatabase(ctx, zw, meta, db, prog); err != nil {
 		return err
 	}
-	return writeMediaEntries(w, mediaDir(dir))
+	return writeMediaEntries(ctx, zw, meta, store, pkg, prog)
+}
+
+// UnpackOptions specifies options for unpacking a collection.
+type UnpackOptions struct {
+	// MaxSize limits the total number of bytes produced when decompressing
+	// the database, the media manifest and the media files. Zero means no limit.
+	MaxSize int64
+	// MaxEntries limits the number of media entries in the package.
+	// Zero means no limit.
+	MaxEntries int
+	// MediaStore is the store to write media files to. If nil, they are
+	// written to the media folder of the collection directory.
+	MediaStore MediaStore
+	// Progress receives progress reports, if not nil.
+	Progress ProgressFunc
+}
+
+var (
+	// ErrInvalidMediaName is returned when a media entry has a name that
+	// would escape the media directory.
+	ErrInvalidMediaName = errors.New("invalid media name")
+	// ErrSizeLimitExceeded is returned when the decompressed size of a
+	// package exceeds UnpackOptions.MaxSize.
+	ErrSizeLimitExceeded = errors.New("decompressed size limit exceeded")
+	// ErrEntryLimitExceeded is returned when a package holds more media
+	// entries than UnpackOptions.MaxEntries.
+	ErrEntryLimitExceeded = errors.New("media entry limit exceeded")
+	// ErrChecksumMismatch is returned when the SHA-1 of a media file does not
+	// match the one recorded in the package.
+	ErrChecksumMismatch = errors.New("media checksum mismatch")
+	// ErrSizeMismatch is returned when the size of a media file does not
+	// match the one recorded in the package.
+	ErrSizeMismatch = errors.New("media size mismatch")
+)
+
+// MediaEntryError records an error unpacking a single media entry.
+type MediaEntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *MediaEntryError) Error() string {
+	return fmt.Sprintf("media entry %q: %v", e.Name, e.Err)
+}
+
+func (e *MediaEntryError) Unwrap() error {
+	return e.Err
 }
 
 // Unpack unpacks a collection from a zip file.
+// Media names are checked so that they cannot escape dir, and media files are
+// verified against the SHA-1 and size recorded in the package, if present.
 func Unpack(r *zip.Reader, dir string) error {
+	return UnpackContext(context.Background(), r, dir, nil)
+}
+
+// UnpackContext is like Unpack, but enforces the limits of the given options
+// and stops unpacking once ctx is done.
+func UnpackContext(ctx context.Context, r *zip.Reader, dir string, opts *UnpackOptions) error {
+	_, err := unpack(ctx, r, dir, opts)
+	return err
+}
+
+// unpack unpacks a collection from a zip file, returning the package metadata.
+func unpack(ctx context.Context, r *zip.Reader, dir string, opts *UnpackOptions) (*pb.PackageMetadata, error) {
+	lim := newUnpackLimiter(opts)
+	prog := unpackProgress(opts)
 	meta, err := detectMetadata(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err = restoreDatabase(r, meta, databasePath(dir)); err != nil {
-		return err
+	if err = restoreDatabase(ctx, r, meta, databasePath(dir), lim, prog); err != nil {
+		return nil, err
+	}
+	store, err := unpackMediaStore(dir, opts)
+	if err != nil {
+		return nil, err
 	}
-	return restoreMediaEntries(r, meta, mediaDir(dir))
+	return meta, restoreMediaEntries(ctx, r, meta, store, lim, prog)
+}
+
+// unpackMediaStore returns the store to unpack media files to, creating the
+// media folder of the collection directory if no store is given.
+func unpackMediaStore(dir string, opts *UnpackOptions) (MediaStore, error) {
+	if opts != nil && opts.MediaStore != nil {
+		return opts.MediaStore, nil
+	}
+	if err := os.Mkdir(mediaDir(dir), 0755); err != nil {
+		return nil, err
+	}
+	return NewDirMediaStore(mediaDir(dir)), nil
+}
+
+// unpackProgress returns a tracker reporting to the progress function in
+// opts, if any.
+func unpackProgress(opts *UnpackOptions) *progress {
+	if opts == nil {
+		return nil
+	}
+	return newProgress(opts.Progress)
+}
+
+// unpackLimiter enforces the limits of UnpackOptions while unpacking.
+type unpackLimiter struct {
+	remaining  int64
+	maxEntries int
+}
+
+// newUnpackLimiter creates a limiter from the given options.
+func newUnpackLimiter(opts *UnpackOptions) *unpackLimiter {
+	lim := &unpackLimiter{remaining: -1}
+	if opts != nil {
+		if opts.MaxSize > 0 {
+			lim.remaining = opts.MaxSize
+		}
+		lim.maxEntries = opts.MaxEntries
+	}
+	return lim
+}
+
+// reader wraps r so that reading fails once the size budget is exhausted.
+func (lim *unpackLimiter) reader(r io.Reader) io.Reader {
+	if lim == nil || lim.remaining < 0 {
+		return r
+	}
+	return &limitedReader{r: r, lim: lim}
+}
+
+// checkEntries checks the number of media entries against the limit.
+func (lim *unpackLimiter) checkEntries(n int) error {
+	if lim != nil && lim.maxEntries > 0 && n > lim.maxEntries {
+		return ErrEntryLimitExceeded
+	}
+	return nil
+}
+
+// limitedReader is a reader that draws from a shared size budget.
+type limitedReader struct {
+	r   io.Reader
+	lim *unpackLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// Allow reading one byte past the budget, so that we can tell an input
+	// that fits exactly apart from one that is too large.
+	if int64(len(p)) > l.lim.remaining+1 {
+		p = p[:l.lim.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.lim.remaining -= int64(n)
+	if l.lim.remaining < 0 {
+		return n, ErrSizeLimitExceeded
+	}
+	return n, err
 }
 
 // isLegacyVersion checks if the package metadata is for a legacy version.
@@ -73,71 +269,227 @@ func mediaDir(dir string) string {
 	return filepath.Join(dir, "media")
 }
 
-// restoreFile restores a file from a zip archive.
-func restoreFile(r *zip.Reader, meta *pb.PackageMetadata, name string, path string) error {
+// restoreFile restores a file from a zip archive to a writer, returning the
+// SHA-1 hash and size of its content.
+func restoreFile(ctx context.Context, r *zip.Reader, meta *pb.PackageMetadata, name string, dst io.Writer, lim *unpackLimiter, prog *progress) ([]byte, int64, error) {
 	src, err := zipOpen(r, name, zstdCompressed(meta))
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	defer src.Close() //nolint:errcheck
 
+	h := sha1.New()
+	n, err := io.Copy(io.MultiWriter(dst, h, prog), lim.reader(readerWithContext(ctx, src)))
+	if err != nil {
+		return nil, n, err
+	}
+	return h.Sum(nil), n, nil
+}
+
+// restoreDatabase restores the database from a zip archive.
+func restoreDatabase(ctx context.Context, r *zip.Reader, meta *pb.PackageMetadata, path string, lim *unpackLimiter, prog *progress) error {
+	prog.phase(ProgressDatabase, 0)
 	dst, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer dst.Close() //nolint:errcheck
 
-	_, err = io.Copy(dst, src)
-	return err
-}
-
-// restoreDatabase restores the database from a zip archive.
-func restoreDatabase(r *zip.Reader, meta *pb.PackageMetadata, path string) error {
-	return restoreFile(r, meta, databaseName(meta), path)
+	if _, _, err = restoreFile(ctx, r, meta, databaseName(meta), dst, lim, prog); err != nil {
+		return err
+	}
+	return dst.Close()
 }
 
 // writeDatabase writes the database to a zip archive.
-func writeDatabase(w *zip.Writer, path string) error {
-	src, err := os.Open(path)
+func writeDatabase(ctx context.Context, w *zipWriter, meta *pb.PackageMetadata, db databaseSource, prog *progress) error {
+	prog.phase(ProgressDatabase, 0)
+	src, err := db.open(isLegacyVersion(meta))
 	if err != nil {
 		return err
 	}
 	defer src.Close() //nolint:errcheck
 
-	dst, err := zipCreate(w, "collection.anki21b", true)
+	dst, err := zipCreate(w, databaseName(meta), zstdCompressed(meta))
 	if err != nil {
 		return err
 	}
 	defer dst.Close() //nolint:errcheck
 
-	_, err = io.Copy(dst, src)
+	_, err = io.Copy(dst, readerWithContext(ctx, io.TeeReader(src, prog)))
 	return err
 }
 
-// restoreMediaEntries restores media entries from a zip archive.
-func restoreMediaEntries(r *zip.Reader, meta *pb.PackageMetadata, dir string) error {
-	if err := os.Mkdir(dir, 0755); err != nil {
+// databaseSource gives access to the content of a collection database, as it
+// is stored in a package.
+type databaseSource interface {
+	// open opens the content of the database for reading, downgraded to the
+	// legacy schema if legacy is true.
+	open(legacy bool) (io.ReadCloser, error)
+}
+
+// fileDatabase is a database stored in a file.
+type fileDatabase string
+
+func (path fileDatabase) open(legacy bool) (io.ReadCloser, error) {
+	if !legacy {
+		return os.Open(string(path))
+	}
+
+	dir, err := os.MkdirTemp("", "anki-*")
+	if err != nil {
+		return nil, err
+	}
+	legacyPath := databasePath(dir)
+	if err = copyFile(string(path), legacyPath); err == nil {
+		err = downgradeDatabase(legacyPath)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(legacyPath)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return &tempFile{File: f, dir: dir}, nil
+}
+
+// tempFile is a file that removes its temporary directory once it is closed.
+type tempFile struct {
+	*os.File
+	dir string
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	_ = os.RemoveAll(f.dir)
+	return err
+}
+
+// copyDatabase copies the content of a database to a file.
+func copyDatabase(db databaseSource, path string, prog *progress) error {
+	r, err := db.open(false)
+	if err != nil {
 		return err
 	}
-	media, err := readMediaEntries(r)
+	defer r.Close() //nolint:errcheck
+
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close() //nolint:errcheck
+
+	if _, err = io.Copy(w, io.TeeReader(r, prog)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// downgradeDatabase downgrades the database at path to the legacy schema,
+// leaving it in rollback journal mode so that it is a single self-contained file.
+func downgradeDatabase(path string) error {
+	db, err := sqlite3Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close() //nolint:errcheck
+
+	if err = downgradeToSchema11(db); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"PRAGMA journal_mode = DELETE", "VACUUM",
+	} {
+		if err = sqlExecute(db, query); err != nil {
+			return err
+		}
+	}
+	return db.Close()
+}
+
+// restoreMediaEntries restores media entries from a zip archive.
+func restoreMediaEntries(ctx context.Context, r *zip.Reader, meta *pb.PackageMetadata, store MediaStore, lim *unpackLimiter, prog *progress) error {
+	media, err := readMediaEntries(r, meta, lim)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			err = nil
 		}
 		return err
 	}
+	if err = lim.checkEntries(len(media.Entries)); err != nil {
+		return err
+	}
+	prog.phase(ProgressMedia, len(media.Entries))
 	for i, entry := range media.Entries {
-		err = restoreFile(r, meta, fmt.Sprint(i), filepath.Join(dir, entry.Name))
-		if err != nil {
-			return err
+		if err = restoreMediaEntry(ctx, r, meta, i, entry, store, lim, prog); err != nil {
+			return &MediaEntryError{Name: entry.Name, Err: err}
 		}
+		prog.step()
 	}
 	return nil
 }
 
+// restoreMediaEntry restores a single media entry, verifying it against the
+// SHA-1 and size recorded in the package.
+// The file is removed from the store if it fails verification.
+func restoreMediaEntry(ctx context.Context, r *zip.Reader, meta *pb.PackageMetadata, i int, entry *pb.MediaEntries_MediaEntry, store MediaStore, lim *unpackLimiter, prog *progress) error {
+	if !validMediaName(entry.Name) {
+		return ErrInvalidMediaName
+	}
+	dst, err := store.Create(entry.Name)
+	if err != nil {
+		return err
+	}
+	sum, size, err := restoreFile(ctx, r, meta, mediaEntryZipName(i, entry), dst, lim, prog)
+	if err != nil {
+		_ = dst.Close()
+		_ = store.Delete(entry.Name)
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	// Legacy packages do not record checksums or sizes.
+	if len(entry.Sha1) == 0 {
+		return nil
+	}
+	switch {
+	case size != int64(entry.Size):
+		err = ErrSizeMismatch
+	case !bytes.Equal(sum, entry.Sha1):
+		err = ErrChecksumMismatch
+	}
+	if err != nil {
+		_ = store.Delete(entry.Name)
+	}
+	return err
+}
+
+// validMediaName checks that a media name is a relative path that stays
+// within the media directory.
+func validMediaName(name string) bool {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return false
+	}
+	return filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// mediaEntryZipName returns the name of the zip file holding a media entry.
+func mediaEntryZipName(i int, entry *pb.MediaEntries_MediaEntry) string {
+	if entry.LegacyZipFilename != nil {
+		return fmt.Sprint(*entry.LegacyZipFilename)
+	}
+	return fmt.Sprint(i)
+}
+
 // readMediaEntries reads media entries from a zip archive.
-func readMediaEntries(r *zip.Reader) (*pb.MediaEntries, error) {
-	b, err := zipReadAll(r, "media", true)
+func readMediaEntries(r *zip.Reader, meta *pb.PackageMetadata, lim *unpackLimiter) (*pb.MediaEntries, error) {
+	if isLegacyVersion(meta) {
+		return readLegacyMediaEntries(r, lim)
+	}
+	b, err := zipReadAllLimited(r, "media", true, lim)
 	if err != nil {
 		return nil, err
 	}
@@ -148,42 +500,112 @@ func readMediaEntries(r *zip.Reader) (*pb.MediaEntries, error) {
 	return &media, nil
 }
 
-// writeMediaEntries writes media entries to a zip archive.
-func writeMediaEntries(w *zip.Writer, dir string) error {
-	var media pb.MediaEntries
-	fn := func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
-			return err
-		}
+// zipReadAllLimited reads all content from a file in a zip archive,
+// drawing from the limiter's size budget.
+func zipReadAllLimited(r *zip.Reader, name string, dcomp bool, lim *unpackLimiter) ([]byte, error) {
+	f, err := zipOpen(r, name, dcomp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	return io.ReadAll(lim.reader(f))
+}
 
-		sha1, err := writeMediaEntry(w, path, fmt.Sprint(len(media.Entries)))
+// readLegacyMediaEntries reads media entries stored as a legacy JSON map
+// from zip entry names to file names, as written by Anki 2.1.49 and earlier
+// and by third-party tools such as genanki.
+func readLegacyMediaEntries(r *zip.Reader, lim *unpackLimiter) (*pb.MediaEntries, error) {
+	b, err := zipReadAllLimited(r, "media", false, lim)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err = json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("invalid legacy media map: %w", err)
+		}
+	}
+	var media pb.MediaEntries
+	for key, name := range m {
+		n, err := strconv.ParseUint(key, 10, 32)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("invalid legacy media entry: %q", key)
 		}
+		zipName := uint32(n)
+		media.Entries = append(media.Entries, &pb.MediaEntries_MediaEntry{
+			Name:              name,
+			LegacyZipFilename: &zipName,
+		})
+	}
+	slices.SortFunc(media.Entries, func(a, b *pb.MediaEntries_MediaEntry) int {
+		return cmp.Compare(*a.LegacyZipFilename, *b.LegacyZipFilename)
+	})
+	return &media, nil
+}
+
+// writeMediaEntries writes media entries to a zip archive, taking the media
+// files from store, and from pkg, if not nil. Deterministic archives store
+// them in the order of their names.
+func writeMediaEntries(ctx context.Context, w *zipWriter, meta *pb.PackageMetadata, store MediaStore, pkg *packageMedia, prog *progress) error {
+	names, err := listMediaNames(store)
+	if err != nil {
+		return err
+	}
+	var files []*packedMedia
+	if pkg != nil {
+		files = pkg.list()
+	}
+	if w.deterministic {
+		slices.Sort(names)
+		slices.SortFunc(files, func(a, b *packedMedia) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+	prog.phase(ProgressMedia, len(names)+len(files))
 
-		rel, err := filepath.Rel(dir, path)
+	var media pb.MediaEntries
+	fn := func(name string) error {
+		src, err := store.Open(name)
 		if err != nil {
 			return err
 		}
+		defer src.Close() //nolint:errcheck
 
-		fi, err := d.Info()
+		zipName := fmt.Sprint(len(media.Entries))
+		sha1, size, err := writeMediaEntry(w, readerWithContext(ctx, io.TeeReader(src, prog)), zipName, zstdCompressed(meta))
 		if err != nil {
 			return err
 		}
 
 		media.Entries = append(media.Entries,
 			&pb.MediaEntries_MediaEntry{
-				Name: rel,
-				Size: uint32(fi.Size()),
+				Name: name,
+				Size: uint32(size),
 				Sha1: sha1,
 			},
 		)
 		return nil
 	}
-	if err := filepath.WalkDir(dir, fn); err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return err
+	for len(names) > 0 || len(files) > 0 {
+		if len(files) == 0 || len(names) > 0 && names[0] < files[0].Name() {
+			if err = fn(names[0]); err != nil {
+				return err
+			}
+			names = names[1:]
+		} else {
+			entry, err := files[0].writeTo(ctx, w, meta, fmt.Sprint(len(media.Entries)), prog)
+			if err != nil {
+				return err
+			}
+			media.Entries = append(media.Entries, entry)
+			files = files[1:]
 		}
+		prog.step()
+	}
+
+	if isLegacyVersion(meta) {
+		return writeLegacyMediaEntries(w, &media)
 	}
 
 	b, err := proto.Marshal(&media)
@@ -193,27 +615,48 @@ func writeMediaEntries(w *zip.Writer, dir string) error {
 	return zipWrite(w, "media", true, b)
 }
 
-// writeMediaEntry writes a single media entry to a zip archive.
-func writeMediaEntry(w *zip.Writer, path, name string) ([]byte, error) {
-	src, err := os.Open(path)
+// listMediaNames returns the names of the media files of a store.
+func listMediaNames(store MediaStore) ([]string, error) {
+	var names []string
+	for name, err := range store.List() {
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// writeLegacyMediaEntries writes media entries as a legacy JSON map from zip
+// entry names to file names.
+func writeLegacyMediaEntries(w *zipWriter, media *pb.MediaEntries) error {
+	m := make(map[string]string, len(media.Entries))
+	for i, entry := range media.Entries {
+		m[fmt.Sprint(i)] = entry.Name
+	}
+	b, err := json.Marshal(m)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer src.Close() //nolint:errcheck
+	return zipWrite(w, "media", false, b)
+}
 
-	dst, err := zipCreate(w, name, true)
+// writeMediaEntry writes a single media entry to a zip archive, returning the
+// SHA-1 hash and size of its content.
+func writeMediaEntry(w *zipWriter, src io.Reader, name string, comp bool) ([]byte, int64, error) {
+	dst, err := zipCreate(w, name, comp)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer dst.Close() //nolint:errcheck
 
 	h := sha1.New()
-	_, err = io.Copy(io.MultiWriter(h, dst), src)
+	n, err := io.Copy(io.MultiWriter(h, dst), src)
 	if err != nil {
-		return nil, err
+		return nil, n, err
 	}
 
-	return h.Sum(nil), nil
+	return h.Sum(nil), n, dst.Close()
 }
 
 // detectMetadata detects the package metadata from a zip archive.
@@ -246,7 +689,7 @@ func readMetadata(r *zip.Reader) (*pb.PackageMetadata, error) {
 }
 
 // writeMetadata writes the package metadata to a zip archive.
-func writeMetadata(w *zip.Writer, meta *pb.PackageMetadata) error {
+func writeMetadata(w *zipWriter, meta *pb.PackageMetadata) error {
 	b, err := proto.Marshal(meta)
 	if err != nil {
 		return err
@@ -254,44 +697,38 @@ func writeMetadata(w *zip.Writer, meta *pb.PackageMetadata) error {
 	return zipWrite(w, "meta", false, b)
 }
 
-// backup creates a backup of a collection.
-func backup(src, dst string) error {
+// backup copies a database and the media files of a store to a collection
+// directory, along with the media files left in a package, if pkg is not nil.
+func backup(db databaseSource, store MediaStore, pkg *packageMedia, dst string, prog *progress) error {
 	if err := os.Mkdir(dst, 0755); err != nil {
 		if !errors.Is(err, fs.ErrExist) {
 			return err
 		}
 	}
 
-	if err := copyFile(databasePath(src), databasePath(dst)); err != nil {
+	prog.phase(ProgressDatabase, 0)
+	if err := copyDatabase(db, databasePath(dst), prog); err != nil {
 		return err
 	}
 
-	return copyDir(mediaDir(src), mediaDir(dst))
-}
-
-// copyDir copies a directory.
-func copyDir(src, dst string) error {
-	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		rel, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		dstPath := filepath.Join(dst, rel)
-
-		if d.IsDir() {
-			err = os.MkdirAll(dstPath, 0755)
-			if errors.Is(err, os.ErrExist) {
-				err = nil
-			}
-			return err
+	names, err := listMediaNames(store)
+	if err != nil {
+		return err
+	}
+	media := make([]Media, 0, len(names))
+	for _, name := range names {
+		media = append(media, &storeMedia{store: store, name: name})
+	}
+	if pkg != nil {
+		for _, m := range pkg.list() {
+			media = append(media, m)
 		}
+	}
 
-		return copyFile(path, dstPath)
-	})
+	if err = os.MkdirAll(mediaDir(dst), 0755); err != nil {
+		return err
+	}
+	return copyMediaFiles(NewDirMediaStore(mediaDir(dst)), media, prog)
 }
 
 // copyFile copies a file.
@@ -0,0 +1,243 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/lftk/anki/pb"
+)
+
+// packageMedia gives access to the media files of a package that were not
+// extracted when it was opened.
+type packageMedia struct {
+	r      *zip.Reader
+	closer io.Closer
+	meta   *pb.PackageMetadata
+	files  []*packedMedia
+	index  map[string]int
+
+	mu sync.Mutex
+	// removed holds the names of the files that were deleted or replaced
+	// since the package was opened.
+	removed map[string]bool
+}
+
+// unpackLazy unpacks the database of a package, leaving the media files in
+// the zip file to be read on demand.
+func unpackLazy(ctx context.Context, r *zip.Reader, dir string, opts *UnpackOptions) (*packageMedia, error) {
+	lim := newUnpackLimiter(opts)
+	meta, err := detectMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = restoreDatabase(ctx, r, meta, databasePath(dir), lim, unpackProgress(opts)); err != nil {
+		return nil, err
+	}
+	if err = os.Mkdir(mediaDir(dir), 0755); err != nil {
+		return nil, err
+	}
+	return readPackageMedia(r, meta, lim)
+}
+
+// readPackageMedia reads the media map of a package, checking that every
+// entry can be found in the zip file.
+func readPackageMedia(r *zip.Reader, meta *pb.PackageMetadata, lim *unpackLimiter) (*packageMedia, error) {
+	pkg := &packageMedia{
+		r:       r,
+		meta:    meta,
+		index:   make(map[string]int),
+		removed: make(map[string]bool),
+	}
+	media, err := readMediaEntries(r, meta, lim)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return pkg, err
+	}
+	if err = lim.checkEntries(len(media.Entries)); err != nil {
+		return nil, err
+	}
+	for i, entry := range media.Entries {
+		if !validMediaName(entry.Name) {
+			return nil, &MediaEntryError{Name: entry.Name, Err: ErrInvalidMediaName}
+		}
+		f, ok := zipLookup(r, mediaEntryZipName(i, entry))
+		if !ok {
+			return nil, &MediaEntryError{Name: entry.Name, Err: fs.ErrNotExist}
+		}
+		pkg.index[entry.Name] = len(pkg.files)
+		pkg.files = append(pkg.files, &packedMedia{
+			entry: entry,
+			file:  f,
+			comp:  zstdCompressed(meta),
+		})
+	}
+	return pkg, nil
+}
+
+// close closes the zip file, if it is owned by the collection.
+func (pkg *packageMedia) close() error {
+	if pkg.closer == nil {
+		return nil
+	}
+	return pkg.closer.Close()
+}
+
+// get gets a media file by name.
+func (pkg *packageMedia) get(name string) (*packedMedia, bool) {
+	pkg.mu.Lock()
+	defer pkg.mu.Unlock()
+
+	if pkg.removed[name] {
+		return nil, false
+	}
+	i, ok := pkg.index[name]
+	if !ok {
+		return nil, false
+	}
+	return pkg.files[i], true
+}
+
+// remove hides a media file, after it was deleted or replaced.
+// It reports whether the package held the file.
+func (pkg *packageMedia) remove(name string) bool {
+	_, ok := pkg.get(name)
+	if ok {
+		pkg.mu.Lock()
+		pkg.removed[name] = true
+		pkg.mu.Unlock()
+	}
+	return ok
+}
+
+// list returns the media files that were neither deleted nor replaced.
+func (pkg *packageMedia) list() []*packedMedia {
+	pkg.mu.Lock()
+	defer pkg.mu.Unlock()
+
+	return slices.DeleteFunc(slices.Clone(pkg.files), func(m *packedMedia) bool {
+		return pkg.removed[m.entry.Name]
+	})
+}
+
+// packedMedia is a media file stored in a package. It implements the Media
+// interface.
+type packedMedia struct {
+	entry *pb.MediaEntries_MediaEntry
+	file  *zip.File
+	comp  bool
+}
+
+func (m *packedMedia) Name() string {
+	return m.entry.Name
+}
+
+// Open opens the media file for reading. If the package records the SHA-1 and
+// size of the file, they are verified when the end of the file is reached.
+func (m *packedMedia) Open() (io.ReadCloser, error) {
+	r, err := m.file.Open()
+	if err != nil {
+		return nil, err
+	}
+	rc := r
+	if m.comp {
+		if rc, err = zstdReadCloser(r); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.entry.Sha1) == 0 {
+		return rc, nil
+	}
+	return &verifyingReader{
+		ReadCloser: rc,
+		h:          sha1.New(),
+		sum:        m.entry.Sha1,
+		size:       int64(m.entry.Size),
+	}, nil
+}
+
+// writeTo writes the media file to a zip archive as the given entry.
+// The compressed content is copied as is if the formats of the packages
+// match, and the checksum and size the target needs are known. Deterministic
+// archives always recompress it, so that they do not depend on how the
+// package was written.
+func (m *packedMedia) writeTo(ctx context.Context, w *zipWriter, meta *pb.PackageMetadata, name string, prog *progress) (*pb.MediaEntries_MediaEntry, error) {
+	entry := &pb.MediaEntries_MediaEntry{Name: m.entry.Name}
+	if w.deterministic || m.comp != zstdCompressed(meta) || (!isLegacyVersion(meta) && len(m.entry.Sha1) == 0) {
+		r, err := m.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close() //nolint:errcheck
+
+		sum, size, err := writeMediaEntry(w, readerWithContext(ctx, io.TeeReader(r, prog)), name, zstdCompressed(meta))
+		if err != nil {
+			return nil, err
+		}
+		entry.Sha1 = sum
+		entry.Size = uint32(size)
+		return entry, nil
+	}
+
+	src, err := m.file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	fh := m.file.FileHeader
+	fh.Name = name
+	dst, err := w.CreateRaw(&fh)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(dst, readerWithContext(ctx, src)); err != nil {
+		return nil, err
+	}
+	// The content is copied compressed, so its size is counted as a whole.
+	// Legacy packages do not record it, but do not compress it with zstd
+	// either.
+	size := int64(m.entry.Size)
+	if !m.comp {
+		size = int64(m.file.UncompressedSize64)
+	}
+	prog.update(func(p *Progress) {
+		p.Bytes += size
+	})
+	entry.Sha1 = m.entry.Sha1
+	entry.Size = m.entry.Size
+	return entry, nil
+}
+
+// verifyingReader checks the SHA-1 and size of the content read from a media
+// file once the end is reached.
+type verifyingReader struct {
+	io.ReadCloser
+	h    hash.Hash
+	n    int64
+	sum  []byte
+	size int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.h.Write(p[:n])
+	v.n += int64(n)
+	if err == io.EOF {
+		if v.n != v.size {
+			return n, ErrSizeMismatch
+		}
+		if !bytes.Equal(v.h.Sum(nil), v.sum) {
+			return n, ErrChecksumMismatch
+		}
+	}
+	return n, err
+}
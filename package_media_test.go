@@ -0,0 +1,99 @@
+package anki
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"testing"
+
+	"github.com/lftk/anki/pb"
+)
+
+// TestLazyMedia tests replacing, deleting and writing the media files of a
+// package opened with LazyMedia.
+func TestLazyMedia(t *testing.T) {
+	tests := []struct {
+		name    string
+		version pb.PackageMetadata_Version
+	}{
+		{name: "latest", version: pb.PackageMetadata_VERSION_LATEST},
+		{name: "legacy 2", version: pb.PackageMetadata_VERSION_LEGACY_2},
+		{name: "legacy 1", version: pb.PackageMetadata_VERSION_LEGACY_1},
+	}
+
+	src, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close() //nolint:errcheck
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		if err = src.WriteMedia(name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "collection.colpkg")
+	if err = src.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+
+	readMedia := func(t *testing.T, col *Collection) map[string]string {
+		t.Helper()
+		files := make(map[string]string)
+		for m, err := range col.ListMedia(nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := m.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			_ = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[m.Name()] = string(b)
+		}
+		return files
+	}
+
+	col, err := OpenWithOptions(path, &OpenOptions{LazyMedia: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	if err = col.WriteMedia("a.png", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.DeleteMedia("b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = col.GetMedia("b.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetMedia() of a deleted file error = %v, want fs.ErrNotExist", err)
+	}
+	want := map[string]string{"a.png": "new", "c.png": "c.png"}
+	if got := readMedia(t, col); !maps.Equal(got, want) {
+		t.Errorf("media = %v, want %v", got, want)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := col.WritePackage(&buf, &WriteOptions{Version: tt.version}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadFromMemory(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close() //nolint:errcheck
+			if media := readMedia(t, got); !maps.Equal(media, want) {
+				t.Errorf("written media = %v, want %v", media, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lftk/anki/pb"
+)
+
+// newTestZip creates a zip archive in memory from a map of file names to contents.
+func newTestZip(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		if err := zipWrite(&zipWriter{Writer: zw}, name, false, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestReadLegacyMediaEntries tests reading the legacy JSON media map.
+func TestReadLegacyMediaEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    string
+		want     []string
+		wantZips []string
+		wantErr  bool
+	}{
+		{
+			name:     "sorted by zip name",
+			media:    `{"10": "b.mp3", "2": "a.png"}`,
+			want:     []string{"a.png", "b.mp3"},
+			wantZips: []string{"2", "10"},
+		},
+		{
+			name:  "empty map",
+			media: `{}`,
+		},
+		{
+			name:  "empty file",
+			media: ``,
+		},
+		{
+			name:    "invalid key",
+			media:   `{"x": "a.png"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestZip(t, map[string]string{"media": tt.media})
+			media, err := readLegacyMediaEntries(r, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readLegacyMediaEntries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(media.Entries) != len(tt.want) {
+				t.Fatalf("readLegacyMediaEntries() got %d entries, want %d", len(media.Entries), len(tt.want))
+			}
+			for i, entry := range media.Entries {
+				if entry.Name != tt.want[i] {
+					t.Errorf("entry %d name = %q, want %q", i, entry.Name, tt.want[i])
+				}
+				if got := mediaEntryZipName(i, entry); got != tt.wantZips[i] {
+					t.Errorf("entry %d zip name = %q, want %q", i, got, tt.wantZips[i])
+				}
+			}
+		})
+	}
+}
+
+// TestValidMediaName tests the validMediaName function.
+func TestValidMediaName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.png", true},
+		{"sub/a.png", true},
+		{"", false},
+		{"../a.png", false},
+		{"sub/../../a.png", false},
+		{"/etc/passwd", false},
+		{"a\x00.png", false},
+	}
+	for _, tt := range tests {
+		if got := validMediaName(tt.name); got != tt.want {
+			t.Errorf("validMediaName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestUnpackLimits tests that Unpack rejects unsafe names and enforces limits.
+func TestUnpackLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		opts    *UnpackOptions
+		wantErr error
+	}{
+		{
+			name:    "path traversal",
+			files:   map[string]string{"collection.anki2": "", "media": `{"0": "../evil"}`, "0": "x"},
+			wantErr: ErrInvalidMediaName,
+		},
+		{
+			name:    "size limit",
+			files:   map[string]string{"collection.anki2": "0123456789"},
+			opts:    &UnpackOptions{MaxSize: 9},
+			wantErr: ErrSizeLimitExceeded,
+		},
+		{
+			name:  "size limit exact",
+			files: map[string]string{"collection.anki2": "0123456789"},
+			opts:  &UnpackOptions{MaxSize: 10},
+		},
+		{
+			name:    "entry limit",
+			files:   map[string]string{"collection.anki2": "", "media": `{"0": "a", "1": "b"}`, "0": "", "1": ""},
+			opts:    &UnpackOptions{MaxEntries: 1},
+			wantErr: ErrEntryLimitExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestZip(t, tt.files)
+			err := UnpackContext(context.Background(), r, t.TempDir(), tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("UnpackContext() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestDeterministicPackage tests that packing the same content twice in
+// deterministic mode produces the same bytes.
+func TestDeterministicPackage(t *testing.T) {
+	tests := []struct {
+		name    string
+		version pb.PackageMetadata_Version
+	}{
+		{name: "latest", version: pb.PackageMetadata_VERSION_LATEST},
+		{name: "legacy 2", version: pb.PackageMetadata_VERSION_LEGACY_2},
+		{name: "legacy 1", version: pb.PackageMetadata_VERSION_LEGACY_1},
+	}
+	build := func(t *testing.T, version pb.PackageMetadata_Version) []byte {
+		t.Helper()
+		col, err := CreateInMemory(&CreateOptions{
+			Clock:              ClockFunc(func() time.Time { return time.Unix(1700000000, 0) }),
+			DeterministicGUIDs: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer col.Close() //nolint:errcheck
+
+		nt := &Notetype{
+			Name:      "Basic",
+			Fields:    []*Field{NewField("Front"), NewField("Back")},
+			Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+			Config:    NewNotetypeConfig("", false),
+		}
+		if err = col.AddNotetype(nt); err != nil {
+			t.Fatal(err)
+		}
+		for _, front := range []string{"a", "b"} {
+			note := &Note{NotetypeID: nt.ID, Fields: []string{front, "back"}}
+			if err = col.AddNote(1, note); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range []string{"b.png", "a.png"} {
+			if err = col.WriteMedia(name, []byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var buf bytes.Buffer
+		opts := &WriteOptions{Version: version, Deterministic: true}
+		if _, err = col.WritePackage(&buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if b1, b2 := build(t, tt.version), build(t, tt.version); !bytes.Equal(b1, b2) {
+				t.Error("packages differ")
+			}
+		})
+	}
+}
+
+// TestSaveLegacyPackage tests saving a collection as a legacy package and
+// opening it again.
+func TestSaveLegacyPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  pb.PackageMetadata_Version
+		database string
+	}{
+		{name: "latest", database: "collection.anki21b"},
+		{name: "legacy 2", version: pb.PackageMetadata_VERSION_LEGACY_2, database: "collection.anki21"},
+		{name: "legacy 1", version: pb.PackageMetadata_VERSION_LEGACY_1, database: "collection.anki2"},
+	}
+
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	nt := &Notetype{
+		Name:      "Basic",
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+		Config:    NewNotetypeConfig("", false),
+	}
+	if err = col.AddNotetype(nt); err != nil {
+		t.Fatal(err)
+	}
+	note := &Note{NotetypeID: nt.ID, Fields: []string{"front", `<img src="a.png">`}}
+	if err = col.AddNote(1, note); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.WriteMedia("a.png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "collection.colpkg")
+			var err error
+			if tt.version == pb.PackageMetadata_VERSION_UNKNOWN {
+				err = col.SaveAs(path)
+			} else {
+				err = col.SavePackage(path, &WriteOptions{Version: tt.version})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.OpenReader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close() //nolint:errcheck
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			if !slices.Contains(names, tt.database) {
+				t.Errorf("entries = %q, want %q", names, tt.database)
+			}
+
+			got, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close() //nolint:errcheck
+			n, err := got.GetNote(note.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(n.Fields, note.Fields) {
+				t.Errorf("fields = %q, want %q", n.Fields, note.Fields)
+			}
+			r, err := got.OpenMedia("a.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close() //nolint:errcheck
+			if b, err := io.ReadAll(r); err != nil || string(b) != "png" {
+				t.Errorf("media = %q, %v, want %q", b, err, "png")
+			}
+		})
+	}
+}
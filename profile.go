@@ -0,0 +1,208 @@
+package anki
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrProfileLocked is returned when a profile is already open in another
+// process that uses this package, or in Anki.
+var ErrProfileLocked = errors.New("profile is locked")
+
+// OpenProfile opens the collection of an Anki desktop profile in place.
+// The profile directory holds collection.anki2, the collection.media folder
+// and the collection.media.db2 database, which is kept in sync when media
+// files are created or deleted, so that Anki picks the changes up on its
+// next media sync.
+// The profile is locked for as long as the collection is open. The lock is
+// advisory: it guards against other users of this package. A profile that
+// Anki itself has open is detected when the collection is opened, by trying
+// to start a write transaction, and is reported as locked too.
+// Of opts, ReadOnly, Clock and DeterministicGUIDs apply, as with LoadDir;
+// media files are always kept in the collection.media folder.
+func OpenProfile(dir string, opts *OpenOptions) (*Collection, error) {
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &profile{lock: lock}
+	col, err := openProfile(dir, p, opts)
+	if err != nil {
+		_ = p.close()
+		return nil, err
+	}
+	return col, nil
+}
+
+// openProfile is an internal helper to open the databases of a profile.
+func openProfile(dir string, p *profile, opts *OpenOptions) (*Collection, error) {
+	path := filepath.Join(dir, "collection.anki2")
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	var clock Clock
+	if opts != nil {
+		clock = opts.Clock
+	}
+	readOnly := opts != nil && opts.ReadOnly
+	var db *sql.DB
+	var err error
+	if readOnly {
+		db, err = openDatabase(path, false, true)
+	} else {
+		db, err = openProfileDatabase(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	col, err := newCollection(db, dir, false, clock)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	col.path = path
+	col.readOnly = readOnly
+	col.store = NewDirMediaStore(filepath.Join(dir, "collection.media"))
+	if opts != nil {
+		col.ids.guids = opts.DeterministicGUIDs
+	}
+	if readOnly {
+		col.profile = p
+		return col, nil
+	}
+
+	if err = os.MkdirAll(filepath.Join(dir, "collection.media"), 0755); err != nil {
+		_ = col.Close()
+		return nil, err
+	}
+	mediaDB, err := sqlite3Open(filepath.Join(dir, "collection.media.db2"))
+	if err != nil {
+		_ = col.Close()
+		return nil, err
+	}
+	p.mediaDB = mediaDB
+	if err = initMediaDB(mediaDB); err != nil {
+		_ = col.Close()
+		return nil, err
+	}
+	col.profile = p
+	return col, nil
+}
+
+// openProfileDatabase opens the database of a profile for writing, upgrading
+// it to the current schema if required. It returns ErrProfileLocked if
+// another process, such as Anki, holds a lock on it.
+func openProfileDatabase(path string) (*sql.DB, error) {
+	db, err := sqlite3Open(path + "?_journal=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if err = probeWriteLock(db); err == nil {
+		err = upgradeSchema(db)
+	}
+	if err != nil {
+		_ = db.Close()
+		if sqlite3Busy(err) {
+			return nil, ErrProfileLocked
+		}
+		return nil, err
+	}
+	return db, nil
+}
+
+// probeWriteLock starts and rolls back a write transaction without waiting
+// for locks, to find out whether another process holds one on the database.
+func probeWriteLock(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	var timeout int
+	if err = conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&timeout); err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, "PRAGMA busy_timeout = 0"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "ROLLBACK")
+	}
+	_, restoreErr := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", timeout))
+	return errors.Join(err, restoreErr)
+}
+
+// mediaDBVersion is the version of the media database that Anki uses.
+const mediaDBVersion = 4
+
+// initMediaDB creates the tables of a new media database, or checks the
+// version of an existing one.
+func initMediaDB(db *sql.DB) error {
+	return sqlTransact(context.Background(), db, func(tx *sql.Tx) error {
+		ver, err := sqlGet(tx, scanValue[int], "PRAGMA user_version")
+		if err != nil {
+			return err
+		}
+		switch ver {
+		case mediaDBVersion:
+			return nil
+		case 0:
+			if err = sqlExecute(tx, createMediaDBQuery); err != nil {
+				return err
+			}
+			return sqlExecute(tx, fmt.Sprintf("PRAGMA user_version = %d", mediaDBVersion))
+		default:
+			return fmt.Errorf("unsupported media database version: %d", ver)
+		}
+	})
+}
+
+// profile holds the resources of a collection opened with OpenProfile.
+type profile struct {
+	lock    io.Closer
+	mediaDB *sql.DB
+}
+
+// close closes the media database and releases the lock.
+func (p *profile) close() error {
+	var errs []error
+	if p.mediaDB != nil {
+		errs = append(errs, p.mediaDB.Close())
+	}
+	errs = append(errs, p.lock.Close())
+	return errors.Join(errs...)
+}
+
+// mediaAdded records a media file that was added or changed.
+func (p *profile) mediaAdded(store MediaStore, name string) error {
+	fi, err := store.Stat(name)
+	if err != nil {
+		return err
+	}
+	r, err := store.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close() //nolint:errcheck
+
+	sum, err := sha1Hex(r)
+	if err != nil {
+		return err
+	}
+	return sqlExecute(p.mediaDB, setMediaEntryQuery, name, sum, fi.ModTime().Unix())
+}
+
+// mediaDeleted records a media file that was deleted.
+func (p *profile) mediaDeleted(name string) error {
+	return sqlExecute(p.mediaDB, setMediaEntryQuery, name, nil, 0)
+}
@@ -0,0 +1,179 @@
+package anki
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestProfile creates a profile directory holding an empty collection.
+func newTestProfile(t *testing.T) string {
+	t.Helper()
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	if err = copyDatabase(col.database(), filepath.Join(dir, "collection.anki2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestProfileMedia tests that the media database of a profile follows the
+// media files that are written and deleted.
+func TestProfileMedia(t *testing.T) {
+	dir := newTestProfile(t)
+	col, err := OpenProfile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	if err = col.WriteMedia("a.png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.WriteMedia("b.mp3", []byte("mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.DeleteMedia("b.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if err = col.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "collection.media", "a.png")); err != nil {
+		t.Error(err)
+	}
+	db, err := sqlite3Open(filepath.Join(dir, "collection.media.db2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close() //nolint:errcheck
+
+	sum := sha1.Sum([]byte("png"))
+	tests := []struct {
+		name string
+		csum sql.NullString
+	}{
+		{name: "a.png", csum: sql.NullString{String: hex.EncodeToString(sum[:]), Valid: true}},
+		{name: "b.mp3"},
+	}
+	for _, tt := range tests {
+		var csum sql.NullString
+		var dirty int
+		err := db.QueryRow("SELECT csum, dirty FROM media WHERE fname = ?", tt.name).Scan(&csum, &dirty)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if csum != tt.csum || dirty != 1 {
+			t.Errorf("%s: csum = %v, dirty = %d, want %v and 1", tt.name, csum, dirty, tt.csum)
+		}
+	}
+	if ver, err := sqlGet(db, scanValue[int], "PRAGMA user_version"); err != nil || ver != mediaDBVersion {
+		t.Errorf("user_version = %d, %v, want %d", ver, err, mediaDBVersion)
+	}
+}
+
+// TestProfileMediaDBVersion tests opening a profile whose media database has
+// another version.
+func TestProfileMediaDBVersion(t *testing.T) {
+	dir := newTestProfile(t)
+	setVersion := func(ver string) {
+		t.Helper()
+		db, err := sqlite3Open(filepath.Join(dir, "collection.media.db2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close() //nolint:errcheck
+		if err = sqlExecute(db, "PRAGMA user_version = "+ver); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setVersion("5")
+	if col, err := OpenProfile(dir, nil); err == nil {
+		_ = col.Close()
+		t.Fatal("OpenProfile() succeeded with an unsupported media database")
+	}
+
+	// The profile is unlocked after the failure, and a media database without
+	// a version is created anew.
+	setVersion("0")
+	col, err := OpenProfile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = col.WriteMedia("a.png", []byte("png")); err != nil {
+		t.Error(err)
+	}
+	if err = col.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenProfileInUse tests that a profile whose collection another process,
+// such as Anki, is writing to cannot be opened.
+func TestOpenProfileInUse(t *testing.T) {
+	dir := newTestProfile(t)
+	db, err := sqlite3Open(filepath.Join(dir, "collection.anki2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close() //nolint:errcheck
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sqlExecute(tx, "INSERT INTO tags (tag, usn, collapsed) VALUES ('held', 0, 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if col, err := OpenProfile(dir, nil); !errors.Is(err, ErrProfileLocked) {
+		if err == nil {
+			_ = col.Close()
+		}
+		t.Errorf("OpenProfile() error = %v, want ErrProfileLocked", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	col, err := OpenProfile(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenProfile() after the transaction: %v", err)
+	}
+	_ = col.Close()
+}
+
+// TestOpenProfileOptions tests opening a profile read-only and with a clock.
+func TestOpenProfileOptions(t *testing.T) {
+	dir := newTestProfile(t)
+	now := time.Unix(1700000000, 0)
+	col, err := OpenProfile(dir, &OpenOptions{
+		ReadOnly: true,
+		Clock:    ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	if err = col.AddDeck(&Deck{Name: "A"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("AddDeck() error = %v, want ErrReadOnly", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "collection.media.db2")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("media database created by a read-only open: %v", err)
+	}
+	if got := col.ids.now(); !got.Equal(now) {
+		t.Errorf("clock = %v, want %v", got, now)
+	}
+}
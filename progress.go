@@ -0,0 +1,132 @@
+package anki
+
+import (
+	"io"
+	"sync"
+)
+
+// ProgressPhase identifies the phase of a long-running operation.
+type ProgressPhase int
+
+const (
+	// ProgressDatabase is reported while the database is copied.
+	ProgressDatabase ProgressPhase = iota + 1
+	// ProgressMedia is reported while media files are copied.
+	ProgressMedia
+	// ProgressNotes is reported while the notes of a notetype are rewritten.
+	ProgressNotes
+	// ProgressOptimize is reported while FSRS parameters are trained.
+	ProgressOptimize
+)
+
+// String returns the name of the phase.
+func (p ProgressPhase) String() string {
+	switch p {
+	case ProgressDatabase:
+		return "database"
+	case ProgressMedia:
+		return "media"
+	case ProgressNotes:
+		return "notes"
+	case ProgressOptimize:
+		return "optimize"
+	default:
+		return "unknown"
+	}
+}
+
+// Progress describes how far a long-running operation has got.
+type Progress struct {
+	// Phase is the current phase of the operation.
+	Phase ProgressPhase
+	// Done and Total count the items of the phase that were processed: media
+	// files, notes or training steps. They are zero for the database phase.
+	Done  int
+	Total int
+	// Bytes is the number of uncompressed bytes copied since the operation
+	// started.
+	Bytes int64
+}
+
+// ProgressFunc receives progress reports. It is called on the goroutine
+// running the operation, never concurrently, and should return quickly.
+type ProgressFunc func(Progress)
+
+// ProgressChan returns a ProgressFunc that sends reports to ch, dropping
+// those that cannot be sent right away so that a slow receiver does not hold
+// up the operation.
+func ProgressChan(ch chan<- Progress) ProgressFunc {
+	return func(p Progress) {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// progress tracks the progress of an operation and reports it to a
+// ProgressFunc. A nil *progress reports nothing.
+type progress struct {
+	mu sync.Mutex
+	fn ProgressFunc
+	p  Progress
+}
+
+// newProgress returns a tracker reporting to fn, or nil if fn is nil.
+func newProgress(fn ProgressFunc) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn}
+}
+
+// update updates the progress with fn and reports it.
+func (p *progress) update(fn func(*Progress)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fn(&p.p)
+	p.fn(p.p)
+}
+
+// phase starts a new phase of total items.
+func (p *progress) phase(phase ProgressPhase, total int) {
+	p.update(func(p *Progress) {
+		p.Phase = phase
+		p.Done = 0
+		p.Total = total
+	})
+}
+
+// step records that an item of the current phase was processed.
+func (p *progress) step() {
+	p.update(func(p *Progress) {
+		p.Done++
+	})
+}
+
+// Write records that len(b) bytes were copied. It lets a tracker count the
+// bytes going through an io.TeeReader or io.MultiWriter.
+func (p *progress) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		p.update(func(p *Progress) {
+			p.Bytes += int64(len(b))
+		})
+	}
+	return len(b), nil
+}
+
+// progressWriter is a writer that counts the bytes written to it.
+type progressWriter struct {
+	io.WriteCloser
+	prog *progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	_, _ = w.prog.Write(b[:n])
+	return n, err
+}
@@ -0,0 +1,51 @@
+package anki
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestProgress tests the progress tracker.
+func TestProgress(t *testing.T) {
+	var got []Progress
+	prog := newProgress(func(p Progress) {
+		got = append(got, p)
+	})
+
+	prog.phase(ProgressMedia, 2)
+	if _, err := io.Copy(io.Discard, io.TeeReader(strings.NewReader("hello"), prog)); err != nil {
+		t.Fatal(err)
+	}
+	prog.step()
+
+	want := Progress{Phase: ProgressMedia, Done: 1, Total: 2, Bytes: 5}
+	if len(got) == 0 || got[len(got)-1] != want {
+		t.Errorf("last report = %v, want %v", got, want)
+	}
+
+	// A nil tracker reports nothing.
+	var nilProg *progress
+	nilProg.phase(ProgressDatabase, 0)
+	nilProg.step()
+	if n, err := nilProg.Write([]byte("x")); n != 1 || err != nil {
+		t.Errorf("Write() = %v, %v, want 1, nil", n, err)
+	}
+}
+
+// TestProgressChan tests that reports are dropped when the channel is full.
+func TestProgressChan(t *testing.T) {
+	ch := make(chan Progress, 1)
+	fn := ProgressChan(ch)
+	fn(Progress{Done: 1})
+	fn(Progress{Done: 2})
+
+	if p := <-ch; p.Done != 1 {
+		t.Errorf("received %v, want Done = 1", p)
+	}
+	select {
+	case p := <-ch:
+		t.Errorf("received %v, want nothing", p)
+	default:
+	}
+}
@@ -63,6 +63,9 @@ var deleteCardsQuery string
 //go:embed queries/list_note_ids.sql
 var listNoteIDsQuery string
 
+//go:embed queries/count_notes.sql
+var countNotesQuery string
+
 //go:embed queries/delete_note.sql
 var deleteNoteQuery string
 
@@ -107,3 +110,102 @@ var getColQuery string
 
 //go:embed queries/add_grave.sql
 var addGraveQuery string
+
+//go:embed queries/downgrade_col.sql
+var downgradeColQuery string
+
+//go:embed queries/upgrade_schema11.sql
+var upgradeSchema11Query string
+
+//go:embed queries/upgrade_col.sql
+var upgradeColQuery string
+
+//go:embed queries/get_col_ver.sql
+var getColVerQuery string
+
+//go:embed queries/get_legacy_col.sql
+var getLegacyColQuery string
+
+//go:embed queries/create_export_decks.sql
+var createExportDecksQuery string
+
+//go:embed queries/add_export_deck.sql
+var addExportDeckQuery string
+
+//go:embed queries/add_export_parent_deck.sql
+var addExportParentDeckQuery string
+
+//go:embed queries/export_deck.sql
+var exportDeckQuery string
+
+//go:embed queries/reset_scheduling.sql
+var resetSchedulingQuery string
+
+//go:embed queries/get_max_new_position.sql
+var getMaxNewPositionQuery string
+
+//go:embed queries/add_tag.sql
+var addTagQuery string
+
+//go:embed queries/create_media_db.sql
+var createMediaDBQuery string
+
+//go:embed queries/set_media_entry.sql
+var setMediaEntryQuery string
+
+//go:embed queries/set_col_mod.sql
+var setColModQuery string
+
+//go:embed queries/insert_note.sql
+var insertNoteQuery string
+
+//go:embed queries/insert_cards.sql
+var insertCardsQuery string
+
+//go:embed queries/get_max_id.sql
+var getMaxIDQuery string
+
+//go:embed queries/search_note_ids.sql
+var searchNoteIDsQuery string
+
+//go:embed queries/search_card_ids.sql
+var searchCardIDsQuery string
+
+//go:embed queries/update_sort_fields.sql
+var updateSortFieldsQuery string
+
+//go:embed queries/create_note_index.sql
+var createNoteIndexQuery string
+
+//go:embed queries/drop_note_index.sql
+var dropNoteIndexQuery string
+
+//go:embed queries/has_note_index.sql
+var hasNoteIndexQuery string
+
+//go:embed queries/add_note_index.sql
+var addNoteIndexQuery string
+
+//go:embed queries/delete_note_index.sql
+var deleteNoteIndexQuery string
+
+//go:embed queries/search_note_index.sql
+var searchNoteIndexQuery string
+
+//go:embed queries/get_review_log.sql
+var getReviewLogQuery string
+
+//go:embed queries/add_review_log.sql
+var addReviewLogQuery string
+
+//go:embed queries/delete_review_log.sql
+var deleteReviewLogQuery string
+
+//go:embed queries/update_deck_common.sql
+var updateDeckCommonQuery string
+
+//go:embed queries/get_deck_tree.sql
+var getDeckTreeQuery string
+
+//go:embed queries/add_review_log_if_unique.sql
+var addReviewLogIfUniqueQuery string
@@ -0,0 +1,4 @@
+INSERT OR IGNORE INTO
+  export_parent_decks (id)
+VALUES
+  (?)
\ No newline at end of file
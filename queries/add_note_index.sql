@@ -0,0 +1,4 @@
+INSERT INTO
+  notes_fts (rowid, text)
+VALUES
+  (?, ?)
\ No newline at end of file
@@ -0,0 +1,39 @@
+INSERT INTO
+  revlog (
+    id,
+    cid,
+    usn,
+    ease,
+    ivl,
+    lastIvl,
+    factor,
+    time,
+    type
+  )
+VALUES
+  (
+    (
+      CASE
+        WHEN ?1 IN (
+          SELECT
+            id
+          FROM
+            revlog
+        ) THEN (
+          SELECT
+            max(id) + 1
+          FROM
+            revlog
+        )
+        ELSE ?1
+      END
+    ),
+    ?,
+    ?,
+    ?,
+    ?,
+    ?,
+    ?,
+    ?,
+    ?
+  )
\ No newline at end of file
@@ -0,0 +1,14 @@
+INSERT OR IGNORE INTO
+  revlog (
+    id,
+    cid,
+    usn,
+    ease,
+    ivl,
+    lastIvl,
+    factor,
+    time,
+    type
+  )
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?)
\ No newline at end of file
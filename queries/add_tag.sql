@@ -0,0 +1,4 @@
+INSERT OR IGNORE INTO
+  tags (tag, usn, collapsed)
+VALUES
+  (?, ?, ?)
\ No newline at end of file
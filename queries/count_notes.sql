@@ -0,0 +1,6 @@
+SELECT
+  COUNT(*)
+FROM
+  notes
+WHERE
+  mid = ?
\ No newline at end of file
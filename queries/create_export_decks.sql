@@ -0,0 +1,3 @@
+CREATE TEMP TABLE export_decks (id integer PRIMARY KEY);
+
+CREATE TEMP TABLE export_parent_decks (id integer PRIMARY KEY)
\ No newline at end of file
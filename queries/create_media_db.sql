@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS media (
+  fname text NOT NULL PRIMARY KEY,
+  csum text,
+  mtime integer NOT NULL,
+  dirty integer NOT NULL
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS idx_media_dirty ON media (dirty)
+WHERE
+  dirty = 1;
+
+CREATE TABLE IF NOT EXISTS meta (dirMod integer, lastUsn integer);
+
+INSERT INTO
+  meta (dirMod, lastUsn)
+SELECT
+  0,
+  0
+WHERE
+  NOT EXISTS (
+    SELECT
+      1
+    FROM
+      meta
+  )
\ No newline at end of file
@@ -0,0 +1,4 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5 (
+  text,
+  tokenize = 'unicode61 remove_diacritics 2'
+)
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM notes_fts
+WHERE
+  rowid = ?
\ No newline at end of file
@@ -0,0 +1,3 @@
+DELETE FROM revlog
+WHERE
+  id = ?
\ No newline at end of file
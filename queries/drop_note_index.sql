@@ -0,0 +1 @@
+DROP TABLE IF EXISTS notes_fts
\ No newline at end of file
@@ -0,0 +1,86 @@
+-- move cards in filtered decks back to their home deck
+UPDATE cards
+SET
+  did = odid,
+  due = odue,
+  odid = 0,
+  odue = 0
+WHERE
+  odid IN (
+    SELECT
+      id
+    FROM
+      export_decks
+  );
+
+DELETE FROM cards
+WHERE
+  did NOT IN (
+    SELECT
+      id
+    FROM
+      export_decks
+  );
+
+DELETE FROM notes
+WHERE
+  id NOT IN (
+    SELECT
+      nid
+    FROM
+      cards
+  );
+
+DELETE FROM revlog
+WHERE
+  cid NOT IN (
+    SELECT
+      id
+    FROM
+      cards
+  );
+
+DELETE FROM graves;
+
+DELETE FROM notetypes
+WHERE
+  id NOT IN (
+    SELECT
+      mid
+    FROM
+      notes
+  );
+
+DELETE FROM fields
+WHERE
+  ntid NOT IN (
+    SELECT
+      id
+    FROM
+      notetypes
+  );
+
+DELETE FROM templates
+WHERE
+  ntid NOT IN (
+    SELECT
+      id
+    FROM
+      notetypes
+  );
+
+DELETE FROM decks
+WHERE
+  id != 1
+  AND id NOT IN (
+    SELECT
+      id
+    FROM
+      export_decks
+  )
+  AND id NOT IN (
+    SELECT
+      id
+    FROM
+      export_parent_decks
+  );
\ No newline at end of file
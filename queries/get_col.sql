@@ -2,7 +2,8 @@ SELECT
   mod,
   scm,
   ls,
-  usn
+  usn,
+  crt
 FROM
   col
 WHERE
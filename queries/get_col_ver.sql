@@ -0,0 +1,6 @@
+SELECT
+  ver
+FROM
+  col
+WHERE
+  id = 1
\ No newline at end of file
@@ -0,0 +1,11 @@
+SELECT
+  d.id
+FROM
+  decks d,
+  decks p
+WHERE
+  p.id = ?
+  AND (
+    d.id = p.id
+    OR substr(d.name, 1, length(p.name) + 1) = p.name || char(31) COLLATE unicase
+  )
\ No newline at end of file
@@ -0,0 +1,10 @@
+SELECT
+  models,
+  decks,
+  dconf,
+  conf,
+  tags
+FROM
+  col
+WHERE
+  id = 1
\ No newline at end of file
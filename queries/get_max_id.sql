@@ -0,0 +1,39 @@
+SELECT
+  max(
+    (
+      SELECT
+        coalesce(max(id), 0)
+      FROM
+        notes
+    ),
+    (
+      SELECT
+        coalesce(max(id), 0)
+      FROM
+        cards
+    ),
+    (
+      SELECT
+        coalesce(max(id), 0)
+      FROM
+        decks
+    ),
+    (
+      SELECT
+        coalesce(max(id), 0)
+      FROM
+        notetypes
+    ),
+    (
+      SELECT
+        coalesce(max(id), 0)
+      FROM
+        deck_config
+    ),
+    (
+      SELECT
+        coalesce(max(id), 0)
+      FROM
+        revlog
+    )
+  )
\ No newline at end of file
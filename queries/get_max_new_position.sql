@@ -0,0 +1,6 @@
+SELECT
+  coalesce(max(due), 0)
+FROM
+  cards
+WHERE
+  type = 0
\ No newline at end of file
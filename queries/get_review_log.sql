@@ -0,0 +1,12 @@
+SELECT
+  id,
+  cid,
+  usn,
+  ease,
+  ivl,
+  lastIvl,
+  factor,
+  time,
+  type
+FROM
+  revlog
\ No newline at end of file
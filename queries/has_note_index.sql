@@ -0,0 +1,7 @@
+SELECT
+  count(*)
+FROM
+  sqlite_master
+WHERE
+  type = 'table'
+  AND name = 'notes_fts'
\ No newline at end of file
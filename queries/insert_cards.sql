@@ -0,0 +1,22 @@
+INSERT INTO
+  cards (
+    id,
+    nid,
+    did,
+    ord,
+    mod,
+    usn,
+    type,
+    queue,
+    due,
+    ivl,
+    factor,
+    reps,
+    lapses,
+    left,
+    odue,
+    odid,
+    flags,
+    data
+  )
+VALUES
\ No newline at end of file
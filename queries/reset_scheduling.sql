@@ -0,0 +1,33 @@
+UPDATE cards
+SET
+  type = 0,
+  queue = 0,
+  due = (
+    SELECT
+      pos
+    FROM
+      (
+        SELECT
+          id,
+          row_number() OVER (
+            ORDER BY
+              nid,
+              ord
+          ) AS pos
+        FROM
+          cards
+      ) AS positions
+    WHERE
+      positions.id = cards.id
+  ),
+  ivl = 0,
+  factor = 0,
+  reps = 0,
+  lapses = 0,
+  left = 0,
+  odue = 0,
+  odid = 0,
+  flags = 0,
+  data = '';
+
+DELETE FROM revlog;
\ No newline at end of file
@@ -0,0 +1,6 @@
+SELECT DISTINCT
+  n.id
+FROM
+  cards c
+  JOIN notes n ON c.nid = n.id
+WHERE
\ No newline at end of file
@@ -0,0 +1,12 @@
+SELECT
+  rowid,
+  snippet(notes_fts, 0, ?, ?, '...', ?),
+  rank
+FROM
+  notes_fts
+WHERE
+  notes_fts MATCH ?
+ORDER BY
+  rank
+LIMIT
+  ?
\ No newline at end of file
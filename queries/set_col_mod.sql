@@ -0,0 +1,5 @@
+UPDATE col
+SET
+  mod = ?
+WHERE
+  id = 1
\ No newline at end of file
@@ -0,0 +1,4 @@
+INSERT OR REPLACE INTO
+  media (fname, csum, mtime, dirty)
+VALUES
+  (?, ?, ?, 1)
\ No newline at end of file
@@ -0,0 +1,7 @@
+UPDATE decks
+SET
+  mtime_secs = ?,
+  usn = ?,
+  common = ?
+WHERE
+  id = ?
\ No newline at end of file
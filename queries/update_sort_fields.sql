@@ -0,0 +1,7 @@
+UPDATE notes
+SET
+  mod = ?,
+  usn = ?,
+  sfld = process_text(field_at_index(flds, ?), ?)
+WHERE
+  mid = ?
\ No newline at end of file
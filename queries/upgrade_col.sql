@@ -0,0 +1,10 @@
+UPDATE col
+SET
+  ver = ?,
+  models = '',
+  decks = '',
+  dconf = '',
+  conf = '',
+  tags = ''
+WHERE
+  id = 1
\ No newline at end of file
@@ -0,0 +1,68 @@
+CREATE TABLE notetypes (
+  id integer NOT NULL PRIMARY KEY,
+  name text NOT NULL,
+  mtime_secs integer NOT NULL,
+  usn integer NOT NULL,
+  config blob NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_notetypes_name ON notetypes (name);
+
+CREATE INDEX idx_notetypes_usn ON notetypes (usn);
+
+CREATE TABLE fields (
+  ntid integer NOT NULL,
+  ord integer NOT NULL,
+  name text NOT NULL,
+  config blob NOT NULL,
+  PRIMARY KEY (ntid, ord)
+) without rowid;
+
+CREATE UNIQUE INDEX idx_fields_name_ntid ON fields (name, ntid);
+
+CREATE TABLE templates (
+  ntid integer NOT NULL,
+  ord integer NOT NULL,
+  name text NOT NULL,
+  mtime_secs integer NOT NULL,
+  usn integer NOT NULL,
+  config blob NOT NULL,
+  PRIMARY KEY (ntid, ord)
+) without rowid;
+
+CREATE UNIQUE INDEX idx_templates_name_ntid ON templates (name, ntid);
+
+CREATE INDEX idx_templates_usn ON templates (usn);
+
+CREATE TABLE config (
+  KEY text NOT NULL PRIMARY KEY,
+  usn integer NOT NULL,
+  mtime_secs integer NOT NULL,
+  val blob NOT NULL
+) without rowid;
+
+CREATE TABLE tags (
+  tag text NOT NULL PRIMARY KEY COLLATE unicase,
+  usn integer NOT NULL,
+  collapsed boolean NOT NULL,
+  config blob NULL
+) without rowid;
+
+CREATE TABLE decks (
+  id integer PRIMARY KEY NOT NULL,
+  name text NOT NULL COLLATE unicase,
+  mtime_secs integer NOT NULL,
+  usn integer NOT NULL,
+  common blob NOT NULL,
+  kind blob NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_decks_name ON decks (name);
+
+CREATE TABLE deck_config (
+  id integer PRIMARY KEY NOT NULL,
+  name text NOT NULL COLLATE unicase,
+  mtime_secs integer NOT NULL,
+  usn integer NOT NULL,
+  config blob NOT NULL
+);
\ No newline at end of file
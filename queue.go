@@ -0,0 +1,523 @@
+package anki
+
+import (
+	"cmp"
+	"context"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lftk/anki/pb"
+)
+
+// Queue is the queue of cards to study in a deck, in the order the v3
+// scheduler of Anki shows them.
+type Queue struct {
+	// NewCount, LearnCount and ReviewCount count the new, learning and review
+	// cards of the queue. Learning cards include those due later today in
+	// the learn ahead limit, and those due today after a day or more.
+	NewCount    int
+	LearnCount  int
+	ReviewCount int
+
+	cards []*Card
+}
+
+// Cards returns an iterator over the cards of the queue, in order. Learning
+// cards due now come first, and those due in the learn ahead limit last.
+func (q *Queue) Cards() iter.Seq[*Card] {
+	return slices.Values(q.cards)
+}
+
+// Queue builds the queue of cards to study today in a deck and its subdecks.
+//
+// Cards are gathered up to the per-day limits of each deck: the limits of its
+// deck configuration, unless overridden for the deck or for today only, minus
+// the cards studied today. Learning cards due after a day count against the
+// review limit, and new cards are limited to the reviews left. Siblings of a
+// gathered card are buried as the deck configurations say.
+//
+// New cards are gathered and sorted, and reviews sorted, in the orders of the
+// configuration of the deck, which also says how new cards and learning cards
+// due after a day mix with reviews.
+func (c *Collection) Queue(deckID int64) (*Queue, error) {
+	return buildQueue(c.db, deckID, c.props, c.ids.now())
+}
+
+// QueueContext is like Queue, but stops with an error once ctx is done.
+func (c *Collection) QueueContext(ctx context.Context, deckID int64) (*Queue, error) {
+	return buildQueue(sqlWithContext(ctx, c.db), deckID, c.props, c.ids.now())
+}
+
+// queueDeck is a deck whose cards are gathered into a queue.
+type queueDeck struct {
+	config *pb.DeckConfig
+	// order is the position of the deck in alphabetical order.
+	order int
+	// limits are the limits the cards of the deck count against: those of
+	// the deck and its parents, up to the studied deck. Filtered decks have
+	// no limits of their own.
+	limits []*queueLimit
+}
+
+// queueLimit is what remains of the per-day limits of a deck.
+type queueLimit struct {
+	new    int
+	review int
+}
+
+// queueBuilder gathers the cards of a queue.
+type queueBuilder struct {
+	q      sqlQueryer
+	config *pb.DeckConfig
+	root   int64
+	decks  map[int64]*queueDeck
+	p      *props
+	today  int64
+	now    time.Time
+	// seen holds the notes with a card in the queue, whose siblings may be
+	// buried.
+	seen map[int64]bool
+}
+
+// buildQueue builds the queue of a deck at time now.
+func buildQueue(q sqlQueryer, deckID int64, p *props, now time.Time) (*Queue, error) {
+	b := &queueBuilder{
+		q:     q,
+		decks: make(map[int64]*queueDeck),
+		p:     p,
+		today: p.daysElapsed(now),
+		now:   now,
+		seen:  make(map[int64]bool),
+	}
+	if err := b.loadDecks(deckID); err != nil {
+		return nil, err
+	}
+	learnAhead, err := getConfigValue(q, "collapseTime", int64(1200))
+	if err != nil {
+		return nil, err
+	}
+	ignoreReviewLimit, err := getConfigValue(q, "newCardsIgnoreReviewLimit", false)
+	if err != nil {
+		return nil, err
+	}
+
+	intraday, err := b.gather(CardQueueLearn, now.Unix()+learnAhead)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(intraday, func(a, b *Card) int {
+		return cmp.Compare(a.Due, b.Due)
+	})
+	for _, card := range intraday {
+		b.seen[card.NoteID] = true
+	}
+
+	interday, err := b.gather(CardQueueDayLearn, b.today)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(interday, func(x, y *Card) int {
+		return cmp.Or(cmp.Compare(x.Due, y.Due), cmp.Compare(b.hash(x.ID), b.hash(y.ID)))
+	})
+	interday = b.limit(interday, false, (*pb.DeckConfig).GetBuryInterdayLearning)
+
+	reviews, err := b.gather(CardQueueReview, b.today)
+	if err != nil {
+		return nil, err
+	}
+	b.sortReviews(reviews)
+	reviews = b.limit(reviews, false, (*pb.DeckConfig).GetBuryReviews)
+
+	if !ignoreReviewLimit {
+		for _, deck := range b.decks {
+			for _, l := range deck.limits {
+				l.new = min(l.new, l.review)
+			}
+		}
+	}
+	news, err := b.gatherNew()
+	if err != nil {
+		return nil, err
+	}
+	b.sortNew(news)
+
+	main := mixQueue(reviews, interday, b.config.GetInterdayLearningMix())
+	main = mixQueue(main, news, b.config.GetNewMix())
+	i, _ := slices.BinarySearchFunc(intraday, now.Unix()+1, func(card *Card, due int64) int {
+		return cmp.Compare(card.Due, due)
+	})
+	cards := slices.Concat(intraday[:i], main, intraday[i:])
+
+	return &Queue{
+		NewCount:    len(news),
+		LearnCount:  len(intraday) + len(interday),
+		ReviewCount: len(reviews),
+		cards:       cards,
+	}, nil
+}
+
+// loadDecks loads the studied deck and its subdecks, with their limits.
+func (b *queueBuilder) loadDecks(deckID int64) error {
+	root, err := getDeck(b.q, deckID)
+	if err != nil {
+		return err
+	}
+	all, err := sqlSelect(b.q, scanDeck, getDeckQuery)
+	if err != nil {
+		return err
+	}
+	configs, err := sqlSelect(b.q, scanDeckConfig, getDeckConfigQuery)
+	if err != nil {
+		return err
+	}
+	configOf := func(deck *Deck) *pb.DeckConfig {
+		id := deck.Kind.GetNormal().GetConfigId()
+		if id == 0 {
+			id = 1
+		}
+		for _, fallback := range []int64{id, 1} {
+			if i := slices.IndexFunc(configs, func(c *DeckConfig) bool { return c.ID == fallback }); i >= 0 {
+				return configs[i].Config
+			}
+		}
+		return DefaultDeckConfig()
+	}
+
+	var decks []*Deck
+	for _, deck := range all {
+		if deck.ID == root.ID || deck.Name.isDescendantOf(root.Name) {
+			decks = append(decks, deck)
+		}
+	}
+	slices.SortFunc(decks, func(a, b *Deck) int {
+		return cmp.Compare(strings.ToLower(string(a.Name)), strings.ToLower(string(b.Name)))
+	})
+
+	// Parents come before their subdecks, so that their limits are known.
+	b.root = root.ID
+	b.config = configOf(root)
+	limits := make(map[DeckName][]*queueLimit)
+	for i, deck := range decks {
+		qd := &queueDeck{config: configOf(deck), order: i}
+		if deck.ID != root.ID {
+			qd.limits = limits[deck.Name.Parent()]
+		}
+		if normal := deck.Kind.GetNormal(); normal != nil {
+			l := deckLimit(deck, normal, qd.config, b.today)
+			qd.limits = append([]*queueLimit{l}, qd.limits...)
+		} else if deck.ID == root.ID {
+			qd.config = b.config
+		}
+		limits[deck.Name] = qd.limits
+		b.decks[deck.ID] = qd
+	}
+	return nil
+}
+
+// deckLimit returns what remains today of the per-day limits of a deck.
+func deckLimit(deck *Deck, normal *pb.DeckNormal, config *pb.DeckConfig, today int64) *queueLimit {
+	l := &queueLimit{
+		new:    int(config.GetNewPerDay()),
+		review: int(config.GetReviewsPerDay()),
+	}
+	if normal.NewLimit != nil {
+		l.new = int(normal.GetNewLimit())
+	}
+	if normal.ReviewLimit != nil {
+		l.review = int(normal.GetReviewLimit())
+	}
+	if limit := normal.GetNewLimitToday(); limit != nil && int64(limit.GetToday()) == today {
+		l.new = int(limit.GetLimit())
+	}
+	if limit := normal.GetReviewLimitToday(); limit != nil && int64(limit.GetToday()) == today {
+		l.review = int(limit.GetLimit())
+	}
+	if common := deck.Common; int64(common.GetLastDayStudied()) == today {
+		l.new -= int(common.GetNewStudied())
+		l.review -= int(common.GetReviewStudied())
+	}
+	l.new = max(l.new, 0)
+	l.review = max(l.review, 0)
+	return l
+}
+
+// gather selects the cards of the decks in a queue, due by due.
+func (b *queueBuilder) gather(queue CardQueue, due int64) ([]*Card, error) {
+	query := getCardQuery + " WHERE did IN " + sqlIntList(b.deckIDs()) + " AND queue = ? AND due <= ? ORDER BY id"
+	return sqlSelect(b.q, scanCard, query, queue, due)
+}
+
+// deckIDs returns the IDs of the decks in a queue, in alphabetical order.
+func (b *queueBuilder) deckIDs() []int64 {
+	ids := make([]int64, 0, len(b.decks))
+	for id := range b.decks {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(x, y int64) int {
+		return cmp.Compare(b.decks[x].order, b.decks[y].order)
+	})
+	return ids
+}
+
+// limit keeps the cards within the limits of their decks, skipping those
+// whose siblings are in the queue when their deck configuration buries them.
+func (b *queueBuilder) limit(cards []*Card, isNew bool, bury func(*pb.DeckConfig) bool) []*Card {
+	kept := cards[:0]
+	for _, card := range cards {
+		if b.take(card, isNew, bury) {
+			kept = append(kept, card)
+		}
+	}
+	return kept
+}
+
+// take reports whether a card is kept within the limits of its deck, which
+// it then counts against, unless its siblings bury it.
+func (b *queueBuilder) take(card *Card, isNew bool, bury func(*pb.DeckConfig) bool) bool {
+	deck := b.decks[card.DeckID]
+	if b.seen[card.NoteID] && bury(deck.config) || b.limitReached(card.DeckID, isNew) {
+		return false
+	}
+	for _, l := range deck.limits {
+		if isNew {
+			l.new--
+		} else {
+			l.review--
+		}
+	}
+	b.seen[card.NoteID] = true
+	return true
+}
+
+// limitReached reports whether a deck, or one of its parents, has no more
+// cards left today.
+func (b *queueBuilder) limitReached(deckID int64, isNew bool) bool {
+	return slices.ContainsFunc(b.decks[deckID].limits, func(l *queueLimit) bool {
+		return isNew && l.new == 0 || !isNew && l.review == 0
+	})
+}
+
+// gatherNew gathers the new cards within the limits, in the gather order.
+// As in Anki, the cards are read in that order until the limits are reached,
+// deck by deck when the order starts with the deck, so that only the cards
+// studied today are loaded.
+func (b *queueBuilder) gatherNew() ([]*Card, error) {
+	var order string
+	byDeck := false
+	switch b.config.GetNewCardGatherPriority() {
+	case pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_DECK:
+		byDeck, order = true, "due, ord, id"
+	case pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_DECK_THEN_RANDOM_NOTES:
+		byDeck, order = true, "queue_hash(nid, ?), ord, id"
+	case pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_LOWEST_POSITION:
+		order = "due, ord, id"
+	case pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_HIGHEST_POSITION:
+		order = "due DESC, ord, id"
+	case pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_RANDOM_NOTES:
+		order = "queue_hash(nid, ?), ord, id"
+	case pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_RANDOM_CARDS:
+		order = "queue_hash(id, ?)"
+	default:
+		order = "id"
+	}
+	args := []any{CardQueueNew}
+	if strings.Contains(order, "?") {
+		args = append(args, b.today)
+	}
+
+	var cards []*Card
+	gather := func(ids []int64, limitID int64) error {
+		query := getCardQuery + " WHERE did IN " + sqlIntList(ids) + " AND queue = ? ORDER BY " + order
+		for card, err := range sqlSelectSeq(b.q, scanCard, query, args...) {
+			if err != nil {
+				return err
+			}
+			if b.take(card, true, (*pb.DeckConfig).GetBuryNew) {
+				cards = append(cards, card)
+				if b.limitReached(limitID, true) {
+					break
+				}
+			}
+		}
+		return nil
+	}
+	if !byDeck {
+		if b.limitReached(b.root, true) {
+			return nil, nil
+		}
+		return cards, gather(b.deckIDs(), b.root)
+	}
+	for _, id := range b.deckIDs() {
+		if b.limitReached(b.root, true) {
+			break
+		}
+		if b.limitReached(id, true) {
+			continue
+		}
+		if err := gather([]int64{id}, id); err != nil {
+			return nil, err
+		}
+	}
+	return cards, nil
+}
+
+// sortNew sorts gathered new cards in the sort order.
+func (b *queueBuilder) sortNew(cards []*Card) {
+	var compare func(x, y *Card) int
+	switch b.config.GetNewCardSortOrder() {
+	case pb.DeckConfig_NEW_CARD_SORT_ORDER_TEMPLATE:
+		compare = func(x, y *Card) int {
+			return cmp.Compare(x.Ordinal, y.Ordinal)
+		}
+	case pb.DeckConfig_NEW_CARD_SORT_ORDER_TEMPLATE_THEN_RANDOM:
+		compare = func(x, y *Card) int {
+			return cmp.Or(cmp.Compare(x.Ordinal, y.Ordinal), b.compareRandom(x.ID, y.ID))
+		}
+	case pb.DeckConfig_NEW_CARD_SORT_ORDER_RANDOM_NOTE_THEN_TEMPLATE:
+		compare = func(x, y *Card) int {
+			return cmp.Or(b.compareRandom(x.NoteID, y.NoteID), cmp.Compare(x.Ordinal, y.Ordinal))
+		}
+	case pb.DeckConfig_NEW_CARD_SORT_ORDER_RANDOM_CARD:
+		compare = func(x, y *Card) int {
+			return b.compareRandom(x.ID, y.ID)
+		}
+	}
+	if compare != nil {
+		slices.SortStableFunc(cards, compare)
+	}
+}
+
+// sortReviews sorts review cards in the review order, which decides the cards
+// gathered within the limits. Cards due the same day come in random order.
+func (b *queueBuilder) sortReviews(cards []*Card) {
+	var compare func(x, y *Card) int
+	switch b.config.GetReviewOrder() {
+	case pb.DeckConfig_REVIEW_CARD_ORDER_DAY:
+		compare = func(x, y *Card) int {
+			return cmp.Compare(x.Due, y.Due)
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_DAY_THEN_DECK:
+		compare = func(x, y *Card) int {
+			return cmp.Or(cmp.Compare(x.Due, y.Due), b.compareDecks(x, y))
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_DECK_THEN_DAY:
+		compare = func(x, y *Card) int {
+			return cmp.Or(b.compareDecks(x, y), cmp.Compare(x.Due, y.Due))
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_INTERVALS_ASCENDING:
+		compare = func(x, y *Card) int {
+			return cmp.Compare(x.Interval, y.Interval)
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_INTERVALS_DESCENDING:
+		compare = func(x, y *Card) int {
+			return cmp.Compare(y.Interval, x.Interval)
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_EASE_ASCENDING:
+		compare = func(x, y *Card) int {
+			return cmp.Compare(x.Factor, y.Factor)
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_EASE_DESCENDING:
+		compare = func(x, y *Card) int {
+			return cmp.Compare(y.Factor, x.Factor)
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_RETRIEVABILITY_ASCENDING,
+		pb.DeckConfig_REVIEW_CARD_ORDER_RETRIEVABILITY_DESCENDING:
+		r := make(map[int64]float64, len(cards))
+		for _, card := range cards {
+			r[card.ID] = b.retrievability(card)
+		}
+		descending := b.config.GetReviewOrder() == pb.DeckConfig_REVIEW_CARD_ORDER_RETRIEVABILITY_DESCENDING
+		compare = func(x, y *Card) int {
+			if descending {
+				x, y = y, x
+			}
+			return cmp.Compare(r[x.ID], r[y.ID])
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_ADDED:
+		compare = func(x, y *Card) int {
+			return cmp.Or(cmp.Compare(x.NoteID, y.NoteID), cmp.Compare(x.Ordinal, y.Ordinal))
+		}
+	case pb.DeckConfig_REVIEW_CARD_ORDER_REVERSE_ADDED:
+		compare = func(x, y *Card) int {
+			return cmp.Or(cmp.Compare(y.NoteID, x.NoteID), cmp.Compare(x.Ordinal, y.Ordinal))
+		}
+	}
+	slices.SortStableFunc(cards, func(x, y *Card) int {
+		if compare != nil {
+			if c := compare(x, y); c != 0 {
+				return c
+			}
+		}
+		return b.compareRandom(x.ID, y.ID)
+	})
+}
+
+// retrievability estimates the probability of recalling a review card now,
+// from its memory state or, failing that, from its interval as if it were
+// recalled with a probability of 90% when due.
+func (b *queueBuilder) retrievability(card *Card) float64 {
+	due := card.Due
+	if card.OriginalDeckID != 0 {
+		due = card.OriginalDue
+	}
+	w := newFSRSParams(b.decks[card.DeckID].config)
+	stability := float64(max(card.Interval, 1))
+	data := parseCardData(card.Data)
+	if m, ok := data.memoryState(); ok {
+		stability = m.stability
+	}
+	last := due - card.Interval
+	if t, ok := data.lastReview(); ok {
+		last = b.p.daysElapsed(t)
+	}
+	return w.retrievability(float64(max(b.today-last, 0)), stability)
+}
+
+// compareDecks compares cards by the alphabetical order of their decks.
+func (b *queueBuilder) compareDecks(x, y *Card) int {
+	return cmp.Compare(b.decks[x.DeckID].order, b.decks[y.DeckID].order)
+}
+
+// compareRandom compares IDs in a random order, which stays the same all day.
+func (b *queueBuilder) compareRandom(x, y int64) int {
+	return cmp.Compare(b.hash(x), b.hash(y))
+}
+
+// hash mixes an ID with the day of the queue.
+func (b *queueBuilder) hash(id int64) uint64 {
+	return queueHash(id, b.today)
+}
+
+// queueHash mixes an ID with a day, as in the SplitMix64 generator.
+func queueHash(id, day int64) uint64 {
+	z := uint64(id) + uint64(day)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// mixQueue mixes cards into a queue: spread evenly among its cards, or after
+// or before them.
+func mixQueue(queue, cards []*Card, mix pb.DeckConfig_ReviewMix) []*Card {
+	switch mix {
+	case pb.DeckConfig_REVIEW_MIX_AFTER_REVIEWS:
+		return slices.Concat(queue, cards)
+	case pb.DeckConfig_REVIEW_MIX_BEFORE_REVIEWS:
+		return slices.Concat(cards, queue)
+	}
+	mixed := make([]*Card, 0, len(queue)+len(cards))
+	i, j := 0, 0
+	for i < len(queue) || j < len(cards) {
+		// The next card comes from the list that is the least far along.
+		if j == len(cards) || i < len(queue) && (i+1)*(len(cards)+1) <= (j+1)*(len(queue)+1) {
+			mixed = append(mixed, queue[i])
+			i++
+		} else {
+			mixed = append(mixed, cards[j])
+			j++
+		}
+	}
+	return mixed
+}
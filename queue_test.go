@@ -0,0 +1,263 @@
+package anki
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lftk/anki/pb"
+)
+
+// TestQueue tests building the queue of a deck.
+func TestQueue(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	type want struct {
+		cards                          []string
+		newCount, learnCount, revCount int
+	}
+	tests := []struct {
+		name   string
+		config func(config *pb.DeckConfig, child *pb.DeckNormal)
+		want   want
+	}{
+		{
+			name: "default orders",
+			want: want{
+				cards:    []string{"l1/0", "r1/0", "r2/1", "r2/0", "a1/0", "a2/0", "b1/0", "a1/1", "a2/1", "r1/1", "b1/1", "l1/1"},
+				newCount: 7, learnCount: 2, revCount: 3,
+			},
+		},
+		{
+			name: "limits",
+			config: func(config *pb.DeckConfig, child *pb.DeckNormal) {
+				config.NewPerDay = 3
+				config.ReviewsPerDay = 5
+				limit := uint32(1)
+				child.NewLimit = &limit
+			},
+			want: want{
+				cards:    []string{"l1/0", "r1/0", "r2/1", "r2/0", "a1/0", "a1/1", "l1/1"},
+				newCount: 2, learnCount: 2, revCount: 3,
+			},
+		},
+		{
+			name: "limits for today",
+			config: func(config *pb.DeckConfig, child *pb.DeckNormal) {
+				config.ReviewsPerDay = 1
+				child.ReviewLimitToday = &pb.DeckNormal_DayLimit{Limit: 0, Today: 1}
+			},
+			want: want{
+				cards:      []string{"l1/0", "r1/0", "l1/1"},
+				learnCount: 2, revCount: 1,
+			},
+		},
+		{
+			name: "bury siblings",
+			config: func(config *pb.DeckConfig, _ *pb.DeckNormal) {
+				config.BuryNew = true
+				config.BuryReviews = true
+			},
+			want: want{
+				cards:    []string{"l1/0", "r1/0", "r2/1", "a1/0", "a2/0", "b1/0", "l1/1"},
+				newCount: 3, learnCount: 2, revCount: 2,
+			},
+		},
+		{
+			name: "other orders",
+			config: func(config *pb.DeckConfig, _ *pb.DeckNormal) {
+				config.NewCardGatherPriority = pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_HIGHEST_POSITION
+				config.NewCardSortOrder = pb.DeckConfig_NEW_CARD_SORT_ORDER_NO_SORT
+				config.ReviewOrder = pb.DeckConfig_REVIEW_CARD_ORDER_INTERVALS_ASCENDING
+				config.NewMix = pb.DeckConfig_REVIEW_MIX_BEFORE_REVIEWS
+			},
+			want: want{
+				cards:    []string{"l1/0", "r1/1", "b1/0", "b1/1", "a2/0", "a2/1", "a1/0", "a1/1", "r2/0", "r2/1", "r1/0", "l1/1"},
+				newCount: 7, learnCount: 2, revCount: 3,
+			},
+		},
+		{
+			name: "deck then random notes",
+			config: func(config *pb.DeckConfig, _ *pb.DeckNormal) {
+				config.NewPerDay = 3
+				config.NewCardGatherPriority = pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_DECK_THEN_RANDOM_NOTES
+				config.NewCardSortOrder = pb.DeckConfig_NEW_CARD_SORT_ORDER_NO_SORT
+			},
+			want: want{
+				cards:    []string{"l1/0", "r1/0", "r2/1", "r2/0", "a1/0", "a1/1", "a2/0", "l1/1"},
+				newCount: 3, learnCount: 2, revCount: 3,
+			},
+		},
+		{
+			name: "random cards",
+			config: func(config *pb.DeckConfig, _ *pb.DeckNormal) {
+				config.NewPerDay = 3
+				config.NewCardGatherPriority = pb.DeckConfig_NEW_CARD_GATHER_PRIORITY_RANDOM_CARDS
+				config.NewCardSortOrder = pb.DeckConfig_NEW_CARD_SORT_ORDER_NO_SORT
+			},
+			want: want{
+				cards:    []string{"l1/0", "r1/0", "r2/1", "r2/0", "a1/0", "a2/0", "b1/1", "l1/1"},
+				newCount: 3, learnCount: 2, revCount: 3,
+			},
+		},
+		{
+			name: "mix with reviews",
+			config: func(config *pb.DeckConfig, _ *pb.DeckNormal) {
+				config.NewPerDay = 2
+				config.NewMix = pb.DeckConfig_REVIEW_MIX_MIX_WITH_REVIEWS
+			},
+			want: want{
+				cards:    []string{"l1/0", "r1/0", "a1/0", "r2/1", "a1/1", "r2/0", "l1/1"},
+				newCount: 2, learnCount: 2, revCount: 3,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			col, labels := newQueueCollection(t, now, tt.config)
+			defer col.Close() //nolint:errcheck
+
+			queue, err := col.Queue(labels.root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for card := range queue.Cards() {
+				got = append(got, labels.cards[card.ID])
+			}
+			if !slices.Equal(got, tt.want.cards) {
+				t.Errorf("cards = %v, want %v", got, tt.want.cards)
+			}
+			counts := [3]int{queue.NewCount, queue.LearnCount, queue.ReviewCount}
+			if want := [3]int{tt.want.newCount, tt.want.learnCount, tt.want.revCount}; counts != want {
+				t.Errorf("counts = %v, want %v", counts, want)
+			}
+		})
+	}
+
+	// Answered cards count against the limits of the day.
+	col, labels := newQueueCollection(t, now, func(config *pb.DeckConfig, _ *pb.DeckNormal) {
+		config.NewPerDay = 2
+	})
+	defer col.Close() //nolint:errcheck
+	for id, label := range labels.cards {
+		if label == "a1/0" {
+			if err := col.AnswerCard(id, ReviewEaseGood, time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	queue, err := col.Queue(labels.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue.NewCount != 1 {
+		t.Errorf("new count after answering a new card = %d, want 1", queue.NewCount)
+	}
+}
+
+// queueLabels maps the cards of newQueueCollection to their labels.
+type queueLabels struct {
+	root  int64
+	cards map[int64]string
+}
+
+// newQueueCollection creates a collection with a deck A and its subdeck A::B,
+// holding notes of two cards labeled by their note and template:
+//
+//   - a1 and a2 in A, and b1 in B, with new cards at positions 1, 2 and 3
+//   - r1 in A, with a review card due two days ago and a new card at
+//     position 4
+//   - r2 in B, with review cards due yesterday and today
+//   - l1 in A, with learning cards due now and in ten minutes
+//
+// The configuration of the decks, and the deck B, can be changed by config.
+func newQueueCollection(t *testing.T, now time.Time, config func(*pb.DeckConfig, *pb.DeckNormal)) (*Collection, *queueLabels) {
+	t.Helper()
+	col, err := CreateInMemory(&CreateOptions{
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := col.props.daysElapsed(now)
+
+	deckConfig := DefaultDeckConfig()
+	deckConfig.NewMix = pb.DeckConfig_REVIEW_MIX_AFTER_REVIEWS
+	child := &pb.DeckNormal{ConfigId: 1}
+	if config != nil {
+		config(deckConfig, child)
+	}
+	if lt := child.GetReviewLimitToday(); lt != nil {
+		lt.Today = uint32(today)
+	}
+	if err = col.AddDeckConfig(&DeckConfig{ID: 1, Name: "Default", Config: deckConfig}); err != nil {
+		t.Fatal(err)
+	}
+	parent := &Deck{Name: "A", Kind: NormalDeckKind(1)}
+	if err = col.AddDeck(parent); err != nil {
+		t.Fatal(err)
+	}
+	sub := &Deck{Name: JoinDeckName("A", "B"), Kind: &pb.DeckKind{Kind: &pb.DeckKind_Normal{Normal: child}}}
+	if err = col.AddDeck(sub); err != nil {
+		t.Fatal(err)
+	}
+	notetype := &Notetype{
+		Name:   "Basic (and reversed card)",
+		Config: NewNotetypeConfig("", false),
+		Fields: []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{
+			NewTemplate("Card 1", "{{Front}}", "{{Back}}"),
+			NewTemplate("Card 2", "{{Back}}", "{{Front}}"),
+		},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+
+	review := func(due, interval int64) func(*Card) {
+		return func(card *Card) {
+			card.Type, card.Queue = CardTypeReview, CardQueueReview
+			card.Due, card.Interval, card.Factor = today+due, interval, 2500
+		}
+	}
+	learn := func(due time.Duration) func(*Card) {
+		return func(card *Card) {
+			card.Type, card.Queue = CardTypeLearn, CardQueueLearn
+			card.Due, card.Left = now.Add(due).Unix(), 1
+		}
+	}
+	position := func(pos int64) func(*Card) {
+		return func(card *Card) { card.Due = pos }
+	}
+	notes := []struct {
+		name  string
+		deck  int64
+		cards [2]func(*Card)
+	}{
+		{name: "a1", deck: parent.ID, cards: [2]func(*Card){position(1), position(1)}},
+		{name: "a2", deck: parent.ID, cards: [2]func(*Card){position(2), position(2)}},
+		{name: "b1", deck: sub.ID, cards: [2]func(*Card){position(3), position(3)}},
+		{name: "r1", deck: parent.ID, cards: [2]func(*Card){review(-2, 5), position(4)}},
+		{name: "r2", deck: sub.ID, cards: [2]func(*Card){review(0, 1), review(-1, 3)}},
+		{name: "l1", deck: parent.ID, cards: [2]func(*Card){learn(-time.Minute), learn(10 * time.Minute)}},
+	}
+	labels := &queueLabels{root: parent.ID, cards: make(map[int64]string)}
+	for _, n := range notes {
+		note := &Note{NotetypeID: notetype.ID, Fields: []string{n.name, n.name}}
+		if err = col.AddNote(n.deck, note); err != nil {
+			t.Fatal(err)
+		}
+		for card, err := range col.ListCards(&ListCardsOptions{NoteID: &note.ID}) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.cards[card.Ordinal](card)
+			if err = updateCard(col.db, card); err != nil {
+				t.Fatal(err)
+			}
+			labels.cards[card.ID] = fmt.Sprintf("%s/%d", n.name, card.Ordinal)
+		}
+	}
+	return col, labels
+}
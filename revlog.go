@@ -0,0 +1,204 @@
+package anki
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"strings"
+	"time"
+)
+
+// ReviewLog represents a review of a card in Anki.
+type ReviewLog struct {
+	// ID is the time of the review in milliseconds, as in Anki.
+	ID     int64
+	CardID int64
+	USN    int64
+	Ease   ReviewEase
+	// Interval is the interval of the card after the review, in days if
+	// positive and in seconds if negative.
+	Interval int64
+	// LastInterval is the interval of the card before the review, in the
+	// same unit as Interval.
+	LastInterval int64
+	// Factor is the ease factor of the card after the review, in permille.
+	Factor int64
+	// TimeTaken is the time spent answering the card.
+	TimeTaken time.Duration
+	Type      ReviewType
+}
+
+// Time returns the time of the review, given by its ID.
+func (r *ReviewLog) Time() time.Time {
+	return time.UnixMilli(r.ID)
+}
+
+// ReviewEase represents the answer button chosen in a review.
+type ReviewEase int
+
+const (
+	// ReviewEaseManual is recorded for cards rescheduled without a review.
+	ReviewEaseManual ReviewEase = 0
+	// ReviewEaseAgain is the "Again" button.
+	ReviewEaseAgain ReviewEase = 1
+	// ReviewEaseHard is the "Hard" button.
+	ReviewEaseHard ReviewEase = 2
+	// ReviewEaseGood is the "Good" button.
+	ReviewEaseGood ReviewEase = 3
+	// ReviewEaseEasy is the "Easy" button.
+	ReviewEaseEasy ReviewEase = 4
+)
+
+// ReviewType represents the type of a review.
+type ReviewType int
+
+const (
+	// ReviewTypeLearn is a review of a learning card.
+	ReviewTypeLearn ReviewType = 0
+	// ReviewTypeReview is a review of a review card.
+	ReviewTypeReview ReviewType = 1
+	// ReviewTypeRelearn is a review of a relearning card.
+	ReviewTypeRelearn ReviewType = 2
+	// ReviewTypeFiltered is a review of a card in a filtered deck ahead of
+	// its due date.
+	ReviewTypeFiltered ReviewType = 3
+	// ReviewTypeManual is a manual change of the schedule of a card.
+	ReviewTypeManual ReviewType = 4
+	// ReviewTypeRescheduled is a rescheduling of a card by the scheduler,
+	// as when its deck options change.
+	ReviewTypeRescheduled ReviewType = 5
+)
+
+// AddReviewLog adds a review to the collection. If the review's ID is zero,
+// the review is recorded at the time of the collection's clock; if the ID is
+// taken, the review is recorded just after the latest one. The review is
+// marked as not synced yet.
+func (c *Collection) AddReviewLog(log *ReviewLog) error {
+	return c.transact(func(tx *sql.Tx) error {
+		log.USN = -1
+		return addReviewLog(tx, log, c.ids)
+	})
+}
+
+// DeleteReviewLogs deletes reviews from the collection by their IDs.
+func (c *Collection) DeleteReviewLogs(ids []int64) error {
+	return c.transact(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := sqlExecute(tx, deleteReviewLogQuery, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addReviewLog adds a review to the database.
+// If the review's ID is zero or already taken, a new ID is allocated from ids.
+func addReviewLog(e sqlExecer, log *ReviewLog, ids *idAllocator) error {
+	id := log.ID
+	if id == 0 {
+		id = ids.next()
+	}
+	args := []any{
+		id,
+		log.CardID,
+		log.USN,
+		log.Ease,
+		log.Interval,
+		log.LastInterval,
+		log.Factor,
+		log.TimeTaken.Milliseconds(),
+		log.Type,
+	}
+	id, err := sqlInsert(e, addReviewLogQuery, args...)
+	if err == nil {
+		log.ID = id
+		ids.use(id)
+	}
+	return err
+}
+
+// ListReviewLogsOptions specifies options for listing reviews.
+type ListReviewLogsOptions struct {
+	CardID *int64
+	// DeckID selects the reviews of the cards in a deck and its subdecks,
+	// including those moved from them to a filtered deck.
+	DeckID *int64
+	// Since and Until select the reviews at or after Since and before Until,
+	// when they are not zero.
+	Since time.Time
+	Until time.Time
+}
+
+// ListReviewLogs lists reviews with optional filtering, from the earliest to
+// the latest.
+func (c *Collection) ListReviewLogs(opts *ListReviewLogsOptions) iter.Seq2[*ReviewLog, error] {
+	return listReviewLogs(c.db, opts)
+}
+
+// ListReviewLogsContext is like ListReviewLogs, but stops the iteration with
+// an error once ctx is done.
+func (c *Collection) ListReviewLogsContext(ctx context.Context, opts *ListReviewLogsOptions) iter.Seq2[*ReviewLog, error] {
+	return listReviewLogs(sqlWithContext(ctx, c.db), opts)
+}
+
+// listReviewLogs lists reviews with optional filtering.
+func listReviewLogs(q sqlQueryer, opts *ListReviewLogsOptions) iter.Seq2[*ReviewLog, error] {
+	var args []any
+	var conds []string
+
+	if opts != nil {
+		if opts.CardID != nil {
+			conds = append(conds, "cid = ?")
+			args = append(args, *opts.CardID)
+		}
+
+		if opts.DeckID != nil {
+			conds = append(conds, "cid IN (SELECT id FROM cards WHERE did IN ("+getDeckTreeQuery+") OR odid IN ("+getDeckTreeQuery+"))")
+			args = append(args, *opts.DeckID, *opts.DeckID)
+		}
+
+		if !opts.Since.IsZero() {
+			conds = append(conds, "id >= ?")
+			args = append(args, opts.Since.UnixMilli())
+		}
+
+		if !opts.Until.IsZero() {
+			conds = append(conds, "id < ?")
+			args = append(args, opts.Until.UnixMilli())
+		}
+	}
+
+	query := getReviewLogQuery
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id"
+
+	return sqlSelectSeq(q, scanReviewLog, query, args...)
+}
+
+// scanReviewLog scans a review from a database row.
+func scanReviewLog(_ sqlQueryer, row sqlRow) (*ReviewLog, error) {
+	var log ReviewLog
+	var timeTaken int64
+
+	dest := []any{
+		&log.ID,
+		&log.CardID,
+		&log.USN,
+		&log.Ease,
+		&log.Interval,
+		&log.LastInterval,
+		&log.Factor,
+		&timeTaken,
+		&log.Type,
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	log.TimeTaken = time.Duration(timeTaken) * time.Millisecond
+
+	return &log, nil
+}
@@ -0,0 +1,135 @@
+package anki
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestReviewLogs tests adding, listing and deleting reviews.
+func TestReviewLogs(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	col, err := CreateInMemory(&CreateOptions{
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+	if err = col.AddNote(1, note); err != nil {
+		t.Fatal(err)
+	}
+	var cardID int64
+	for card, err := range col.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		cardID = card.ID
+	}
+	// The reviews of a subdeck are listed with its parent, whatever the case
+	// of the name of the parent, but not those of a deck whose name only
+	// starts like it.
+	var childDeckID int64
+	childCards := make(map[string]int64)
+	for _, name := range []DeckName{JoinDeckName("Default", "Child"), "Default_", JoinDeckName("default", "Mixed")} {
+		deck := &Deck{Name: name}
+		if err = col.AddDeck(deck); err != nil {
+			t.Fatal(err)
+		}
+		if childDeckID == 0 {
+			childDeckID = deck.ID
+		}
+		note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+		if err = col.AddNote(deck.ID, note); err != nil {
+			t.Fatal(err)
+		}
+		for card, err := range col.ListCards(&ListCardsOptions{NoteID: &note.ID}) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			childCards[name.HumanString()] = card.ID
+		}
+	}
+
+	day := 24 * time.Hour
+	past := now.Add(-10 * day).UnixMilli()
+	logs := []*ReviewLog{
+		{ID: past, CardID: cardID, Ease: ReviewEaseGood, Interval: 1, TimeTaken: 5 * time.Second, Type: ReviewTypeLearn},
+		// The ID is taken, so the review is moved just after it.
+		{ID: past, CardID: cardID, Ease: ReviewEaseAgain, Interval: -600, LastInterval: 1, Type: ReviewTypeRelearn},
+		// The ID is zero, so the review is recorded now.
+		{CardID: cardID, Ease: ReviewEaseEasy, Interval: 4, Factor: 2650, Type: ReviewTypeReview},
+		{ID: past - 1, CardID: -1, Ease: ReviewEaseHard, Type: ReviewTypeReview},
+		{ID: past + 2, CardID: childCards["Default::Child"], Ease: ReviewEaseGood, Type: ReviewTypeReview},
+		{ID: past + 3, CardID: childCards["Default_"], Ease: ReviewEaseGood, Type: ReviewTypeReview},
+		{ID: past + 4, CardID: childCards["default::Mixed"], Ease: ReviewEaseGood, Type: ReviewTypeReview},
+	}
+	for _, log := range logs {
+		if err = col.AddReviewLog(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logs[1].ID != past+1 {
+		t.Errorf("taken ID moved to %d, want %d", logs[1].ID, past+1)
+	}
+	if logs[2].Time().Before(now) {
+		t.Errorf("zero ID allocated as %d, want the time of the clock", logs[2].ID)
+	}
+
+	deckID := int64(1)
+	tests := []struct {
+		name string
+		opts *ListReviewLogsOptions
+		want []int
+	}{
+		{name: "all", want: []int{3, 0, 1, 4, 5, 6, 2}},
+		{name: "card", opts: &ListReviewLogsOptions{CardID: &cardID}, want: []int{0, 1, 2}},
+		{name: "deck", opts: &ListReviewLogsOptions{DeckID: &deckID}, want: []int{0, 1, 4, 6, 2}},
+		{name: "subdeck", opts: &ListReviewLogsOptions{DeckID: &childDeckID}, want: []int{4}},
+		{name: "since", opts: &ListReviewLogsOptions{Since: now.Add(-day)}, want: []int{2}},
+		{name: "until", opts: &ListReviewLogsOptions{Until: time.UnixMilli(past + 1)}, want: []int{3, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for log, err := range col.ListReviewLogs(tt.opts) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				i := slices.IndexFunc(logs, func(l *ReviewLog) bool { return l.ID == log.ID })
+				if i >= 0 && *log != *logs[i] {
+					t.Errorf("got %+v, want %+v", log, logs[i])
+				}
+				got = append(got, i)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ListReviewLogs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err = col.DeleteReviewLogs([]int64{logs[0].ID, logs[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, err := range col.ListReviewLogs(&ListReviewLogsOptions{CardID: &cardID}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("got %d reviews after deleting, want 1", n)
+	}
+}
@@ -0,0 +1,731 @@
+package anki
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lftk/anki/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrInvalidRating is returned when answering a card with a rating other than
+// Again, Hard, Good or Easy.
+var ErrInvalidRating = errors.New("invalid rating")
+
+// Constants of the SM-2 algorithm, as in Anki.
+const (
+	minimumEaseFactor    = 1.3
+	easeFactorAgainDelta = -0.2
+	easeFactorHardDelta  = -0.15
+	easeFactorEasyDelta  = 0.15
+)
+
+// leechTag is the tag added to the notes of leeches.
+const leechTag = "leech"
+
+// AnswerCard answers a card with a rating and records the review, as the v3
+// scheduler of Anki does with the SM-2 algorithm. timeTaken is the time spent
+// answering, which is recorded up to the limit of the deck configuration.
+//
+// New cards go through the learning steps of their deck configuration before
+// graduating to review cards, whose interval then grows with their ease
+// factor, up to the maximum interval. Review cards answered with Again lapse
+// into the relearning steps, and become leeches when they lapse too often.
+// Review intervals are fuzzed, so that cards learned together spread over
+// several days.
+//
+// When FSRS is enabled, review intervals are instead those after which the
+// card is recalled with the desired retention, as predicted from its memory
+// state, which is updated and kept in the card data.
+func (c *Collection) AnswerCard(cardID int64, rating ReviewEase, timeTaken time.Duration) error {
+	if rating < ReviewEaseAgain || rating > ReviewEaseEasy {
+		return fmt.Errorf("%w: %d", ErrInvalidRating, rating)
+	}
+	return c.transact(func(tx *sql.Tx) error {
+		return answerCard(tx, cardID, rating, timeTaken, c.props, c.ids)
+	})
+}
+
+// answerCard answers a card at the time of the clock of ids.
+func answerCard(tx *sql.Tx, cardID int64, rating ReviewEase, timeTaken time.Duration, p *props, ids *idAllocator) error {
+	card, err := getCard(tx, cardID)
+	if err != nil {
+		return err
+	}
+	ctx, config, err := loadSchedulingContext(tx, card, p, ids.now())
+	if err != nil {
+		return err
+	}
+
+	current := ctx.currentState(card)
+	next := current.answer(ctx, rating)
+	// The studied deck is counted, even if the card leaves it.
+	deckID, queue := card.DeckID, card.Queue
+	ctx.apply(card, next)
+	if err = updateCard(tx, card); err != nil {
+		return err
+	}
+	if next.leeched {
+		if err = tagLeech(tx, card.NoteID, ids); err != nil {
+			return err
+		}
+	}
+
+	if limit := time.Duration(config.Config.GetCapAnswerTimeToSecs()) * time.Second; limit > 0 {
+		timeTaken = min(timeTaken, limit)
+	}
+	log := &ReviewLog{
+		CardID:       card.ID,
+		USN:          -1,
+		Ease:         rating,
+		Interval:     ctx.revlogInterval(next),
+		LastInterval: ctx.revlogInterval(current),
+		Factor:       next.revlogFactor(),
+		TimeTaken:    timeTaken,
+		Type:         current.reviewType(),
+	}
+	if err = addReviewLog(tx, log, ids); err != nil {
+		return err
+	}
+	return updateDeckStats(tx, deckID, queue, timeTaken, ctx.today, ctx.now)
+}
+
+// updateDeckStats counts a card answered today from a queue in the cards
+// studied in a deck and its parents, which the per-day limits depend on.
+func updateDeckStats(tx *sql.Tx, deckID int64, queue CardQueue, timeTaken time.Duration, today int64, now time.Time) error {
+	deck, err := getDeck(tx, deckID)
+	if err != nil {
+		return err
+	}
+	for {
+		common := deck.Common
+		if int64(common.LastDayStudied) != today {
+			common.LastDayStudied = uint32(today)
+			common.NewStudied = 0
+			common.ReviewStudied = 0
+			common.LearningStudied = 0
+			common.MillisecondsStudied = 0
+		}
+		switch queue {
+		case CardQueueNew:
+			common.NewStudied++
+		case CardQueueReview, CardQueueDayLearn:
+			common.ReviewStudied++
+		}
+		common.MillisecondsStudied += int32(timeTaken.Milliseconds())
+		b, err := proto.Marshal(common)
+		if err != nil {
+			return err
+		}
+		if err = sqlExecute(tx, updateDeckCommonQuery, timeUnix(now), -1, b, deck.ID); err != nil {
+			return err
+		}
+
+		parent := deck.Name.Parent()
+		if parent == "" {
+			return nil
+		}
+		deck, err = sqlGet(tx, scanDeck, getDeckQuery+" WHERE name = ?", parent)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// NextIntervals returns the delays after which a card would be due again if
+// answered now with each rating.
+func (c *Collection) NextIntervals(cardID int64) (map[ReviewEase]time.Duration, error) {
+	card, err := getCard(c.db, cardID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, _, err := loadSchedulingContext(c.db, card, c.props, c.ids.now())
+	if err != nil {
+		return nil, err
+	}
+	current := ctx.currentState(card)
+	intervals := make(map[ReviewEase]time.Duration, 4)
+	for rating := ReviewEaseAgain; rating <= ReviewEaseEasy; rating++ {
+		next := current.answer(ctx, rating)
+		if next.kind == cardStateReview {
+			intervals[rating] = time.Duration(next.scheduledDays) * 24 * time.Hour
+		} else {
+			intervals[rating] = time.Duration(next.scheduledSecs) * time.Second
+		}
+	}
+	return intervals, nil
+}
+
+// loadSchedulingContext loads what the scheduler needs to answer a card at
+// time now, along with the deck configuration of the card.
+func loadSchedulingContext(q sqlQueryer, card *Card, p *props, now time.Time) (*schedulingContext, *DeckConfig, error) {
+	config, err := cardDeckConfig(q, card)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx := newSchedulingContext(card, config.Config, p, now)
+	enabled, err := getConfigValue(q, fsrsConfigKey, false)
+	if err == nil && enabled {
+		ctx.fsrs, err = newFSRSStates(q, card, config.Config, p, now)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ctx, config, nil
+}
+
+// cardDeckConfig returns the configuration of the home deck of a card, or
+// the default configuration if the deck or its configuration is missing.
+func cardDeckConfig(q sqlQueryer, card *Card) (*DeckConfig, error) {
+	deckID := card.DeckID
+	if card.OriginalDeckID != 0 {
+		deckID = card.OriginalDeckID
+	}
+	configID := int64(1)
+	deck, err := getDeck(q, deckID)
+	if err == nil {
+		if id := deck.Kind.GetNormal().GetConfigId(); id != 0 {
+			configID = id
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	config, err := getDeckConfig(q, configID)
+	if errors.Is(err, sql.ErrNoRows) && configID != 1 {
+		return getDeckConfig(q, 1)
+	}
+	return config, err
+}
+
+// tagLeech adds the leech tag to a note.
+func tagLeech(tx *sql.Tx, noteID int64, ids *idAllocator) error {
+	note, err := getNote(tx, noteID)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(note.Tags, func(tag string) bool {
+		return strings.EqualFold(tag, leechTag)
+	}) {
+		return nil
+	}
+	notetype, err := getNotetype(tx, note.NotetypeID)
+	if err != nil {
+		return err
+	}
+	note.Tags = append(note.Tags, leechTag)
+	if err = updateNoteWithoutCards(tx, note, notetype, ids); err != nil {
+		return err
+	}
+	return sqlExecute(tx, addTagQuery, leechTag, -1, false)
+}
+
+// schedulingContext holds what the scheduler needs to answer a card.
+type schedulingContext struct {
+	config       *pb.DeckConfig
+	learnSteps   learningSteps
+	relearnSteps learningSteps
+	// fuzz is a number in [0, 1) that picks an interval within the fuzz
+	// range. It is derived from the card, so that answers are reproducible.
+	fuzz float64
+	now  time.Time
+	// today is the number of days elapsed since the collection was created,
+	// and secsUntilRollover the number of seconds until the next day.
+	today             int64
+	secsUntilRollover int64
+	// fsrs holds the next memory states of the card when FSRS is enabled.
+	fsrs *fsrsStates
+}
+
+// newSchedulingContext creates a context to answer a card at time now.
+func newSchedulingContext(card *Card, config *pb.DeckConfig, p *props, now time.Time) *schedulingContext {
+	rng := rand.New(rand.NewPCG(uint64(card.ID), uint64(card.Repetitions)))
+	return &schedulingContext{
+		config:            config,
+		learnSteps:        config.GetLearnSteps(),
+		relearnSteps:      config.GetRelearnSteps(),
+		fuzz:              rng.Float64(),
+		now:               now,
+		today:             p.daysElapsed(now),
+		secsUntilRollover: int64(p.dayCutoff(now).Sub(now) / time.Second),
+	}
+}
+
+// cardStateKind is the kind of a cardState.
+type cardStateKind int
+
+const (
+	cardStateNew cardStateKind = iota
+	cardStateLearning
+	cardStateReview
+	cardStateRelearning
+)
+
+// cardState is the scheduling state of a card, as in the v3 scheduler of
+// Anki. Learning and relearning cards go through steps, and wait a number of
+// seconds until the next one. Review and relearning cards have an interval in
+// days and an ease factor. With FSRS, answered cards also have a memory state.
+type cardState struct {
+	kind           cardStateKind
+	remainingSteps int64
+	scheduledSecs  int64
+	scheduledDays  int64
+	elapsedDays    int64
+	easeFactor     float64
+	lapses         int64
+	leeched        bool
+	memory         *memoryState
+}
+
+// currentState returns the state of a card.
+func (ctx *schedulingContext) currentState(card *Card) cardState {
+	remaining := card.Left % 1000
+	ease := float64(card.Factor) / 1000
+	switch card.Type {
+	case CardTypeLearn:
+		return cardState{
+			kind:           cardStateLearning,
+			remainingSteps: remaining,
+			scheduledSecs:  ctx.learnSteps.currentDelay(remaining),
+		}
+	case CardTypeReview:
+		due := card.Due
+		if card.OriginalDeckID != 0 {
+			due = card.OriginalDue
+		}
+		return cardState{
+			kind:          cardStateReview,
+			scheduledDays: card.Interval,
+			elapsedDays:   max(ctx.today-(due-card.Interval), 0),
+			easeFactor:    ease,
+			lapses:        card.Lapses,
+		}
+	case CardTypeRelearn:
+		return cardState{
+			kind:           cardStateRelearning,
+			remainingSteps: remaining,
+			scheduledSecs:  ctx.relearnSteps.currentDelay(remaining),
+			scheduledDays:  card.Interval,
+			elapsedDays:    card.Interval,
+			easeFactor:     ease,
+			lapses:         card.Lapses,
+		}
+	}
+	return cardState{kind: cardStateNew}
+}
+
+// answer returns the state following s once answered with a rating.
+func (s cardState) answer(ctx *schedulingContext, rating ReviewEase) cardState {
+	var next cardState
+	switch s.kind {
+	case cardStateNew:
+		// New cards are answered from the first learning step.
+		learning := cardState{
+			kind:           cardStateLearning,
+			remainingSteps: ctx.learnSteps.remainingForFailed(),
+		}
+		next = learning.answerLearning(ctx, rating)
+	case cardStateLearning:
+		next = s.answerLearning(ctx, rating)
+	case cardStateReview:
+		next = s.answerReview(ctx, rating)
+	default:
+		next = s.answerRelearning(ctx, rating)
+	}
+	if ctx.fsrs != nil {
+		next.memory = &ctx.fsrs.memory[rating-1]
+	}
+	return next
+}
+
+func (s cardState) answerLearning(ctx *schedulingContext, rating ReviewEase) cardState {
+	steps := ctx.learnSteps
+	graduate := func(days uint32) cardState {
+		var interval int64
+		if ctx.fsrs != nil {
+			interval = ctx.fsrsGraduatingInterval(rating)
+		} else {
+			interval = ctx.constrainedInterval(float64(days), 1, true)
+		}
+		return cardState{
+			kind:          cardStateReview,
+			scheduledDays: interval,
+			easeFactor:    float64(ctx.config.GetInitialEase()),
+		}
+	}
+	switch rating {
+	case ReviewEaseAgain:
+		if delay, ok := steps.againDelay(); ok {
+			return cardState{
+				kind:           cardStateLearning,
+				remainingSteps: steps.remainingForFailed(),
+				scheduledSecs:  delay,
+			}
+		}
+	case ReviewEaseHard:
+		if delay, ok := steps.hardDelay(s.remainingSteps); ok {
+			s.scheduledSecs = delay
+			return s
+		}
+	case ReviewEaseGood:
+		if delay, ok := steps.goodDelay(s.remainingSteps); ok {
+			s.remainingSteps = steps.remainingForGood(s.remainingSteps)
+			s.scheduledSecs = delay
+			return s
+		}
+	case ReviewEaseEasy:
+		return graduate(ctx.config.GetGraduatingIntervalEasy())
+	}
+	return graduate(ctx.config.GetGraduatingIntervalGood())
+}
+
+func (s cardState) answerReview(ctx *schedulingContext, rating ReviewEase) cardState {
+	next := cardState{
+		kind:       cardStateReview,
+		easeFactor: s.easeFactor,
+		lapses:     s.lapses,
+	}
+	switch rating {
+	case ReviewEaseAgain:
+		next.lapses++
+		next.leeched = ctx.leechThresholdMet(next.lapses)
+		next.scheduledDays = ctx.failingInterval(s.scheduledDays)
+		if ctx.fsrs != nil {
+			next.scheduledDays = ctx.constrainedInterval(ctx.fsrs.interval(rating), int64(ctx.config.GetMinimumLapseInterval()), false)
+		}
+		next.easeFactor = max(s.easeFactor+easeFactorAgainDelta, minimumEaseFactor)
+		if delay, ok := ctx.relearnSteps.againDelay(); ok {
+			next.kind = cardStateRelearning
+			next.remainingSteps = ctx.relearnSteps.remainingForFailed()
+			next.scheduledSecs = delay
+		}
+	case ReviewEaseHard:
+		next.scheduledDays, _, _ = s.passingIntervals(ctx)
+		next.easeFactor = max(s.easeFactor+easeFactorHardDelta, minimumEaseFactor)
+	case ReviewEaseGood:
+		_, next.scheduledDays, _ = s.passingIntervals(ctx)
+	case ReviewEaseEasy:
+		_, _, next.scheduledDays = s.passingIntervals(ctx)
+		next.easeFactor = s.easeFactor + easeFactorEasyDelta
+	}
+	return next
+}
+
+func (s cardState) answerRelearning(ctx *schedulingContext, rating ReviewEase) cardState {
+	steps := ctx.relearnSteps
+	review := cardState{
+		kind:          cardStateReview,
+		scheduledDays: s.scheduledDays,
+		easeFactor:    s.easeFactor,
+		lapses:        s.lapses,
+	}
+	if ctx.fsrs != nil && rating >= ReviewEaseGood {
+		review.scheduledDays = ctx.fsrsGraduatingInterval(rating)
+	}
+	switch rating {
+	case ReviewEaseAgain:
+		if delay, ok := steps.againDelay(); ok {
+			s.remainingSteps = steps.remainingForFailed()
+			s.scheduledSecs = delay
+			return s
+		}
+	case ReviewEaseHard:
+		if delay, ok := steps.hardDelay(s.remainingSteps); ok {
+			s.scheduledSecs = delay
+			return s
+		}
+	case ReviewEaseGood:
+		if delay, ok := steps.goodDelay(s.remainingSteps); ok {
+			s.remainingSteps = steps.remainingForGood(s.remainingSteps)
+			s.scheduledSecs = delay
+			return s
+		}
+	case ReviewEaseEasy:
+		if ctx.fsrs == nil {
+			review.scheduledDays++
+		}
+	}
+	return review
+}
+
+// passingIntervals returns the intervals of a review card answered with
+// Hard, Good and Easy.
+func (s cardState) passingIntervals(ctx *schedulingContext) (hard, good, easy int64) {
+	if ctx.fsrs != nil {
+		hard = ctx.constrainedInterval(ctx.fsrs.interval(ReviewEaseHard), 1, true)
+		good = ctx.constrainedInterval(ctx.fsrs.interval(ReviewEaseGood), hard+1, true)
+		easy = ctx.constrainedInterval(ctx.fsrs.interval(ReviewEaseEasy), good+1, true)
+		return
+	}
+
+	scheduled := float64(s.scheduledDays)
+	daysLate := s.elapsedDays - s.scheduledDays
+	hardFactor := float64(ctx.config.GetHardMultiplier())
+	easyFactor := float64(ctx.config.GetEasyMultiplier())
+
+	if daysLate < 0 {
+		// The card is reviewed early, so the intervals grow from the days
+		// elapsed instead, without going below the current interval.
+		elapsed := float64(s.elapsedDays)
+		hard = ctx.passingInterval(max(elapsed*hardFactor, scheduled*hardFactor/2), 0, false)
+		good = ctx.passingInterval(max(elapsed*s.easeFactor, scheduled), 0, false)
+		easy = ctx.passingInterval(max(elapsed*s.easeFactor, scheduled)*(easyFactor-(easyFactor-1)/2), 0, false)
+		return
+	}
+
+	late := float64(daysLate)
+	hardMinimum, goodMinimum := int64(0), s.scheduledDays+1
+	if hardFactor > 1 {
+		hardMinimum = s.scheduledDays + 1
+	}
+	hard = ctx.passingInterval(scheduled*hardFactor, hardMinimum, true)
+	if hardFactor > 1 {
+		goodMinimum = hard + 1
+	}
+	good = ctx.passingInterval((scheduled+late/2)*s.easeFactor, goodMinimum, true)
+	easy = ctx.passingInterval((scheduled+late)*s.easeFactor*easyFactor, good+1, true)
+	return
+}
+
+// passingInterval applies the interval multiplier to an interval and bounds
+// it, fuzzing it if fuzz is true.
+func (ctx *schedulingContext) passingInterval(interval float64, minimum int64, fuzz bool) int64 {
+	return ctx.constrainedInterval(interval*float64(ctx.config.GetIntervalMultiplier()), minimum, fuzz)
+}
+
+// constrainedInterval bounds an interval, fuzzing it if fuzz is true.
+func (ctx *schedulingContext) constrainedInterval(interval float64, minimum int64, fuzz bool) int64 {
+	minimum, maximum := ctx.intervalBounds(minimum)
+	if fuzz {
+		return ctx.fuzzedInterval(interval, minimum, maximum)
+	}
+	return min(max(int64(math.Round(interval)), minimum), maximum)
+}
+
+// failingInterval returns the interval of a review card after a lapse.
+func (ctx *schedulingContext) failingInterval(scheduledDays int64) int64 {
+	minimum, maximum := ctx.intervalBounds(int64(ctx.config.GetMinimumLapseInterval()))
+	interval := int64(math.Round(float64(scheduledDays) * float64(ctx.config.GetLapseMultiplier())))
+	return min(max(interval, minimum), maximum)
+}
+
+// fsrsGraduatingInterval returns the interval of a learning or relearning
+// card graduating with Good or Easy under FSRS. Easy always gives a longer
+// interval than Good.
+func (ctx *schedulingContext) fsrsGraduatingInterval(rating ReviewEase) int64 {
+	good := ctx.constrainedInterval(ctx.fsrs.interval(ReviewEaseGood), 1, true)
+	if rating != ReviewEaseEasy {
+		return good
+	}
+	return ctx.constrainedInterval(ctx.fsrs.interval(ReviewEaseEasy), good+1, true)
+}
+
+// intervalBounds returns the bounds of a review interval, given a minimum.
+func (ctx *schedulingContext) intervalBounds(minimum int64) (int64, int64) {
+	maximum := max(int64(ctx.config.GetMaximumReviewInterval()), 1)
+	return min(max(minimum, 1), maximum), maximum
+}
+
+// leechThresholdMet reports whether a card becomes a leech after a number of
+// lapses: when it reaches the threshold, and every half threshold after,
+// rounded up as in Anki.
+func (ctx *schedulingContext) leechThresholdMet(lapses int64) bool {
+	threshold := int64(ctx.config.GetLeechThreshold())
+	if threshold == 0 {
+		return false
+	}
+	half := max((threshold+1)/2, 1)
+	return lapses >= threshold && (lapses-threshold)%half == 0
+}
+
+// fuzzRanges define how much review intervals are fuzzed: by one day, plus
+// a factor of the part of the interval within each range.
+var fuzzRanges = []struct {
+	start, end, factor float64
+}{
+	{start: 2.5, end: 7, factor: 0.15},
+	{start: 7, end: 20, factor: 0.1},
+	{start: 20, end: math.MaxFloat64, factor: 0.05},
+}
+
+// fuzzedInterval picks an interval around interval, between minimum and
+// maximum.
+func (ctx *schedulingContext) fuzzedInterval(interval float64, minimum, maximum int64) int64 {
+	minimum = min(minimum, maximum)
+	interval = min(max(interval, float64(minimum)), float64(maximum))
+	delta := 0.0
+	if interval >= 2.5 {
+		delta = 1
+		for _, r := range fuzzRanges {
+			delta += r.factor * max(min(interval, r.end)-r.start, 0)
+		}
+	}
+	lower := min(max(int64(math.Round(interval-delta)), minimum), maximum)
+	upper := min(max(int64(math.Round(interval+delta)), minimum), maximum)
+	if upper == lower && upper > 2 && upper < maximum {
+		upper = lower + 1
+	}
+	return lower + int64(ctx.fuzz*float64(upper-lower+1))
+}
+
+// apply updates a card to a new state.
+func (ctx *schedulingContext) apply(card *Card, next cardState) {
+	card.Repetitions++
+	card.Modified = ctx.now
+	card.USN = -1
+
+	switch next.kind {
+	case cardStateLearning, cardStateRelearning:
+		card.Type = CardTypeLearn
+		if next.kind == cardStateRelearning {
+			card.Type = CardTypeRelearn
+			card.Interval = next.scheduledDays
+			card.Factor = int64(math.Round(next.easeFactor * 1000))
+			card.Lapses = next.lapses
+		}
+		card.Left = next.remainingSteps
+		if next.scheduledSecs < ctx.secsUntilRollover {
+			// Delays are fuzzed by up to a quarter, or five minutes.
+			extra := min(next.scheduledSecs/4, 300)
+			card.Queue = CardQueueLearn
+			card.Due = ctx.now.Unix() + next.scheduledSecs + int64(ctx.fuzz*float64(extra))
+		} else {
+			card.Queue = CardQueueDayLearn
+			card.Due = ctx.today + (next.scheduledSecs-ctx.secsUntilRollover)/86400 + 1
+		}
+	case cardStateReview:
+		card.Type = CardTypeReview
+		card.Queue = CardQueueReview
+		card.Interval = next.scheduledDays
+		card.Due = ctx.today + next.scheduledDays
+		card.Factor = int64(math.Round(next.easeFactor * 1000))
+		card.Lapses = next.lapses
+		card.Left = 0
+		if card.OriginalDeckID != 0 {
+			// Cards leave filtered decks once they graduate.
+			card.DeckID = card.OriginalDeckID
+			card.OriginalDeckID = 0
+			card.OriginalDue = 0
+		}
+	}
+
+	if next.leeched && ctx.config.GetLeechAction() == pb.DeckConfig_LEECH_ACTION_SUSPEND {
+		card.Queue = CardQueueSuspended
+	}
+
+	// The memory state is removed when scheduling with SM-2, as it would be
+	// outdated by the review.
+	data := parseCardData(card.Data)
+	if ctx.fsrs != nil {
+		data.setMemoryState(next.memory, ctx.fsrs.retention, ctx.fsrs.params.decay())
+	} else {
+		data.setMemoryState(nil, 0, 0)
+	}
+	data.setLastReview(ctx.now)
+	card.Data = data.String()
+}
+
+// revlogInterval returns the interval of a state as recorded in reviews: in
+// days if positive, and in seconds if negative.
+func (ctx *schedulingContext) revlogInterval(s cardState) int64 {
+	switch s.kind {
+	case cardStateNew:
+		return 0
+	case cardStateReview:
+		return s.scheduledDays
+	}
+	if s.scheduledSecs >= ctx.secsUntilRollover {
+		return (s.scheduledSecs-ctx.secsUntilRollover)/86400 + 1
+	}
+	return -s.scheduledSecs
+}
+
+// revlogFactor returns the ease factor of a state as recorded in reviews.
+func (s cardState) revlogFactor() int64 {
+	if s.kind == cardStateReview || s.kind == cardStateRelearning {
+		return int64(math.Round(s.easeFactor * 1000))
+	}
+	return 0
+}
+
+// reviewType returns the type of the review of a card in a state.
+func (s cardState) reviewType() ReviewType {
+	switch s.kind {
+	case cardStateReview:
+		return ReviewTypeReview
+	case cardStateRelearning:
+		return ReviewTypeRelearn
+	}
+	return ReviewTypeLearn
+}
+
+// learningSteps are learning or relearning steps, in minutes.
+type learningSteps []float32
+
+// secsAt returns the delay of a step in seconds.
+func (s learningSteps) secsAt(i int) (int64, bool) {
+	if i < 0 || i >= len(s) {
+		return 0, false
+	}
+	return int64(math.Round(float64(s[i]) * 60)), true
+}
+
+// index returns the index of the current step of a card with remaining
+// steps left.
+func (s learningSteps) index(remaining int64) int {
+	n := len(s)
+	return min(max(n-int(remaining%1000), 0), max(n-1, 0))
+}
+
+// currentDelay returns the delay of the current step.
+func (s learningSteps) currentDelay(remaining int64) int64 {
+	secs, _ := s.secsAt(s.index(remaining))
+	return secs
+}
+
+// againDelay returns the delay after answering Again, from the first step.
+func (s learningSteps) againDelay() (int64, bool) {
+	return s.secsAt(0)
+}
+
+// hardDelay returns the delay after answering Hard, which repeats the current
+// step. On the first step, it is the average of the first two steps, or one
+// and a half times the only step, capped at one more day.
+func (s learningSteps) hardDelay(remaining int64) (int64, bool) {
+	if len(s) == 0 {
+		return 0, false
+	}
+	i := s.index(remaining)
+	current, _ := s.secsAt(i)
+	if i > 0 {
+		return current, true
+	}
+	if next, ok := s.secsAt(1); ok {
+		return (current + next) / 2, true
+	}
+	return min(current*3/2, current+86400), true
+}
+
+// goodDelay returns the delay after answering Good, which moves to the next
+// step, if there is one.
+func (s learningSteps) goodDelay(remaining int64) (int64, bool) {
+	return s.secsAt(s.index(remaining) + 1)
+}
+
+// remainingForGood returns the number of steps left after answering Good.
+func (s learningSteps) remainingForGood(remaining int64) int64 {
+	return int64(max(len(s)-s.index(remaining)-1, 0))
+}
+
+// remainingForFailed returns the number of steps left after answering Again.
+func (s learningSteps) remainingForFailed() int64 {
+	return int64(len(s))
+}
@@ -0,0 +1,348 @@
+package anki
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lftk/anki/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestAnswerCard tests answering cards with the SM-2 algorithm.
+func TestAnswerCard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	col, err := CreateInMemory(&CreateOptions{
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	config := DefaultDeckConfig()
+	config.LearnSteps = []float32{1, 10}
+	config.RelearnSteps = []float32{10}
+	config.LapseMultiplier = 0.5
+	config.LeechThreshold = 2
+	config.LeechAction = pb.DeckConfig_LEECH_ACTION_SUSPEND
+	if err = col.AddDeckConfig(&DeckConfig{ID: 1, Name: "Default", Config: config}); err != nil {
+		t.Fatal(err)
+	}
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	today := col.props.daysElapsed(now)
+
+	review := func(card *Card) {
+		card.Type = CardTypeReview
+		card.Queue = CardQueueReview
+		card.Interval = 10
+		card.Due = today
+		card.Factor = 2500
+	}
+	type want struct {
+		typ      CardType
+		queue    CardQueue
+		left     int64
+		minDue   int64
+		maxDue   int64
+		interval int64
+		factor   int64
+		lapses   int64
+		revlog   int64
+	}
+	tests := []struct {
+		name    string
+		setup   func(*Card)
+		ratings []ReviewEase
+		want    want
+	}{
+		{
+			name:    "new again",
+			ratings: []ReviewEase{ReviewEaseAgain},
+			want:    want{typ: CardTypeLearn, queue: CardQueueLearn, left: 2, minDue: now.Unix() + 60, maxDue: now.Unix() + 75, revlog: -60},
+		},
+		{
+			name:    "new hard",
+			ratings: []ReviewEase{ReviewEaseHard},
+			want:    want{typ: CardTypeLearn, queue: CardQueueLearn, left: 2, minDue: now.Unix() + 330, maxDue: now.Unix() + 412, revlog: -330},
+		},
+		{
+			name:    "new good",
+			ratings: []ReviewEase{ReviewEaseGood},
+			want:    want{typ: CardTypeLearn, queue: CardQueueLearn, left: 1, minDue: now.Unix() + 600, maxDue: now.Unix() + 750, revlog: -600},
+		},
+		{
+			name:    "graduate",
+			ratings: []ReviewEase{ReviewEaseGood, ReviewEaseGood},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 1, maxDue: today + 1, interval: 1, factor: 2500, revlog: 1},
+		},
+		{
+			name:    "new easy",
+			ratings: []ReviewEase{ReviewEaseEasy},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 3, maxDue: today + 5, interval: -1, factor: 2500, revlog: -1},
+		},
+		{
+			name:    "review hard",
+			setup:   review,
+			ratings: []ReviewEase{ReviewEaseHard},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 11, maxDue: today + 14, interval: -1, factor: 2350, revlog: -1},
+		},
+		{
+			name:    "review good",
+			setup:   review,
+			ratings: []ReviewEase{ReviewEaseGood},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 22, maxDue: today + 28, interval: -1, factor: 2500, revlog: -1},
+		},
+		{
+			name:    "review easy",
+			setup:   review,
+			ratings: []ReviewEase{ReviewEaseEasy},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 29, maxDue: today + 36, interval: -1, factor: 2650, revlog: -1},
+		},
+		{
+			name:    "maximum interval",
+			setup:   func(card *Card) { review(card); card.Interval = 36000 },
+			ratings: []ReviewEase{ReviewEaseGood},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 36001, maxDue: today + 36500, interval: -1, factor: 2500, revlog: -1},
+		},
+		{
+			name:    "lapse",
+			setup:   review,
+			ratings: []ReviewEase{ReviewEaseAgain},
+			want:    want{typ: CardTypeRelearn, queue: CardQueueLearn, minDue: now.Unix() + 600, maxDue: now.Unix() + 750, left: 1, interval: 5, factor: 2300, lapses: 1, revlog: -600},
+		},
+		{
+			name:    "relearn",
+			setup:   review,
+			ratings: []ReviewEase{ReviewEaseAgain, ReviewEaseGood},
+			want:    want{typ: CardTypeReview, queue: CardQueueReview, minDue: today + 5, maxDue: today + 5, interval: 5, factor: 2300, lapses: 1, revlog: 5},
+		},
+		{
+			name:    "leech",
+			setup:   func(card *Card) { review(card); card.Lapses = 1 },
+			ratings: []ReviewEase{ReviewEaseAgain},
+			want:    want{typ: CardTypeRelearn, queue: CardQueueSuspended, minDue: now.Unix() + 600, maxDue: now.Unix() + 750, left: 1, interval: 5, factor: 2300, lapses: 2, revlog: -600},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			note := &Note{NotetypeID: notetype.ID, Fields: []string{tt.name, ""}}
+			if err := col.AddNote(1, note); err != nil {
+				t.Fatal(err)
+			}
+			var card *Card
+			for c, err := range col.ListCards(&ListCardsOptions{NoteID: &note.ID}) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				card = c
+			}
+			if tt.setup != nil {
+				tt.setup(card)
+				if err := updateCard(col.db, card); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, rating := range tt.ratings {
+				if err := col.AnswerCard(card.ID, rating, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			card, err := col.GetCard(card.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := want{
+				typ:      card.Type,
+				queue:    card.Queue,
+				left:     card.Left,
+				interval: card.Interval,
+				factor:   card.Factor,
+				lapses:   card.Lapses,
+			}
+			if card.Due < tt.want.minDue || card.Due > tt.want.maxDue {
+				t.Errorf("due = %d, want between %d and %d", card.Due, tt.want.minDue, tt.want.maxDue)
+			}
+			var last *ReviewLog
+			n := 0
+			for log, err := range col.ListReviewLogs(&ListReviewLogsOptions{CardID: &card.ID}) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				last = log
+				n++
+			}
+			if n != len(tt.ratings) {
+				t.Fatalf("got %d reviews, want %d", n, len(tt.ratings))
+			}
+			if last.TimeTaken != time.Minute {
+				t.Errorf("time taken = %v, want %v", last.TimeTaken, time.Minute)
+			}
+			got.minDue, got.maxDue, got.revlog = tt.want.minDue, tt.want.maxDue, last.Interval
+			if tt.want.interval == -1 {
+				// The interval is fuzzed, and must match the due date.
+				tt.want.interval = card.Due - today
+				tt.want.revlog = card.Due - today
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+
+			note, err = col.GetNote(note.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leech := slices.Contains(note.Tags, leechTag); leech != (tt.want.queue == CardQueueSuspended) {
+				t.Errorf("note tags = %v", note.Tags)
+			}
+		})
+	}
+
+	if err = col.AnswerCard(1, ReviewEaseManual, 0); !errors.Is(err, ErrInvalidRating) {
+		t.Errorf("AnswerCard() error = %v, want ErrInvalidRating", err)
+	}
+}
+
+// TestLeechThresholdMet tests the lapses at which cards become leeches, which
+// are the threshold and every half threshold after, rounded up.
+func TestLeechThresholdMet(t *testing.T) {
+	tests := []struct {
+		threshold uint32
+		want      []int64
+	}{
+		{threshold: 8, want: []int64{8, 12, 16}},
+		{threshold: 7, want: []int64{7, 11, 15}},
+		{threshold: 1, want: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+		{threshold: 0},
+	}
+	for _, tt := range tests {
+		ctx := &schedulingContext{config: &pb.DeckConfig{LeechThreshold: tt.threshold}}
+		var got []int64
+		for lapses := int64(1); lapses <= 16; lapses++ {
+			if ctx.leechThresholdMet(lapses) {
+				got = append(got, lapses)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("threshold %d: leeches at %v lapses, want %v", tt.threshold, got, tt.want)
+		}
+	}
+}
+
+// TestAnswerCardDeckStats tests counting answered cards in the studied deck
+// and its parents.
+func TestAnswerCardDeckStats(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	col, err := CreateInMemory(&CreateOptions{
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	home := &Deck{Name: JoinDeckName("Home", "Child")}
+	if err = col.AddDeck(home); err != nil {
+		t.Fatal(err)
+	}
+	filtered := &Deck{
+		Name: "Filtered",
+		Kind: &pb.DeckKind{Kind: &pb.DeckKind_Filtered{Filtered: &pb.DeckFiltered{Reschedule: true}}},
+	}
+	if err = col.AddDeck(filtered); err != nil {
+		t.Fatal(err)
+	}
+	today := col.props.daysElapsed(now)
+
+	addCard := func(setup func(*Card)) *Card {
+		t.Helper()
+		note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+		if err := col.AddNote(home.ID, note); err != nil {
+			t.Fatal(err)
+		}
+		for card, err := range col.ListCards(&ListCardsOptions{NoteID: &note.ID}) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			setup(card)
+			if err = updateCard(col.db, card); err != nil {
+				t.Fatal(err)
+			}
+			return card
+		}
+		t.Fatal("no card generated")
+		return nil
+	}
+	// The home deck was last studied yesterday, so its counts start over.
+	home.Common.LastDayStudied = uint32(today - 1)
+	home.Common.NewStudied, home.Common.ReviewStudied, home.Common.LearningStudied = 3, 4, 5
+	b, err := proto.Marshal(home.Common)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sqlExecute(col.db, updateDeckCommonQuery, timeUnix(now), -1, b, home.ID); err != nil {
+		t.Fatal(err)
+	}
+	// A new card studied in its home deck, and a review card that graduates
+	// from the filtered deck.
+	newCard := addCard(func(*Card) {})
+	reviewCard := addCard(func(card *Card) {
+		card.Type, card.Queue = CardTypeReview, CardQueueReview
+		card.Interval, card.Factor = 10, 2500
+		card.DeckID, card.OriginalDeckID = filtered.ID, home.ID
+		card.Due, card.OriginalDue = today, today
+	})
+	for _, card := range []*Card{newCard, reviewCard} {
+		if err = col.AnswerCard(card.ID, ReviewEaseGood, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if card, err := col.GetCard(reviewCard.ID); err != nil || card.DeckID != home.ID {
+		t.Fatalf("graduated card = %+v, %v, want it back in its home deck", card, err)
+	}
+
+	parent, err := sqlGet(col.db, scanDeck, getDeckQuery+" WHERE name = ?", DeckName("Home"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		deck    int64
+		new     int32
+		reviews int32
+	}{
+		{deck: home.ID, new: 1},
+		{deck: parent.ID, new: 1},
+		{deck: filtered.ID, reviews: 1},
+	}
+	for _, tt := range tests {
+		deck, err := col.GetDeck(tt.deck)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := deck.Common
+		if int64(c.LastDayStudied) != today || c.NewStudied != tt.new || c.ReviewStudied != tt.reviews || c.LearningStudied != 0 || c.MillisecondsStudied == 0 {
+			t.Errorf("%s: studied %d new, %d reviews and %d learning on day %d in %dms, want %d, %d and 0 on day %d",
+				deck.Name.HumanString(), c.NewStudied, c.ReviewStudied, c.LearningStudied, c.LastDayStudied, c.MillisecondsStudied,
+				tt.new, tt.reviews, today)
+		}
+	}
+}
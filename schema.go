@@ -0,0 +1,386 @@
+package anki
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// schemaVersion is the schema version of collections created by this package.
+	schemaVersion = 18
+	// legacySchemaVersion is the schema version understood by older clients.
+	legacySchemaVersion = 11
+)
+
+// ErrUnsupportedSchema is returned when a collection uses a schema version
+// that cannot be opened.
+var ErrUnsupportedSchema = errors.New("unsupported collection schema")
+
+// SchemaError records the schema version of a collection that cannot be
+// opened.
+type SchemaError struct {
+	Version int
+	Err     error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("collection schema version %d: %v", e.Version, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// upgradeSchema upgrades a database to the current schema if required.
+// Collections from schema 11 to 13 keep their notetypes, decks and deck
+// configs as JSON in the col table, as older clients export them and older
+// profiles store them on disk, and are upgraded the same way, as in Anki.
+// Schemas 14 to 17 move them into tables one step at a time and are not
+// supported.
+func upgradeSchema(db *sql.DB) error {
+	ver, err := sqlGet(db, scanValue[int], getColVerQuery)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ver == schemaVersion:
+		return nil
+	case canUpgradeSchema(ver):
+		return upgradeFromSchema11(db)
+	default:
+		return &SchemaError{Version: ver, Err: ErrUnsupportedSchema}
+	}
+}
+
+// canUpgradeSchema reports whether upgradeSchema upgrades a schema version.
+func canUpgradeSchema(ver int) bool {
+	return ver >= legacySchemaVersion && ver < 14
+}
+
+// checkSchema checks that a database uses the current schema, for databases
+// that cannot be upgraded.
+func checkSchema(db *sql.DB) error {
+	ver, err := sqlGet(db, scanValue[int], getColVerQuery)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ver == schemaVersion:
+		return nil
+	case canUpgradeSchema(ver):
+		// The collection would have to be upgraded to be read.
+		return &SchemaError{Version: ver, Err: ErrReadOnly}
+	default:
+		return &SchemaError{Version: ver, Err: ErrUnsupportedSchema}
+	}
+}
+
+// upgradeDatabase upgrades the database at path to the current schema if required.
+func upgradeDatabase(path string) error {
+	db, err := sqlite3Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close() //nolint:errcheck
+
+	if err = upgradeSchema(db); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// upgradeFromSchema11 converts a legacy database to the current schema,
+// moving the JSON stored in the col table into the proto-backed tables.
+func upgradeFromSchema11(db *sql.DB) error {
+	return sqlTransact(context.Background(), db, func(tx *sql.Tx) error {
+		var models, decks, dconf, conf, tags string
+		row := tx.QueryRow(getLegacyColQuery)
+		if err := row.Scan(&models, &decks, &dconf, &conf, &tags); err != nil {
+			return err
+		}
+
+		if err := sqlExecute(tx, upgradeSchema11Query); err != nil {
+			return err
+		}
+
+		for _, fn := range []func() error{
+			func() error { return upgradeNotetypes(tx, models) },
+			func() error { return upgradeDecks(tx, decks) },
+			func() error { return upgradeDeckConfigs(tx, dconf) },
+			func() error { return upgradeConfigs(tx, conf) },
+			func() error { return upgradeTags(tx, tags) },
+		} {
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+
+		return sqlExecute(tx, upgradeColQuery, schemaVersion)
+	})
+}
+
+// unmarshalLegacyObject unmarshals a legacy JSON object, treating an empty
+// string as an empty object.
+func unmarshalLegacyObject[T any](s string) (map[string]T, error) {
+	var m map[string]T
+	if s == "" {
+		return m, nil
+	}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// upgradeNotetypes inserts the notetypes held in the legacy models JSON.
+func upgradeNotetypes(tx *sql.Tx, models string) error {
+	m, err := unmarshalLegacyObject[json.RawMessage](models)
+	if err != nil {
+		return fmt.Errorf("invalid legacy notetypes: %w", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		b := m[key]
+		var s notetypeSchema11
+		other, err := unmarshalSchema11(b, &s)
+		if err != nil {
+			return fmt.Errorf("invalid legacy notetype: %w", err)
+		}
+		nt := notetypeFromSchema11(&s, other)
+		config, err := proto.Marshal(nt.Config)
+		if err != nil {
+			return err
+		}
+		args := []any{
+			nt.ID,
+			nt.Name,
+			timeUnix(nt.Modified),
+			nt.USN,
+			config,
+		}
+		if err = sqlExecute(tx, addNotetypeQuery, args...); err != nil {
+			return err
+		}
+		for _, f := range nt.Fields {
+			if err = addField(tx, nt.ID, f); err != nil {
+				return err
+			}
+		}
+		for _, t := range nt.Templates {
+			if err = addTemplate(tx, nt.ID, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// upgradeDecks inserts the decks held in the legacy decks JSON.
+func upgradeDecks(tx *sql.Tx, decks string) error {
+	m, err := unmarshalLegacyObject[json.RawMessage](decks)
+	if err != nil {
+		return fmt.Errorf("invalid legacy decks: %w", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		b := m[key]
+		var s deckSchema11
+		other, err := unmarshalSchema11(b, &s)
+		if err != nil {
+			return fmt.Errorf("invalid legacy deck: %w", err)
+		}
+		if err = addDeck(tx, deckFromSchema11(&s, other), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeDeckConfigs inserts the deck configs held in the legacy dconf JSON.
+func upgradeDeckConfigs(tx *sql.Tx, dconf string) error {
+	m, err := unmarshalLegacyObject[json.RawMessage](dconf)
+	if err != nil {
+		return fmt.Errorf("invalid legacy deck configs: %w", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		b := m[key]
+		s := newDeckConfigSchema11()
+		other, err := unmarshalSchema11(b, s)
+		if err != nil {
+			return fmt.Errorf("invalid legacy deck config: %w", err)
+		}
+		if err = addDeckConfig(tx, deckConfigFromSchema11(s, other), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeConfigs inserts the config entries held in the legacy conf JSON.
+func upgradeConfigs(tx *sql.Tx, conf string) error {
+	m, err := unmarshalLegacyObject[json.RawMessage](conf)
+	if err != nil {
+		return fmt.Errorf("invalid legacy config: %w", err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		value := m[key]
+		config := &Config{
+			Key:      key,
+			Value:    value,
+			USN:      0,
+			Modified: timeZero(),
+		}
+		if err = setConfig(tx, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeTags inserts the tags held in the legacy tags JSON.
+func upgradeTags(tx *sql.Tx, tags string) error {
+	m, err := unmarshalLegacyObject[int64](tags)
+	if err != nil {
+		return fmt.Errorf("invalid legacy tags: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		usn := m[name]
+		if err = sqlExecute(tx, setTagQuery, name, usn, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downgradeToSchema11 converts a database to the legacy schema, moving
+// notetypes, decks, deck configs, tags and config entries into JSON stored in
+// the col table, and dropping the tables that held them.
+func downgradeToSchema11(db *sql.DB) error {
+	return sqlTransact(context.Background(), db, func(tx *sql.Tx) error {
+		models, err := legacyNotetypes(tx)
+		if err != nil {
+			return err
+		}
+		decks, err := legacyDecks(tx)
+		if err != nil {
+			return err
+		}
+		dconf, err := legacyDeckConfigs(tx)
+		if err != nil {
+			return err
+		}
+		conf, err := legacyConfigs(tx)
+		if err != nil {
+			return err
+		}
+		tags, err := legacyTags(tx)
+		if err != nil {
+			return err
+		}
+
+		args := []any{
+			legacySchemaVersion,
+			string(models),
+			string(decks),
+			string(dconf),
+			string(conf),
+			string(tags),
+		}
+		if err = sqlExecute(tx, downgradeColQuery, args...); err != nil {
+			return err
+		}
+		if err = sqlExecute(tx, dropNoteIndexQuery); err != nil {
+			return err
+		}
+
+		for _, table := range []string{
+			"notetypes", "fields", "templates", "decks", "deck_config", "config", "tags",
+		} {
+			if err = sqlExecute(tx, "DROP TABLE "+table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// legacyNotetypes returns all notetypes as a legacy JSON object keyed by ID.
+func legacyNotetypes(q sqlQueryer) ([]byte, error) {
+	models := make(map[string]json.RawMessage)
+	for nt, err := range sqlSelectSeq(q, scanNotetype, getNotetypeQuery) {
+		if err != nil {
+			return nil, err
+		}
+		b, err := marshalSchema11(notetypeToSchema11(nt), nt.Config.GetOther())
+		if err != nil {
+			return nil, err
+		}
+		models[strconv.FormatInt(nt.ID, 10)] = b
+	}
+	return json.Marshal(models)
+}
+
+// legacyDecks returns all decks as a legacy JSON object keyed by ID.
+func legacyDecks(q sqlQueryer) ([]byte, error) {
+	decks := make(map[string]json.RawMessage)
+	for deck, err := range sqlSelectSeq(q, scanDeck, getDeckQuery) {
+		if err != nil {
+			return nil, err
+		}
+		b, err := marshalSchema11(deckToSchema11(deck), deck.Common.GetOther())
+		if err != nil {
+			return nil, err
+		}
+		decks[strconv.FormatInt(deck.ID, 10)] = b
+	}
+	return json.Marshal(decks)
+}
+
+// legacyDeckConfigs returns all deck configs as a legacy JSON object keyed by ID.
+func legacyDeckConfigs(q sqlQueryer) ([]byte, error) {
+	dconf := make(map[string]json.RawMessage)
+	for config, err := range sqlSelectSeq(q, scanDeckConfig, getDeckConfigQuery) {
+		if err != nil {
+			return nil, err
+		}
+		b, err := marshalSchema11(deckConfigToSchema11(config), config.Config.GetOther())
+		if err != nil {
+			return nil, err
+		}
+		dconf[strconv.FormatInt(config.ID, 10)] = b
+	}
+	return json.Marshal(dconf)
+}
+
+// legacyConfigs returns all config entries as a legacy JSON object.
+func legacyConfigs(q sqlQueryer) ([]byte, error) {
+	conf := make(map[string]json.RawMessage)
+	for config, err := range sqlSelectSeq(q, scanConfig, getConfigQuery) {
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(config.Value) {
+			continue
+		}
+		conf[config.Key] = config.Value
+	}
+	return json.Marshal(conf)
+}
+
+// legacyTags returns all tags as a legacy JSON object mapping names to USNs.
+func legacyTags(q sqlQueryer) ([]byte, error) {
+	tags := make(map[string]int64)
+	for tag, err := range sqlSelectSeq(q, scanTag, getTagQuery) {
+		if err != nil {
+			return nil, err
+		}
+		tags[tag.Name] = tag.USN
+	}
+	return json.Marshal(tags)
+}
@@ -0,0 +1,778 @@
+package anki
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lftk/anki/pb"
+)
+
+// This file contains the JSON representations used by schema 11 collections,
+// where notetypes, decks, deck configs, tags and config entries are stored as
+// JSON in the col table instead of in their own tables.
+
+// notetypeSchema11 is the legacy JSON representation of a notetype.
+type notetypeSchema11 struct {
+	ID                int64                     `json:"id"`
+	Name              string                    `json:"name"`
+	Type              int32                     `json:"type"`
+	Mod               int64                     `json:"mod"`
+	USN               int64                     `json:"usn"`
+	SortField         uint32                    `json:"sortf"`
+	DeckID            *int64                    `json:"did"`
+	Templates         []*templateSchema11       `json:"tmpls"`
+	Fields            []*fieldSchema11          `json:"flds"`
+	CSS               string                    `json:"css"`
+	LatexPre          string                    `json:"latexPre"`
+	LatexPost         string                    `json:"latexPost"`
+	LatexSVG          bool                      `json:"latexsvg"`
+	Req               []cardRequirementSchema11 `json:"req"`
+	OriginalStockKind int32                     `json:"originalStockKind,omitempty"`
+	OriginalID        *int64                    `json:"originalId,omitempty"`
+}
+
+// fieldSchema11 is the legacy JSON representation of a notetype field.
+type fieldSchema11 struct {
+	Name              string  `json:"name"`
+	Ord               int     `json:"ord"`
+	Sticky            bool    `json:"sticky"`
+	RTL               bool    `json:"rtl"`
+	Font              string  `json:"font"`
+	Size              uint32  `json:"size"`
+	Description       string  `json:"description"`
+	PlainText         bool    `json:"plainText"`
+	Collapsed         bool    `json:"collapsed"`
+	ExcludeFromSearch bool    `json:"excludeFromSearch"`
+	ID                *int64  `json:"id,omitempty"`
+	Tag               *uint32 `json:"tag,omitempty"`
+	PreventDeletion   bool    `json:"preventDeletion"`
+}
+
+// templateSchema11 is the legacy JSON representation of a card template.
+type templateSchema11 struct {
+	Name   string `json:"name"`
+	Ord    int    `json:"ord"`
+	QFmt   string `json:"qfmt"`
+	AFmt   string `json:"afmt"`
+	BQFmt  string `json:"bqfmt"`
+	BAFmt  string `json:"bafmt"`
+	DeckID *int64 `json:"did"`
+	BFont  string `json:"bfont"`
+	BSize  uint32 `json:"bsize"`
+	ID     *int64 `json:"id,omitempty"`
+}
+
+// cardRequirementSchema11 is the legacy representation of a card requirement,
+// serialized as a [ord, kind, [field ords]] tuple.
+type cardRequirementSchema11 struct {
+	Ord    uint32
+	Kind   string
+	Fields []uint32
+}
+
+func (r cardRequirementSchema11) MarshalJSON() ([]byte, error) {
+	fields := r.Fields
+	if fields == nil {
+		fields = []uint32{}
+	}
+	return json.Marshal([]any{r.Ord, r.Kind, fields})
+}
+
+func (r *cardRequirementSchema11) UnmarshalJSON(b []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(b, &tuple); err != nil {
+		return err
+	}
+	if len(tuple) != 3 {
+		return fmt.Errorf("invalid card requirement: %s", b)
+	}
+	for i, v := range []any{&r.Ord, &r.Kind, &r.Fields} {
+		if err := json.Unmarshal(tuple[i], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deckSchema11 is the legacy JSON representation of a deck. Normal and
+// filtered decks share a single object, distinguished by the dyn key.
+type deckSchema11 struct {
+	ID               int64    `json:"id"`
+	Mod              int64    `json:"mod"`
+	Name             string   `json:"name"`
+	USN              int64    `json:"usn"`
+	LearnToday       [2]int64 `json:"lrnToday"`
+	ReviewToday      [2]int64 `json:"revToday"`
+	NewToday         [2]int64 `json:"newToday"`
+	TimeToday        [2]int64 `json:"timeToday"`
+	Collapsed        bool     `json:"collapsed"`
+	BrowserCollapsed bool     `json:"browserCollapsed"`
+	Desc             string   `json:"desc"`
+	Markdown         bool     `json:"md,omitempty"`
+	Dyn              int      `json:"dyn"`
+
+	// normal decks
+	Conf             int64             `json:"conf,omitempty"`
+	ExtendNew        uint32            `json:"extendNew"`
+	ExtendRev        uint32            `json:"extendRev"`
+	ReviewLimit      *uint32           `json:"reviewLimit,omitempty"`
+	NewLimit         *uint32           `json:"newLimit,omitempty"`
+	ReviewLimitToday *dayLimitSchema11 `json:"reviewLimitToday,omitempty"`
+	NewLimitToday    *dayLimitSchema11 `json:"newLimitToday,omitempty"`
+
+	// filtered decks
+	Resched          bool                 `json:"resched,omitempty"`
+	Terms            []searchTermSchema11 `json:"terms,omitempty"`
+	Separate         bool                 `json:"separate,omitempty"`
+	Delays           []float32            `json:"delays,omitempty"`
+	PreviewDelay     uint32               `json:"previewDelay,omitempty"`
+	PreviewAgainSecs uint32               `json:"previewAgainSecs,omitempty"`
+	PreviewHardSecs  uint32               `json:"previewHardSecs,omitempty"`
+	PreviewGoodSecs  uint32               `json:"previewGoodSecs,omitempty"`
+}
+
+// dayLimitSchema11 is the legacy representation of a per-day deck limit.
+type dayLimitSchema11 struct {
+	Limit uint32 `json:"limit"`
+	Today uint32 `json:"today"`
+}
+
+// searchTermSchema11 is the legacy representation of a filtered deck search
+// term, serialized as a [search, limit, order] tuple.
+type searchTermSchema11 struct {
+	Search string
+	Limit  uint32
+	Order  int32
+}
+
+func (t searchTermSchema11) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{t.Search, t.Limit, t.Order})
+}
+
+func (t *searchTermSchema11) UnmarshalJSON(b []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(b, &tuple); err != nil {
+		return err
+	}
+	if len(tuple) != 3 {
+		return fmt.Errorf("invalid search term: %s", b)
+	}
+	for i, v := range []any{&t.Search, &t.Limit, &t.Order} {
+		if err := json.Unmarshal(tuple[i], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deckConfigSchema11 is the legacy JSON representation of a deck configuration.
+type deckConfigSchema11 struct {
+	ID                      int64                `json:"id"`
+	Mod                     int64                `json:"mod"`
+	Name                    string               `json:"name"`
+	USN                     int64                `json:"usn"`
+	MaxTaken                uint32               `json:"maxTaken"`
+	Autoplay                bool                 `json:"autoplay"`
+	Timer                   numericBool          `json:"timer"`
+	ReplayQ                 bool                 `json:"replayq"`
+	New                     newConfigSchema11    `json:"new"`
+	Rev                     reviewConfigSchema11 `json:"rev"`
+	Lapse                   lapseConfigSchema11  `json:"lapse"`
+	Dyn                     bool                 `json:"dyn"`
+	NewMix                  int32                `json:"newMix"`
+	NewPerDayMinimum        uint32               `json:"newPerDayMinimum"`
+	InterdayLearningMix     int32                `json:"interdayLearningMix"`
+	ReviewOrder             int32                `json:"reviewOrder"`
+	NewSortOrder            int32                `json:"newSortOrder"`
+	NewGatherPriority       int32                `json:"newGatherPriority"`
+	BuryInterdayLearning    bool                 `json:"buryInterdayLearning"`
+	FsrsWeights             []float32            `json:"fsrsWeights"`
+	FsrsParams5             []float32            `json:"fsrsParams5"`
+	FsrsParams6             []float32            `json:"fsrsParams6"`
+	DesiredRetention        float32              `json:"desiredRetention"`
+	IgnoreRevlogsBeforeDate string               `json:"ignoreRevlogsBeforeDate"`
+	EasyDaysPercentages     []float32            `json:"easyDaysPercentages"`
+	StopTimerOnAnswer       bool                 `json:"stopTimerOnAnswer"`
+	SecondsToShowQuestion   float32              `json:"secondsToShowQuestion"`
+	SecondsToShowAnswer     float32              `json:"secondsToShowAnswer"`
+	AnswerAction            int32                `json:"answerAction"`
+	QuestionAction          int32                `json:"questionAction"`
+	WaitForAudio            bool                 `json:"waitForAudio"`
+	SM2Retention            float32              `json:"sm2Retention"`
+	WeightSearch            string               `json:"weightSearch"`
+}
+
+// newDeckConfigSchema11 returns a legacy deck configuration holding the
+// values Anki assumes for keys that older clients did not write.
+func newDeckConfigSchema11() *deckConfigSchema11 {
+	return &deckConfigSchema11{
+		Rev:                 reviewConfigSchema11{HardFactor: 1.2},
+		DesiredRetention:    0.9,
+		SM2Retention:        0.9,
+		EasyDaysPercentages: []float32{1, 1, 1, 1, 1, 1, 1},
+	}
+}
+
+// newConfigSchema11 holds the legacy settings for new cards.
+type newConfigSchema11 struct {
+	Bury          bool      `json:"bury"`
+	Delays        []float32 `json:"delays"`
+	InitialFactor uint32    `json:"initialFactor"`
+	Ints          [3]uint32 `json:"ints"`
+	Order         int32     `json:"order"`
+	PerDay        uint32    `json:"perDay"`
+}
+
+// reviewConfigSchema11 holds the legacy settings for review cards.
+type reviewConfigSchema11 struct {
+	Bury       bool    `json:"bury"`
+	Ease4      float32 `json:"ease4"`
+	IvlFct     float32 `json:"ivlFct"`
+	MaxIvl     uint32  `json:"maxIvl"`
+	PerDay     uint32  `json:"perDay"`
+	HardFactor float32 `json:"hardFactor"`
+}
+
+// lapseConfigSchema11 holds the legacy settings for lapsed cards.
+type lapseConfigSchema11 struct {
+	Delays      []float32 `json:"delays"`
+	LeechAction int32     `json:"leechAction"`
+	LeechFails  uint32    `json:"leechFails"`
+	MinInt      uint32    `json:"minInt"`
+	Mult        float32   `json:"mult"`
+}
+
+// Legacy new card orders. Note that these are the reverse of
+// pb.DeckConfig_NewCardInsertOrder.
+const (
+	newCardOrderSchema11Random = 0
+	newCardOrderSchema11Due    = 1
+)
+
+// notetypeToSchema11 converts a notetype to its legacy JSON representation.
+func notetypeToSchema11(nt *Notetype) *notetypeSchema11 {
+	config := nt.Config
+	if config == nil {
+		config = &pb.NotetypeConfig{}
+	}
+	s := &notetypeSchema11{
+		ID:                nt.ID,
+		Name:              nt.Name,
+		Type:              int32(config.Kind),
+		Mod:               timeUnix(nt.Modified),
+		USN:               nt.USN,
+		SortField:         config.SortFieldIdx,
+		CSS:               config.Css,
+		LatexPre:          config.LatexPre,
+		LatexPost:         config.LatexPost,
+		LatexSVG:          config.LatexSvg,
+		OriginalStockKind: int32(config.OriginalStockKind),
+		OriginalID:        config.OriginalId,
+	}
+	if id := config.TargetDeckIdUnused; id != 0 {
+		s.DeckID = &id
+	}
+	for _, f := range nt.Fields {
+		c := f.Config
+		if c == nil {
+			c = &pb.FieldConfig{}
+		}
+		s.Fields = append(s.Fields, &fieldSchema11{
+			Name:              f.Name,
+			Ord:               f.Ordinal,
+			Sticky:            c.Sticky,
+			RTL:               c.Rtl,
+			Font:              c.FontName,
+			Size:              c.FontSize,
+			Description:       c.Description,
+			PlainText:         c.PlainText,
+			Collapsed:         c.Collapsed,
+			ExcludeFromSearch: c.ExcludeFromSearch,
+			ID:                c.Id,
+			Tag:               c.Tag,
+			PreventDeletion:   c.PreventDeletion,
+		})
+	}
+	for _, t := range nt.Templates {
+		c := t.Config
+		if c == nil {
+			c = &pb.TemplateConfig{}
+		}
+		tmpl := &templateSchema11{
+			Name:  t.Name,
+			Ord:   t.Ordinal,
+			QFmt:  c.QFormat,
+			AFmt:  c.AFormat,
+			BQFmt: c.QFormatBrowser,
+			BAFmt: c.AFormatBrowser,
+			BFont: c.BrowserFontName,
+			BSize: c.BrowserFontSize,
+			ID:    c.Id,
+		}
+		if id := c.TargetDeckId; id != 0 {
+			tmpl.DeckID = &id
+		}
+		s.Templates = append(s.Templates, tmpl)
+	}
+	for _, req := range config.Reqs {
+		kind := "none"
+		switch req.Kind {
+		case pb.NotetypeConfig_CardRequirement_KIND_ANY:
+			kind = "any"
+		case pb.NotetypeConfig_CardRequirement_KIND_ALL:
+			kind = "all"
+		}
+		s.Req = append(s.Req, cardRequirementSchema11{
+			Ord:    req.CardOrd,
+			Kind:   kind,
+			Fields: req.FieldOrds,
+		})
+	}
+	if s.Req == nil {
+		s.Req = []cardRequirementSchema11{}
+	}
+	return s
+}
+
+// deckToSchema11 converts a deck to its legacy JSON representation.
+func deckToSchema11(deck *Deck) *deckSchema11 {
+	common := deck.Common
+	if common == nil {
+		common = DefaultDeckCommon()
+	}
+	day := int64(common.LastDayStudied)
+	s := &deckSchema11{
+		ID:               deck.ID,
+		Mod:              timeUnix(deck.Modified),
+		Name:             deck.Name.HumanString(),
+		USN:              deck.USN,
+		LearnToday:       [2]int64{day, int64(common.LearningStudied)},
+		ReviewToday:      [2]int64{day, int64(common.ReviewStudied)},
+		NewToday:         [2]int64{day, int64(common.NewStudied)},
+		TimeToday:        [2]int64{day, int64(common.MillisecondsStudied)},
+		Collapsed:        common.StudyCollapsed,
+		BrowserCollapsed: common.BrowserCollapsed,
+	}
+	switch kind := deck.Kind.GetKind().(type) {
+	case *pb.DeckKind_Filtered:
+		f := kind.Filtered
+		s.Dyn = 1
+		s.Resched = f.Reschedule
+		s.Separate = true
+		s.Delays = f.Delays
+		s.PreviewDelay = f.PreviewDelay
+		s.PreviewAgainSecs = f.PreviewAgainSecs
+		s.PreviewHardSecs = f.PreviewHardSecs
+		s.PreviewGoodSecs = f.PreviewGoodSecs
+		for _, term := range f.SearchTerms {
+			s.Terms = append(s.Terms, searchTermSchema11{
+				Search: term.Search,
+				Limit:  term.Limit,
+				Order:  int32(term.Order),
+			})
+		}
+	default:
+		n := deck.Kind.GetNormal()
+		if n == nil {
+			n = &pb.DeckNormal{ConfigId: 1}
+		}
+		s.Conf = n.ConfigId
+		s.ExtendNew = n.ExtendNew
+		s.ExtendRev = n.ExtendReview
+		s.Desc = n.Description
+		s.Markdown = n.MarkdownDescription
+		s.ReviewLimit = n.ReviewLimit
+		s.NewLimit = n.NewLimit
+		if l := n.ReviewLimitToday; l != nil {
+			s.ReviewLimitToday = &dayLimitSchema11{Limit: l.Limit, Today: l.Today}
+		}
+		if l := n.NewLimitToday; l != nil {
+			s.NewLimitToday = &dayLimitSchema11{Limit: l.Limit, Today: l.Today}
+		}
+	}
+	return s
+}
+
+// deckConfigToSchema11 converts a deck configuration to its legacy JSON
+// representation.
+func deckConfigToSchema11(config *DeckConfig) *deckConfigSchema11 {
+	c := config.Config
+	if c == nil {
+		c = DefaultDeckConfig()
+	}
+	order := int32(newCardOrderSchema11Due)
+	if c.NewCardInsertOrder == pb.DeckConfig_NEW_CARD_INSERT_ORDER_RANDOM {
+		order = newCardOrderSchema11Random
+	}
+	return &deckConfigSchema11{
+		ID:       config.ID,
+		Mod:      timeUnix(config.Modified),
+		Name:     config.Name,
+		USN:      int64(config.USN),
+		MaxTaken: c.CapAnswerTimeToSecs,
+		Autoplay: !c.DisableAutoplay,
+		Timer:    numericBool(c.ShowTimer),
+		ReplayQ:  !c.SkipQuestionWhenReplayingAnswer,
+		New: newConfigSchema11{
+			Bury:          c.BuryNew,
+			Delays:        nonNilFloats(c.LearnSteps),
+			InitialFactor: uint32(c.InitialEase*1000 + 0.5),
+			Ints:          [3]uint32{c.GraduatingIntervalGood, c.GraduatingIntervalEasy, 0},
+			Order:         order,
+			PerDay:        c.NewPerDay,
+		},
+		Rev: reviewConfigSchema11{
+			Bury:       c.BuryReviews,
+			Ease4:      c.EasyMultiplier,
+			IvlFct:     c.IntervalMultiplier,
+			MaxIvl:     c.MaximumReviewInterval,
+			PerDay:     c.ReviewsPerDay,
+			HardFactor: c.HardMultiplier,
+		},
+		Lapse: lapseConfigSchema11{
+			Delays:      nonNilFloats(c.RelearnSteps),
+			LeechAction: int32(c.LeechAction),
+			LeechFails:  c.LeechThreshold,
+			MinInt:      c.MinimumLapseInterval,
+			Mult:        c.LapseMultiplier,
+		},
+		NewMix:                  int32(c.NewMix),
+		NewPerDayMinimum:        c.NewPerDayMinimum,
+		InterdayLearningMix:     int32(c.InterdayLearningMix),
+		ReviewOrder:             int32(c.ReviewOrder),
+		NewSortOrder:            int32(c.NewCardSortOrder),
+		NewGatherPriority:       int32(c.NewCardGatherPriority),
+		BuryInterdayLearning:    c.BuryInterdayLearning,
+		FsrsWeights:             nonNilFloats(c.FsrsParams_4),
+		FsrsParams5:             nonNilFloats(c.FsrsParams_5),
+		FsrsParams6:             nonNilFloats(c.FsrsParams_6),
+		DesiredRetention:        c.DesiredRetention,
+		IgnoreRevlogsBeforeDate: c.IgnoreRevlogsBeforeDate,
+		EasyDaysPercentages:     nonNilFloats(c.EasyDaysPercentages),
+		StopTimerOnAnswer:       c.StopTimerOnAnswer,
+		SecondsToShowQuestion:   c.SecondsToShowQuestion,
+		SecondsToShowAnswer:     c.SecondsToShowAnswer,
+		AnswerAction:            int32(c.AnswerAction),
+		QuestionAction:          int32(c.QuestionAction),
+		WaitForAudio:            c.WaitForAudio,
+		SM2Retention:            c.HistoricalRetention,
+		WeightSearch:            c.ParamSearch,
+	}
+}
+
+// nonNilFloats returns an empty slice instead of nil, so that it is
+// serialized as an empty JSON array.
+func nonNilFloats(s []float32) []float32 {
+	if s == nil {
+		return []float32{}
+	}
+	return s
+}
+
+// marshalSchema11 marshals a legacy object, merging in any extra keys
+// preserved in other. Extra keys never override known ones.
+func marshalSchema11(v any, other []byte) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || len(other) == 0 {
+		return b, err
+	}
+	return mergeSchema11(b, other)
+}
+
+// mergeSchema11 adds the keys of the JSON object other that the JSON object b
+// lacks, merging the keys of nested objects the same way. b is returned as is
+// if either is not an object; other is opaque to us.
+func mergeSchema11(b, other []byte) ([]byte, error) {
+	var obj, extra map[string]json.RawMessage
+	if json.Unmarshal(b, &obj) != nil || json.Unmarshal(other, &extra) != nil || obj == nil {
+		return b, nil
+	}
+	for k, v := range extra {
+		known, ok := obj[k]
+		if !ok {
+			obj[k] = v
+			continue
+		}
+		merged, err := mergeSchema11(known, v)
+		if err != nil {
+			return nil, err
+		}
+		obj[k] = merged
+	}
+	return json.Marshal(obj)
+}
+
+// notetypeFromSchema11 converts a legacy notetype to a notetype.
+func notetypeFromSchema11(s *notetypeSchema11, other []byte) *Notetype {
+	nt := &Notetype{
+		ID:       s.ID,
+		Name:     s.Name,
+		Modified: time.Unix(s.Mod, 0),
+		USN:      s.USN,
+		Config: &pb.NotetypeConfig{
+			Kind:              pb.NotetypeConfig_Kind(s.Type),
+			SortFieldIdx:      s.SortField,
+			Css:               s.CSS,
+			LatexPre:          s.LatexPre,
+			LatexPost:         s.LatexPost,
+			LatexSvg:          s.LatexSVG,
+			OriginalStockKind: pb.StockNotetype_OriginalStockKind(s.OriginalStockKind),
+			OriginalId:        s.OriginalID,
+			Other:             other,
+		},
+	}
+	if s.DeckID != nil {
+		nt.Config.TargetDeckIdUnused = *s.DeckID
+	}
+	for i, f := range s.Fields {
+		nt.Fields = append(nt.Fields, &Field{
+			Ordinal: i,
+			Name:    f.Name,
+			Config: &pb.FieldConfig{
+				Sticky:            f.Sticky,
+				Rtl:               f.RTL,
+				FontName:          f.Font,
+				FontSize:          f.Size,
+				Description:       f.Description,
+				PlainText:         f.PlainText,
+				Collapsed:         f.Collapsed,
+				ExcludeFromSearch: f.ExcludeFromSearch,
+				Id:                f.ID,
+				Tag:               f.Tag,
+				PreventDeletion:   f.PreventDeletion,
+			},
+		})
+	}
+	for i, t := range s.Templates {
+		tmpl := &Template{
+			Ordinal:  i,
+			Name:     t.Name,
+			Modified: nt.Modified,
+			USN:      nt.USN,
+			Config: &pb.TemplateConfig{
+				QFormat:         t.QFmt,
+				AFormat:         t.AFmt,
+				QFormatBrowser:  t.BQFmt,
+				AFormatBrowser:  t.BAFmt,
+				BrowserFontName: t.BFont,
+				BrowserFontSize: t.BSize,
+				Id:              t.ID,
+			},
+		}
+		if t.DeckID != nil {
+			tmpl.Config.TargetDeckId = *t.DeckID
+		}
+		nt.Templates = append(nt.Templates, tmpl)
+	}
+	for _, req := range s.Req {
+		kind := pb.NotetypeConfig_CardRequirement_KIND_NONE
+		switch req.Kind {
+		case "any":
+			kind = pb.NotetypeConfig_CardRequirement_KIND_ANY
+		case "all":
+			kind = pb.NotetypeConfig_CardRequirement_KIND_ALL
+		}
+		nt.Config.Reqs = append(nt.Config.Reqs, &pb.NotetypeConfig_CardRequirement{
+			CardOrd:   req.Ord,
+			Kind:      kind,
+			FieldOrds: req.Fields,
+		})
+	}
+	return nt
+}
+
+// deckFromSchema11 converts a legacy deck to a deck.
+func deckFromSchema11(s *deckSchema11, other []byte) *Deck {
+	deck := &Deck{
+		ID:       s.ID,
+		Name:     JoinDeckName(strings.Split(s.Name, "::")...),
+		Modified: time.Unix(s.Mod, 0),
+		USN:      s.USN,
+		Common: &pb.DeckCommon{
+			StudyCollapsed:      s.Collapsed,
+			BrowserCollapsed:    s.BrowserCollapsed,
+			LastDayStudied:      uint32(s.NewToday[0]),
+			NewStudied:          int32(s.NewToday[1]),
+			ReviewStudied:       int32(s.ReviewToday[1]),
+			LearningStudied:     int32(s.LearnToday[1]),
+			MillisecondsStudied: int32(s.TimeToday[1]),
+			Other:               other,
+		},
+	}
+	if s.Dyn != 0 {
+		filtered := &pb.DeckFiltered{
+			Reschedule:       s.Resched,
+			Delays:           s.Delays,
+			PreviewDelay:     s.PreviewDelay,
+			PreviewAgainSecs: s.PreviewAgainSecs,
+			PreviewHardSecs:  s.PreviewHardSecs,
+			PreviewGoodSecs:  s.PreviewGoodSecs,
+		}
+		for _, term := range s.Terms {
+			filtered.SearchTerms = append(filtered.SearchTerms, &pb.DeckFiltered_SearchTerm{
+				Search: term.Search,
+				Limit:  term.Limit,
+				Order:  pb.DeckFiltered_SearchTerm_Order(term.Order),
+			})
+		}
+		deck.Kind = &pb.DeckKind{Kind: &pb.DeckKind_Filtered{Filtered: filtered}}
+		return deck
+	}
+
+	normal := &pb.DeckNormal{
+		ConfigId:            s.Conf,
+		ExtendNew:           s.ExtendNew,
+		ExtendReview:        s.ExtendRev,
+		Description:         s.Desc,
+		MarkdownDescription: s.Markdown,
+		ReviewLimit:         s.ReviewLimit,
+		NewLimit:            s.NewLimit,
+	}
+	if normal.ConfigId == 0 {
+		normal.ConfigId = 1
+	}
+	if l := s.ReviewLimitToday; l != nil {
+		normal.ReviewLimitToday = &pb.DeckNormal_DayLimit{Limit: l.Limit, Today: l.Today}
+	}
+	if l := s.NewLimitToday; l != nil {
+		normal.NewLimitToday = &pb.DeckNormal_DayLimit{Limit: l.Limit, Today: l.Today}
+	}
+	deck.Kind = &pb.DeckKind{Kind: &pb.DeckKind_Normal{Normal: normal}}
+	return deck
+}
+
+// deckConfigFromSchema11 converts a legacy deck configuration to a deck
+// configuration.
+func deckConfigFromSchema11(s *deckConfigSchema11, other []byte) *DeckConfig {
+	order := pb.DeckConfig_NEW_CARD_INSERT_ORDER_DUE
+	if s.New.Order == newCardOrderSchema11Random {
+		order = pb.DeckConfig_NEW_CARD_INSERT_ORDER_RANDOM
+	}
+	return &DeckConfig{
+		ID:       s.ID,
+		Name:     s.Name,
+		Modified: time.Unix(s.Mod, 0),
+		USN:      int(s.USN),
+		Config: &pb.DeckConfig{
+			LearnSteps:                      s.New.Delays,
+			RelearnSteps:                    s.Lapse.Delays,
+			FsrsParams_4:                    s.FsrsWeights,
+			FsrsParams_5:                    s.FsrsParams5,
+			FsrsParams_6:                    s.FsrsParams6,
+			NewPerDay:                       s.New.PerDay,
+			ReviewsPerDay:                   s.Rev.PerDay,
+			NewPerDayMinimum:                s.NewPerDayMinimum,
+			InitialEase:                     float32(s.New.InitialFactor) / 1000,
+			EasyMultiplier:                  s.Rev.Ease4,
+			HardMultiplier:                  s.Rev.HardFactor,
+			LapseMultiplier:                 s.Lapse.Mult,
+			IntervalMultiplier:              s.Rev.IvlFct,
+			MaximumReviewInterval:           s.Rev.MaxIvl,
+			MinimumLapseInterval:            s.Lapse.MinInt,
+			GraduatingIntervalGood:          s.New.Ints[0],
+			GraduatingIntervalEasy:          s.New.Ints[1],
+			NewCardInsertOrder:              order,
+			NewCardGatherPriority:           pb.DeckConfig_NewCardGatherPriority(s.NewGatherPriority),
+			NewCardSortOrder:                pb.DeckConfig_NewCardSortOrder(s.NewSortOrder),
+			NewMix:                          pb.DeckConfig_ReviewMix(s.NewMix),
+			ReviewOrder:                     pb.DeckConfig_ReviewCardOrder(s.ReviewOrder),
+			InterdayLearningMix:             pb.DeckConfig_ReviewMix(s.InterdayLearningMix),
+			LeechAction:                     pb.DeckConfig_LeechAction(s.Lapse.LeechAction),
+			LeechThreshold:                  s.Lapse.LeechFails,
+			DisableAutoplay:                 !s.Autoplay,
+			CapAnswerTimeToSecs:             s.MaxTaken,
+			ShowTimer:                       bool(s.Timer),
+			StopTimerOnAnswer:               s.StopTimerOnAnswer,
+			SecondsToShowQuestion:           s.SecondsToShowQuestion,
+			SecondsToShowAnswer:             s.SecondsToShowAnswer,
+			QuestionAction:                  pb.DeckConfig_QuestionAction(s.QuestionAction),
+			AnswerAction:                    pb.DeckConfig_AnswerAction(s.AnswerAction),
+			WaitForAudio:                    s.WaitForAudio,
+			SkipQuestionWhenReplayingAnswer: !s.ReplayQ,
+			BuryNew:                         s.New.Bury,
+			BuryReviews:                     s.Rev.Bury,
+			BuryInterdayLearning:            s.BuryInterdayLearning,
+			DesiredRetention:                s.DesiredRetention,
+			IgnoreRevlogsBeforeDate:         s.IgnoreRevlogsBeforeDate,
+			EasyDaysPercentages:             s.EasyDaysPercentages,
+			HistoricalRetention:             s.SM2Retention,
+			ParamSearch:                     s.WeightSearch,
+			Other:                           other,
+		},
+	}
+}
+
+// unmarshalSchema11 unmarshals a legacy object into v, returning any keys
+// that v does not know about as a JSON object, so they can be preserved.
+// Unknown keys of nested objects, such as the new, rev and lapse settings of
+// deck configurations, are kept under the key of the object, as Anki does.
+func unmarshalSchema11(b []byte, v any) ([]byte, error) {
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	obj, err := unknownSchema11Keys(b, reflect.TypeOf(v).Elem())
+	if err != nil || len(obj) == 0 {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+// unknownSchema11Keys returns the keys of the legacy object b that the struct
+// type t does not know about.
+func unknownSchema11Keys(b []byte, t reflect.Type) (map[string]json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			continue
+		}
+		if v, ok := obj[name]; ok && f.Type.Kind() == reflect.Struct {
+			nested, err := unknownSchema11Keys(v, f.Type)
+			if err != nil {
+				return nil, err
+			}
+			if len(nested) > 0 {
+				if obj[name], err = json.Marshal(nested); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		delete(obj, name)
+	}
+	return obj, nil
+}
+
+// numericBool is a legacy boolean stored as 0 or 1. Some older clients
+// stored it as a JSON boolean instead, which is also accepted.
+type numericBool bool
+
+func (b numericBool) MarshalJSON() ([]byte, error) {
+	if b {
+		return []byte("1"), nil
+	}
+	return []byte("0"), nil
+}
+
+func (b *numericBool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = numericBool(v)
+	case float64:
+		*b = v != 0
+	default:
+		*b = false
+	}
+	return nil
+}
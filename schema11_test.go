@@ -0,0 +1,304 @@
+package anki
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lftk/anki/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestDeckConfigSchema11RoundTrip tests converting a deck configuration to
+// its legacy JSON representation and back.
+func TestDeckConfigSchema11RoundTrip(t *testing.T) {
+	want := &DeckConfig{
+		ID:     1,
+		Name:   "Default",
+		Config: DefaultDeckConfig(),
+	}
+	want.Config.LearnSteps = []float32{1, 10}
+	want.Config.RelearnSteps = []float32{10}
+	want.Config.NewCardInsertOrder = pb.DeckConfig_NEW_CARD_INSERT_ORDER_RANDOM
+	want.Config.ShowTimer = true
+
+	b, err := marshalSchema11(deckConfigToSchema11(want), []byte(`{"extra":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s deckConfigSchema11
+	other, err := unmarshalSchema11(b, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(other) != `{"extra":1}` {
+		t.Errorf("other = %s, want %s", other, `{"extra":1}`)
+	}
+
+	got := deckConfigFromSchema11(&s, nil)
+	got.Config.FsrsParams_4 = nil
+	got.Config.FsrsParams_5 = nil
+	got.Config.FsrsParams_6 = nil
+	got.Config.EasyDaysPercentages = nil
+	if !proto.Equal(got.Config, want.Config) {
+		t.Errorf("deckConfigFromSchema11() = %v, want %v", got.Config, want.Config)
+	}
+}
+
+// TestDeckSchema11RoundTrip tests converting decks to their legacy JSON
+// representation and back.
+func TestDeckSchema11RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		deck *Deck
+	}{
+		{
+			name: "normal",
+			deck: &Deck{
+				ID:     2,
+				Name:   JoinDeckName("A", "B"),
+				Common: DefaultDeckCommon(),
+				Kind:   NormalDeckKind(3),
+			},
+		},
+		{
+			name: "filtered",
+			deck: &Deck{
+				ID:     3,
+				Name:   "Filtered",
+				Common: DefaultDeckCommon(),
+				Kind: &pb.DeckKind{
+					Kind: &pb.DeckKind_Filtered{
+						Filtered: &pb.DeckFiltered{
+							Reschedule: true,
+							SearchTerms: []*pb.DeckFiltered_SearchTerm{
+								{Search: "is:due", Limit: 100, Order: pb.DeckFiltered_SearchTerm_RANDOM},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(deckToSchema11(tt.deck))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var s deckSchema11
+			if _, err = unmarshalSchema11(b, &s); err != nil {
+				t.Fatal(err)
+			}
+			got := deckFromSchema11(&s, nil)
+			if got.Name != tt.deck.Name {
+				t.Errorf("Name = %q, want %q", got.Name, tt.deck.Name)
+			}
+			if !proto.Equal(got.Kind, tt.deck.Kind) {
+				t.Errorf("Kind = %v, want %v", got.Kind, tt.deck.Kind)
+			}
+			if !proto.Equal(got.Common, tt.deck.Common) {
+				t.Errorf("Common = %v, want %v", got.Common, tt.deck.Common)
+			}
+		})
+	}
+}
+
+// TestDeckConfigSchema11Defaults tests that keys missing from a legacy deck
+// configuration take the values Anki assumes, and that unknown keys of its
+// nested objects are kept.
+func TestDeckConfigSchema11Defaults(t *testing.T) {
+	b := []byte(`{"id":1,"name":"Default","new":{"perDay":20,"extra":1},"rev":{"perDay":200},"lapse":{},"extra":2}`)
+	s := newDeckConfigSchema11()
+	other, err := unmarshalSchema11(b, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"extra":2,"new":{"extra":1}}`; string(other) != want {
+		t.Errorf("other = %s, want %s", other, want)
+	}
+	c := deckConfigFromSchema11(s, other).Config
+	if c.HardMultiplier != 1.2 || c.DesiredRetention != 0.9 || c.HistoricalRetention != 0.9 {
+		t.Errorf("hard multiplier, desired and historical retention = %v, %v, %v, want 1.2, 0.9, 0.9",
+			c.HardMultiplier, c.DesiredRetention, c.HistoricalRetention)
+	}
+	if want := []float32{1, 1, 1, 1, 1, 1, 1}; !slices.Equal(c.EasyDaysPercentages, want) {
+		t.Errorf("easy days percentages = %v, want %v", c.EasyDaysPercentages, want)
+	}
+
+	b, err = marshalSchema11(deckConfigToSchema11(&DeckConfig{ID: 1, Config: c}), c.Other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj struct {
+		New   map[string]any `json:"new"`
+		Extra int            `json:"extra"`
+	}
+	if err = json.Unmarshal(b, &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.New["extra"] != 1.0 || obj.New["perDay"] != 20.0 || obj.Extra != 2 {
+		t.Errorf("marshalSchema11() = %s, want the unknown keys merged back", b)
+	}
+}
+
+// TestUpgradeSchema11 tests opening a legacy collection written without the
+// deck configuration keys that newer clients added, and scheduling a card in
+// it.
+func TestUpgradeSchema11(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:      "Basic",
+		Config:    NewNotetypeConfig("", false),
+		Fields:    []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{NewTemplate("Card 1", "{{Front}}", "{{Back}}")},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	note := &Note{NotetypeID: notetype.ID, Fields: []string{"front", "back"}}
+	if err = col.AddNote(1, note); err != nil {
+		t.Fatal(err)
+	}
+	var cardID int64
+	for card, err := range col.ListCards(nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		card.Type, card.Queue = CardTypeReview, CardQueueReview
+		card.Due, card.Interval, card.Factor = col.props.daysElapsed(col.ids.now()), 10, 2500
+		if err = updateCard(col.db, card); err != nil {
+			t.Fatal(err)
+		}
+		cardID = card.ID
+	}
+
+	dir, db := newLegacyProfile(t, col)
+	dconf, err := sqlGet(db, scanValue[string], "SELECT dconf FROM col")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var configs map[string]map[string]json.RawMessage
+	if err = json.Unmarshal([]byte(dconf), &configs); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range configs {
+		var rev map[string]json.RawMessage
+		if err = json.Unmarshal(config["rev"], &rev); err != nil {
+			t.Fatal(err)
+		}
+		delete(rev, "hardFactor")
+		if config["rev"], err = json.Marshal(rev); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"desiredRetention", "sm2Retention", "easyDaysPercentages"} {
+			delete(config, key)
+		}
+	}
+	b, err := json.Marshal(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sqlExecute(db, "UPDATE col SET dconf = ?", string(b)); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded, err := OpenProfile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upgraded.Close() //nolint:errcheck
+
+	config, err := upgraded.GetDeckConfig(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := config.Config; c.HardMultiplier != 1.2 || c.DesiredRetention != 0.9 || c.HistoricalRetention != 0.9 {
+		t.Errorf("hard multiplier, desired and historical retention = %v, %v, %v, want 1.2, 0.9, 0.9",
+			c.HardMultiplier, c.DesiredRetention, c.HistoricalRetention)
+	}
+	intervals, err := upgraded.NextIntervals(cardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	if hard, good := intervals[ReviewEaseHard], intervals[ReviewEaseGood]; hard <= 10*day || good <= hard {
+		t.Errorf("hard and good intervals = %v, %v, want more than 10 days and increasing", hard, good)
+	}
+}
+
+// newLegacyProfile creates a profile directory holding a copy of a collection
+// downgraded to schema 11, and returns it with its database, which must be
+// closed before the profile is opened.
+func newLegacyProfile(t *testing.T, col *Collection) (string, *sql.DB) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "collection.anki2")
+	if err := copyDatabase(col.database(), path, nil); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlite3Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err = downgradeToSchema11(db); err != nil {
+		t.Fatal(err)
+	}
+	return dir, db
+}
+
+// TestUpgradeSchemaVersions tests opening legacy collections marked with each
+// schema version other than the current one.
+func TestUpgradeSchemaVersions(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	for _, ver := range []int{11, 12, 13, 14, 15, 16, 17, 19} {
+		t.Run(strconv.Itoa(ver), func(t *testing.T) {
+			dir, db := newLegacyProfile(t, col)
+			if err := sqlExecute(db, "UPDATE col SET ver = ?", ver); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			upgraded, err := OpenProfile(dir, nil)
+			if ver < 14 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer upgraded.Close() //nolint:errcheck
+				if _, err = upgraded.GetDeckConfig(1); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil {
+				_ = upgraded.Close()
+			}
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) || schemaErr.Version != ver || !errors.Is(err, ErrUnsupportedSchema) {
+				t.Errorf("OpenProfile() error = %v, want a SchemaError for version %d", err, ver)
+			}
+		})
+	}
+}
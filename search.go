@@ -0,0 +1,831 @@
+package anki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidSearch is returned when a search cannot be parsed.
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchNotes lists the notes matching a search written in Anki's search
+// syntax. Terms are combined with "and" unless joined with "or", "-" negates
+// a term, and parentheses group terms. Text containing spaces or parentheses
+// is quoted, as in "deck:My Deck" or deck:"My Deck", and a backslash escapes
+// the character that follows it.
+//
+// Unqualified text matches notes with a field containing it, where "*"
+// matches any sequence of characters and "_" any single character. The
+// following terms are supported:
+//
+//   - deck:name matches cards in a deck or its subdecks, and deck:filtered
+//     cards in filtered decks
+//   - tag:name matches notes with a tag or its child tags, and tag:none
+//     notes without tags
+//   - note:name matches notes of a notetype
+//   - preset:name matches cards in decks using a deck configuration
+//   - card:n or card:name matches cards of a template, by number or name
+//   - field:text matches notes whose field is text, as in Front:*word*
+//   - is:new, is:learn, is:review, is:due, is:suspended and is:buried match
+//     cards by state
+//   - flag:n matches cards with a flag, or no flag with flag:0
+//   - prop:ivl>10 compares ivl, due, reps, lapses, ease or pos to a number
+//   - rated:n and rated:n:ease match cards answered in the last n days
+//   - added:n matches cards added in the last n days
+//   - re:regexp matches notes with a field matching a regular expression
+//   - nc:text matches like unqualified text, ignoring accents
+//   - dupe:notetype,text matches notes of a notetype whose first field is
+//     text
+func (c *Collection) SearchNotes(query string) iter.Seq2[*Note, error] {
+	return searchNotes(c.db, query, c.props, c.ids.now())
+}
+
+// SearchNotesContext is like SearchNotes, but stops the iteration with an
+// error once ctx is done.
+func (c *Collection) SearchNotesContext(ctx context.Context, query string) iter.Seq2[*Note, error] {
+	return searchNotes(sqlWithContext(ctx, c.db), query, c.props, c.ids.now())
+}
+
+// SearchCards lists the cards matching a search written in Anki's search
+// syntax, as described for SearchNotes.
+func (c *Collection) SearchCards(query string) iter.Seq2[*Card, error] {
+	return searchCards(c.db, query, c.props, c.ids.now())
+}
+
+// SearchCardsContext is like SearchCards, but stops the iteration with an
+// error once ctx is done.
+func (c *Collection) SearchCardsContext(ctx context.Context, query string) iter.Seq2[*Card, error] {
+	return searchCards(sqlWithContext(ctx, c.db), query, c.props, c.ids.now())
+}
+
+// searchNotes lists the notes matching a search at time now.
+func searchNotes(q sqlQueryer, query string, p *props, now time.Time) iter.Seq2[*Note, error] {
+	where, args, err := compileSearch(q, query, p, now)
+	if err != nil {
+		return errorSeq[*Note](err)
+	}
+	query = getNoteQuery + " WHERE id IN (" + searchNoteIDsQuery + " " + where + ")"
+	return sqlSelectSeq(q, scanNote, query, args...)
+}
+
+// searchCards lists the cards matching a search at time now.
+func searchCards(q sqlQueryer, query string, p *props, now time.Time) iter.Seq2[*Card, error] {
+	where, args, err := compileSearch(q, query, p, now)
+	if err != nil {
+		return errorSeq[*Card](err)
+	}
+	query = getCardQuery + " WHERE id IN (" + searchCardIDsQuery + " " + where + ")"
+	return sqlSelectSeq(q, scanCard, query, args...)
+}
+
+// searchNode is a node of a parsed search: a searchAnd, searchOr, searchNot
+// or searchTerm.
+type searchNode any
+
+// searchAnd matches what all its nodes match.
+type searchAnd []searchNode
+
+// searchOr matches what any of its nodes matches.
+type searchOr []searchNode
+
+// searchNot matches what its node does not match.
+type searchNot struct {
+	node searchNode
+}
+
+// searchTerm is a single term, such as "deck:French" or unqualified text, in
+// which case its key is empty. Escapes are kept in the key and value, so
+// that escaped wildcards can be told from wildcards.
+type searchTerm struct {
+	key   string
+	value string
+}
+
+// searchTokenKind is the kind of a searchToken.
+type searchTokenKind int
+
+const (
+	searchTokenText searchTokenKind = iota
+	searchTokenAnd
+	searchTokenOr
+	searchTokenNot
+	searchTokenOpen
+	searchTokenClose
+)
+
+// searchToken is a token of a search.
+type searchToken struct {
+	kind searchTokenKind
+	text string
+}
+
+// parseSearch parses a search. An empty search yields a nil node, which
+// matches everything.
+func parseSearch(s string) (searchNode, error) {
+	tokens, err := tokenizeSearch(s)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	p := &searchParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.unexpected()
+	}
+	return node, nil
+}
+
+// tokenizeSearch splits a search into tokens.
+func tokenizeSearch(s string) ([]searchToken, error) {
+	var tokens []searchToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, searchToken{searchTokenOpen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, searchToken{searchTokenClose, ")"})
+			i++
+		case r == '-' && i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) && rs[i+1] != ')':
+			tokens = append(tokens, searchToken{searchTokenNot, "-"})
+			i++
+		default:
+			text, n, plain, err := scanSearchText(rs[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+			kind := searchTokenText
+			if plain {
+				switch strings.ToLower(text) {
+				case "and":
+					kind = searchTokenAnd
+				case "or":
+					kind = searchTokenOr
+				}
+			}
+			tokens = append(tokens, searchToken{kind, text})
+		}
+	}
+	return tokens, nil
+}
+
+// scanSearchText scans the text of a term, which ends at white space or a
+// parenthesis outside quotes. Quotes are removed, while escapes are kept.
+// It reports how many runes were scanned, and whether the text was plain,
+// without quotes or escapes.
+func scanSearchText(rs []rune) (text string, n int, plain bool, err error) {
+	var b strings.Builder
+	quoted := false
+	plain = true
+	for ; n < len(rs); n++ {
+		r := rs[n]
+		switch {
+		case r == '\\':
+			if n+1 == len(rs) {
+				return "", 0, false, fmt.Errorf("%w: trailing backslash", ErrInvalidSearch)
+			}
+			plain = false
+			n++
+			b.WriteRune(r)
+			b.WriteRune(rs[n])
+			continue
+		case r == '"':
+			plain = false
+			quoted = !quoted
+			continue
+		case !quoted && (unicode.IsSpace(r) || r == '(' || r == ')'):
+			return b.String(), n, plain, nil
+		}
+		b.WriteRune(r)
+	}
+	if quoted {
+		return "", 0, false, fmt.Errorf("%w: unterminated quote", ErrInvalidSearch)
+	}
+	return b.String(), n, plain, nil
+}
+
+// searchParser parses the tokens of a search. "and" binds more tightly than
+// "or", and "-" more tightly than both.
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+// peek returns the kind of the next token, reporting false at the end.
+func (p *searchParser) peek() (searchTokenKind, bool) {
+	if p.pos == len(p.tokens) {
+		return 0, false
+	}
+	return p.tokens[p.pos].kind, true
+}
+
+// unexpected returns an error for the next token.
+func (p *searchParser) unexpected() error {
+	if p.pos == len(p.tokens) {
+		return fmt.Errorf("%w: unexpected end", ErrInvalidSearch)
+	}
+	return fmt.Errorf("%w: unexpected %q", ErrInvalidSearch, p.tokens[p.pos].text)
+}
+
+func (p *searchParser) parseOr() (searchNode, error) {
+	var nodes searchOr
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if kind, ok := p.peek(); !ok || kind != searchTokenOr {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *searchParser) parseAnd() (searchNode, error) {
+	var nodes searchAnd
+	for {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		kind, ok := p.peek()
+		if !ok || kind == searchTokenOr || kind == searchTokenClose {
+			break
+		}
+		if kind == searchTokenAnd {
+			p.pos++
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *searchParser) parseUnary() (searchNode, error) {
+	kind, ok := p.peek()
+	if !ok {
+		return nil, p.unexpected()
+	}
+	switch kind {
+	case searchTokenNot:
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return searchNot{node}, nil
+	case searchTokenOpen:
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if kind, ok := p.peek(); !ok || kind != searchTokenClose {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		return node, nil
+	case searchTokenText:
+		text := p.tokens[p.pos].text
+		p.pos++
+		return parseSearchTerm(text), nil
+	}
+	return nil, p.unexpected()
+}
+
+// parseSearchTerm splits the text of a term at its first unescaped colon.
+func parseSearchTerm(text string) searchTerm {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case ':':
+			return searchTerm{key: text[:i], value: text[i+1:]}
+		}
+	}
+	return searchTerm{value: text}
+}
+
+// globPart is a run of literal text, or a wildcard, in the text of a search.
+type globPart struct {
+	lit  string
+	wild byte
+}
+
+// splitGlob splits the text of a search into literal text and the "*" and
+// "_" wildcards, removing escapes.
+func splitGlob(s string) []globPart {
+	var parts []globPart
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, globPart{lit: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				lit.WriteByte(s[i])
+			}
+		case '*', '_':
+			flush()
+			parts = append(parts, globPart{wild: c})
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return parts
+}
+
+// unescapeSearch removes the escapes from the text of a search.
+func unescapeSearch(s string) string {
+	var b strings.Builder
+	for _, part := range splitGlob(s) {
+		if part.wild != 0 {
+			b.WriteByte(part.wild)
+		}
+		b.WriteString(part.lit)
+	}
+	return b.String()
+}
+
+// escapeSearch escapes text so that a search matches it literally, once
+// quoted.
+func escapeSearch(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\"*_`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// globLike converts the text of a search to a LIKE pattern escaped with a
+// backslash.
+func globLike(s string) string {
+	var b strings.Builder
+	for _, part := range splitGlob(s) {
+		switch part.wild {
+		case '*':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte('_')
+		default:
+			for _, r := range part.lit {
+				if r == '\\' || r == '%' || r == '_' {
+					b.WriteByte('\\')
+				}
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// globRegexp converts the text of a search to a regular expression, in which
+// "*" becomes many and "_" becomes one.
+func globRegexp(s, many, one string) string {
+	var b strings.Builder
+	for _, part := range splitGlob(s) {
+		switch part.wild {
+		case '*':
+			b.WriteString(many)
+		case '_':
+			b.WriteString(one)
+		default:
+			b.WriteString(regexp.QuoteMeta(part.lit))
+		}
+	}
+	return b.String()
+}
+
+// globName compiles a case-insensitive regular expression matching the
+// names given by the text of a search.
+func globName(s string) *regexp.Regexp {
+	return regexp.MustCompile("(?i)^" + globRegexp(s, ".*", ".") + "$")
+}
+
+// searchWriter compiles a parsed search to an SQL condition over the cards c
+// and their notes n.
+type searchWriter struct {
+	q     sqlQueryer
+	props *props
+	now   time.Time
+	sql   strings.Builder
+	args  []any
+	// notetypes caches the notetypes of the collection once they are needed.
+	notetypes []*Notetype
+}
+
+// compileSearch compiles a search to an SQL condition and its arguments,
+// evaluating relative dates at time now.
+func compileSearch(q sqlQueryer, query string, p *props, now time.Time) (string, []any, error) {
+	node, err := parseSearch(query)
+	if err != nil {
+		return "", nil, err
+	}
+	if node == nil {
+		return "1", nil, nil
+	}
+	w := &searchWriter{q: q, props: p, now: now}
+	if err = w.write(node); err != nil {
+		return "", nil, err
+	}
+	return w.sql.String(), w.args, nil
+}
+
+func (w *searchWriter) write(node searchNode) error {
+	switch node := node.(type) {
+	case searchAnd:
+		return w.writeList(node, " AND ")
+	case searchOr:
+		return w.writeList(node, " OR ")
+	case searchNot:
+		w.sql.WriteString("NOT ")
+		return w.write(node.node)
+	case searchTerm:
+		w.sql.WriteString("(")
+		if err := w.writeTerm(node); err != nil {
+			return err
+		}
+		w.sql.WriteString(")")
+		return nil
+	}
+	return fmt.Errorf("unknown search node %T", node)
+}
+
+func (w *searchWriter) writeList(nodes []searchNode, sep string) error {
+	w.sql.WriteString("(")
+	for i, node := range nodes {
+		if i > 0 {
+			w.sql.WriteString(sep)
+		}
+		if err := w.write(node); err != nil {
+			return err
+		}
+	}
+	w.sql.WriteString(")")
+	return nil
+}
+
+// writef writes a condition with its arguments.
+func (w *searchWriter) writef(sql string, args ...any) {
+	w.sql.WriteString(sql)
+	w.args = append(w.args, args...)
+}
+
+func (w *searchWriter) writeTerm(t searchTerm) error {
+	switch strings.ToLower(t.key) {
+	case "":
+		like := "%" + globLike(t.value) + "%"
+		w.writef(`n.sfld LIKE ? ESCAPE '\' OR n.flds LIKE ? ESCAPE '\'`, like, like)
+		return nil
+	case "deck":
+		return w.writeDeck(t.value)
+	case "tag":
+		w.writeTag(t.value)
+		return nil
+	case "note":
+		return w.writeNotetype(t.value)
+	case "preset":
+		return w.writePreset(t.value)
+	case "card":
+		return w.writeTemplate(t.value)
+	case "flag":
+		return w.writeFlag(t.value)
+	case "is":
+		return w.writeState(t.value)
+	case "prop":
+		return w.writeProp(t.value)
+	case "rated":
+		return w.writeRated(t.value)
+	case "added":
+		return w.writeAdded(t.value)
+	case "re":
+		return w.writeRegexp(t.value)
+	case "nc":
+		w.writeNoCombining(t.value)
+		return nil
+	case "dupe":
+		return w.writeDupe(t.value)
+	}
+	return w.writeField(t.key, t.value)
+}
+
+func (w *searchWriter) writeDeck(name string) error {
+	switch name {
+	case "*":
+		w.sql.WriteString("1")
+		return nil
+	case "filtered":
+		w.sql.WriteString("c.odid != 0")
+		return nil
+	}
+	re := regexp.MustCompile("(?i)^" + globRegexp(name, ".*", ".") + "(?:$|::)")
+	decks, err := sqlSelect(w.q, scanDeck, getDeckQuery)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for _, deck := range decks {
+		if re.MatchString(deck.Name.HumanString()) {
+			ids = append(ids, deck.ID)
+		}
+	}
+	list := sqlIntList(ids)
+	w.sql.WriteString("c.did IN " + list + " OR c.odid IN " + list)
+	return nil
+}
+
+func (w *searchWriter) writeTag(tag string) {
+	switch tag {
+	case "none":
+		w.sql.WriteString("n.tags = ''")
+	case "*":
+		w.sql.WriteString("1")
+	default:
+		re := `(?i).* ` + globRegexp(tag, `\S*`, `\S`) + `(::| ).*`
+		w.writef("n.tags REGEXP ?", re)
+	}
+}
+
+func (w *searchWriter) writeNotetype(name string) error {
+	notetypes, err := w.loadNotetypes()
+	if err != nil {
+		return err
+	}
+	re := globName(name)
+	var ids []int64
+	for _, nt := range notetypes {
+		if re.MatchString(nt.Name) {
+			ids = append(ids, nt.ID)
+		}
+	}
+	w.sql.WriteString("n.mid IN " + sqlIntList(ids))
+	return nil
+}
+
+func (w *searchWriter) writePreset(name string) error {
+	configs, err := sqlSelect(w.q, scanDeckConfig, getDeckConfigQuery)
+	if err != nil {
+		return err
+	}
+	re := globName(name)
+	matched := make(map[int64]bool)
+	for _, config := range configs {
+		if re.MatchString(config.Name) {
+			matched[config.ID] = true
+		}
+	}
+	decks, err := sqlSelect(w.q, scanDeck, getDeckQuery)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for _, deck := range decks {
+		normal := deck.Kind.GetNormal()
+		if normal == nil {
+			continue
+		}
+		configID := normal.GetConfigId()
+		if configID == 0 {
+			configID = 1
+		}
+		if matched[configID] {
+			ids = append(ids, deck.ID)
+		}
+	}
+	list := sqlIntList(ids)
+	w.sql.WriteString("c.did IN " + list + " OR c.odid IN " + list)
+	return nil
+}
+
+func (w *searchWriter) writeTemplate(template string) error {
+	if n, err := strconv.Atoi(template); err == nil {
+		if n < 1 {
+			return fmt.Errorf("%w: card:%s", ErrInvalidSearch, template)
+		}
+		w.writef("c.ord = ?", n-1)
+		return nil
+	}
+	notetypes, err := w.loadNotetypes()
+	if err != nil {
+		return err
+	}
+	re := globName(template)
+	var conds []string
+	for _, nt := range notetypes {
+		for _, t := range nt.Templates {
+			if re.MatchString(t.Name) {
+				conds = append(conds, fmt.Sprintf("n.mid = %d AND c.ord = %d", nt.ID, t.Ordinal))
+			}
+		}
+	}
+	w.sql.WriteString(sqlOrList(conds))
+	return nil
+}
+
+func (w *searchWriter) writeFlag(flag string) error {
+	n, err := strconv.Atoi(flag)
+	if err != nil || n < 0 || n > 7 {
+		return fmt.Errorf("%w: flag:%s", ErrInvalidSearch, flag)
+	}
+	w.writef("(c.flags & 7) = ?", n)
+	return nil
+}
+
+func (w *searchWriter) writeState(state string) error {
+	switch strings.ToLower(state) {
+	case "new":
+		w.writef("c.type = ?", CardTypeNew)
+	case "learn":
+		w.writef("c.queue IN (?, ?)", CardQueueLearn, CardQueueDayLearn)
+	case "review":
+		w.writef("c.type IN (?, ?)", CardTypeReview, CardTypeRelearn)
+	case "due":
+		w.writef("c.queue IN (?, ?) AND c.due <= ? OR c.queue = ? AND c.due <= ?",
+			CardQueueReview, CardQueueDayLearn, w.props.daysElapsed(w.now),
+			CardQueueLearn, w.now.Unix())
+	case "suspended":
+		w.writef("c.queue = ?", CardQueueSuspended)
+	case "buried":
+		w.writef("c.queue IN (?, ?)", CardQueueSchedBuried, CardQueueUserBuried)
+	default:
+		return fmt.Errorf("%w: is:%s", ErrInvalidSearch, state)
+	}
+	return nil
+}
+
+// propRe matches the value of a prop: term.
+var propRe = regexp.MustCompile(`^(ivl|due|reps|lapses|ease|pos)(<=|>=|!=|=|<|>)(-?\d+(?:\.\d+)?)$`)
+
+func (w *searchWriter) writeProp(prop string) error {
+	m := propRe.FindStringSubmatch(strings.ToLower(prop))
+	if m == nil {
+		return fmt.Errorf("%w: prop:%s", ErrInvalidSearch, prop)
+	}
+	name, op := m[1], m[2]
+	if name == "ease" {
+		ease, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			return fmt.Errorf("%w: prop:%s", ErrInvalidSearch, prop)
+		}
+		w.writef("c.factor "+op+" ?", int64(math.Round(ease*1000)))
+		return nil
+	}
+	n, err := strconv.ParseInt(m[3], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: prop:%s", ErrInvalidSearch, prop)
+	}
+	switch name {
+	case "due":
+		w.writef("c.queue IN (?, ?) AND c.due "+op+" ?",
+			CardQueueReview, CardQueueDayLearn, w.props.daysElapsed(w.now)+n)
+	case "pos":
+		w.writef("c.type = ? AND c.due "+op+" ?", CardTypeNew, n)
+	default:
+		w.writef("c."+name+" "+op+" ?", n)
+	}
+	return nil
+}
+
+// daysCutoff returns the time, in milliseconds, at which the day that was n-1
+// days before today started.
+func (w *searchWriter) daysCutoff(n int64) int64 {
+	return w.props.dayCutoff(w.now).Add(-time.Duration(n) * 24 * time.Hour).UnixMilli()
+}
+
+func (w *searchWriter) writeRated(rated string) error {
+	days, ease, hasEase := strings.Cut(rated, ":")
+	n, err := strconv.ParseInt(days, 10, 64)
+	if err != nil || n < 1 {
+		return fmt.Errorf("%w: rated:%s", ErrInvalidSearch, rated)
+	}
+	if !hasEase {
+		w.writef("c.id IN (SELECT cid FROM revlog WHERE id > ? AND ease BETWEEN 1 AND 4)", w.daysCutoff(n))
+		return nil
+	}
+	e, err := strconv.Atoi(ease)
+	if err != nil || e < 1 || e > 4 {
+		return fmt.Errorf("%w: rated:%s", ErrInvalidSearch, rated)
+	}
+	w.writef("c.id IN (SELECT cid FROM revlog WHERE id > ? AND ease = ?)", w.daysCutoff(n), e)
+	return nil
+}
+
+func (w *searchWriter) writeAdded(added string) error {
+	n, err := strconv.ParseInt(added, 10, 64)
+	if err != nil || n < 1 {
+		return fmt.Errorf("%w: added:%s", ErrInvalidSearch, added)
+	}
+	w.writef("c.id > ?", w.daysCutoff(n))
+	return nil
+}
+
+func (w *searchWriter) writeRegexp(re string) error {
+	re = "(?i)" + re
+	if _, err := regexp.Compile(re); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSearch, err)
+	}
+	w.writef("regexp_fields(?, n.flds)", re)
+	return nil
+}
+
+func (w *searchWriter) writeNoCombining(text string) {
+	like := "%" + globLike(withoutCombining(text)) + "%"
+	w.writef(`process_text(n.flds, ?) LIKE ? ESCAPE '\'`, processTextNoCombining, like)
+}
+
+func (w *searchWriter) writeDupe(dupe string) error {
+	id, text, ok := strings.Cut(dupe, ",")
+	notetypeID, err := strconv.ParseInt(id, 10, 64)
+	if !ok || err != nil {
+		return fmt.Errorf("%w: dupe:%s", ErrInvalidSearch, dupe)
+	}
+	// The checksum narrows the notes down quickly, but it can collide.
+	text = stripHTML(unescapeSearch(text))
+	w.writef("n.mid = ? AND n.csum = ? AND process_text(field_at_index(n.flds, 0), ?) = ?",
+		notetypeID, fieldChecksum(text), processTextStripHTML, text)
+	return nil
+}
+
+func (w *searchWriter) writeField(field, text string) error {
+	notetypes, err := w.loadNotetypes()
+	if err != nil {
+		return err
+	}
+	re := globName(field)
+	value := "(?is)^" + globRegexp(text, ".*", ".") + "$"
+	var conds []string
+	for _, nt := range notetypes {
+		var ords []string
+		for _, f := range nt.Fields {
+			if re.MatchString(f.Name) {
+				ords = append(ords, strconv.Itoa(f.Ordinal))
+			}
+		}
+		if len(ords) > 0 {
+			conds = append(conds, "n.mid = ? AND regexp_fields(?, n.flds, "+strings.Join(ords, ", ")+")")
+			w.args = append(w.args, nt.ID, value)
+		}
+	}
+	w.sql.WriteString(sqlOrList(conds))
+	return nil
+}
+
+// loadNotetypes returns the notetypes of the collection.
+func (w *searchWriter) loadNotetypes() ([]*Notetype, error) {
+	if w.notetypes == nil {
+		notetypes, err := sqlSelect(w.q, scanNotetype, getNotetypeQuery)
+		if err != nil {
+			return nil, err
+		}
+		w.notetypes = notetypes
+	}
+	return w.notetypes, nil
+}
+
+// sqlIntList formats integers as an SQL list, such as "(1, 2)".
+func sqlIntList(vals []int64) string {
+	s := make([]string, len(vals))
+	for i, v := range vals {
+		s[i] = strconv.FormatInt(v, 10)
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
+
+// sqlOrList joins conditions with OR, matching nothing if there are none.
+func sqlOrList(conds []string) string {
+	if len(conds) == 0 {
+		return "0"
+	}
+	return "(" + strings.Join(conds, ") OR (") + ")"
+}
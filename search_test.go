@@ -0,0 +1,130 @@
+package anki
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// TestSearch tests the SearchNotes and SearchCards methods.
+func TestSearch(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	notetype := &Notetype{
+		Name:   "Basic (and reversed card)",
+		Config: NewNotetypeConfig("", false),
+		Fields: []*Field{NewField("Front"), NewField("Back")},
+		Templates: []*Template{
+			NewTemplate("Card 1", "{{Front}}", "{{Back}}"),
+			NewTemplate("Card 2", "{{Back}}", "{{Front}}"),
+		},
+	}
+	if err = col.AddNotetype(notetype); err != nil {
+		t.Fatal(err)
+	}
+	deck := &Deck{
+		Name:   JoinDeckName("French", "Verbs"),
+		Common: DefaultDeckCommon(),
+		Kind:   NormalDeckKind(1),
+	}
+	if err = col.AddDeck(deck); err != nil {
+		t.Fatal(err)
+	}
+
+	notes := []*Note{
+		{NotetypeID: notetype.ID, Fields: []string{"être", "to be"}, Tags: []string{"verb::irregular"}},
+		{NotetypeID: notetype.ID, Fields: []string{"parler", "to speak"}, Tags: []string{"verb"}},
+		{NotetypeID: notetype.ID, Fields: []string{"le chat", "the cat"}},
+	}
+	for i, note := range notes {
+		deckID := deck.ID
+		if i == 2 {
+			deckID = 1
+		}
+		if err = col.AddNote(deckID, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []int // indexes of the matching notes
+	}{
+		{query: "", want: []int{0, 1, 2}},
+		{query: "to", want: []int{0, 1}},
+		{query: "*cat", want: []int{2}},
+		{query: `"le chat"`, want: []int{2}},
+		{query: "-to", want: []int{2}},
+		{query: "speak or cat", want: []int{1, 2}},
+		{query: "to -(speak or cat)", want: []int{0}},
+		{query: "deck:French", want: []int{0, 1}},
+		{query: `deck:"french::verbs"`, want: []int{0, 1}},
+		{query: "deck:Fr*", want: []int{0, 1}},
+		{query: "deck:Verbs"},
+		{query: "tag:verb", want: []int{0, 1}},
+		{query: "tag:verb::irregular", want: []int{0}},
+		{query: "tag:none", want: []int{2}},
+		{query: "note:basic*", want: []int{0, 1, 2}},
+		{query: "note:Cloze"},
+		{query: `preset:"default"`, want: []int{0, 1, 2}},
+		{query: "preset:Other"},
+		{query: "Front:le*", want: []int{2}},
+		{query: "back:to_be", want: []int{0}},
+		{query: "Front:to*"},
+		{query: "nc:etre", want: []int{0}},
+		{query: "nc:ETRE", want: []int{0}},
+		{query: "re:^p.*r$", want: []int{1}},
+		{query: "re:^to", want: []int{0, 1}},
+		{query: "is:new", want: []int{0, 1, 2}},
+		{query: "is:due"},
+		{query: "flag:0", want: []int{0, 1, 2}},
+		{query: "prop:ivl>10"},
+		{query: "rated:7"},
+		{query: "added:1", want: []int{0, 1, 2}},
+		{query: "dupe:" + strconv.FormatInt(notetype.ID, 10) + ",parler", want: []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var got []int
+			for note, err := range col.SearchNotes(tt.query) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, slices.IndexFunc(notes, func(n *Note) bool { return n.ID == note.ID }))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SearchNotes(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	var cards int
+	for card, err := range col.SearchCards("card:2 deck:French") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if card.Ordinal != 1 || card.DeckID != deck.ID {
+			t.Errorf("SearchCards() got card %d of deck %d", card.Ordinal, card.DeckID)
+		}
+		cards++
+	}
+	if cards != 2 {
+		t.Errorf("SearchCards() got %d cards, want 2", cards)
+	}
+
+	for _, query := range []string{`"unterminated`, "(to", "to)", "flag:9", "prop:foo>1", "is:foo", "re:("} {
+		var err error
+		for _, err = range col.SearchNotes(query) {
+			break
+		}
+		if !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("SearchNotes(%q) error = %v, want ErrInvalidSearch", query, err)
+		}
+	}
+}
@@ -1,13 +1,25 @@
 package anki
 
 import (
+	"context"
 	"database/sql"
 	"iter"
 )
 
 // sqlTransact is a helper function to run a database transaction.
-func sqlTransact(db *sql.DB, fn func(tx *sql.Tx) error) error {
-	tx, err := db.Begin()
+// The transaction is rolled back if ctx is done before it is committed, and
+// the statements that follow fail.
+func sqlTransact(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	// The rollback triggered by ctx runs in the background. Closing the
+	// connection waits for it, so that the database is no longer locked once
+	// sqlTransact returns.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -21,11 +33,15 @@ func sqlTransact(db *sql.DB, fn func(tx *sql.Tx) error) error {
 		}
 	}()
 
-	if err = fn(tx); err != nil {
-		return err
+	if err = fn(tx); err == nil {
+		err = tx.Commit()
 	}
-
-	return tx.Commit()
+	// Statements run after the transaction was rolled back report that it
+	// is done, rather than why.
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return err
 }
 
 // sqlQueryer is an interface for querying the database.
@@ -79,6 +95,10 @@ func sqlSelectSeq[T any](q sqlQueryer, fn func(sqlQueryer, sqlRow) (T, error), q
 				return
 			}
 		}
+		if err = rows.Err(); err != nil {
+			var zero T
+			yield(zero, err)
+		}
 	}
 }
 
@@ -107,3 +127,34 @@ type sqlExt interface {
 	sqlQueryer
 	sqlExecer
 }
+
+// sqlContextExt is implemented by databases, connections and transactions.
+type sqlContextExt interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sqlContext runs the queries of the helpers above with a context, so that
+// they can be cancelled.
+type sqlContext struct {
+	ctx context.Context
+	db  sqlContextExt
+}
+
+// sqlWithContext binds a context to a database, connection or transaction.
+func sqlWithContext(ctx context.Context, db sqlContextExt) sqlExt {
+	return &sqlContext{ctx: ctx, db: db}
+}
+
+func (c *sqlContext) QueryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *sqlContext) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c *sqlContext) Exec(query string, args ...any) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
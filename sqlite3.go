@@ -1,19 +1,36 @@
 package anki
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"unicode"
 
 	"github.com/mattn/go-sqlite3"
 )
 
+// sqlite3Driver is the driver of every database, registered as sqlite3_ext.
+var sqlite3Driver = &sqlite3.SQLiteDriver{
+	ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		for name, fn := range sqlFuncs {
+			if err := conn.RegisterFunc(name, fn, true); err != nil {
+				return err
+			}
+		}
+		return conn.RegisterCollation("unicase", unicase)
+	},
+}
+
 func init() {
-	sql.Register("sqlite3_ext", &sqlite3.SQLiteDriver{
-		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-			return conn.RegisterCollation("unicase", unicase)
-		},
-	})
+	sql.Register("sqlite3_ext", sqlite3Driver)
 }
 
 // unicase is a custom collation that compares strings case-insensitively.
@@ -24,7 +41,274 @@ func unicase(s1, s2 string) int {
 	)
 }
 
+// sqlFuncs are the functions Anki defines on its connections, so that
+// queries ported from Anki run unchanged.
+var sqlFuncs = map[string]any{
+	"regexp":         sqlRegexp,
+	"regexp_fields":  sqlRegexpFields,
+	"field_at_index": sqlFieldAtIndex,
+	"process_text":   sqlProcessText,
+	"queue_hash":     sqlQueueHash,
+
+	"extract_custom_data":   sqlExtractCustomData,
+	"extract_fsrs_variable": sqlExtractFSRSVariable,
+}
+
+// sqlQueueHash implements queue_hash(id, day), which orders IDs in the random
+// order of the queues built on that day.
+func sqlQueueHash(id, day int64) int64 {
+	// Flipping the sign bit keeps the unsigned order of the hashes.
+	return int64(queueHash(id, day) ^ 1<<63)
+}
+
+// sqlRegexp implements the REGEXP operator: "s REGEXP re" reports whether s
+// matches the regular expression re.
+func sqlRegexp(re, s string) (bool, error) {
+	r, err := cachedRegexp(re)
+	if err != nil {
+		return false, err
+	}
+	return r.MatchString(s), nil
+}
+
+// sqlRegexpFields implements "regexp_fields(re, flds, ords...)", which reports
+// whether any of the fields of a note at the given ordinals, or any of its
+// fields if none are given, matches the regular expression re.
+func sqlRegexpFields(re, flds string, ords ...int64) (bool, error) {
+	r, err := cachedRegexp(re)
+	if err != nil {
+		return false, err
+	}
+	for i, field := range splitFields(flds) {
+		if len(ords) > 0 && !slices.Contains(ords, int64(i)) {
+			continue
+		}
+		if r.MatchString(field) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sqlFieldAtIndex implements "field_at_index(flds, ord)", which returns the
+// field of a note at the given ordinal, or an empty string if there is none.
+func sqlFieldAtIndex(flds string, ord int64) string {
+	fields := splitFields(flds)
+	if ord < 0 || ord >= int64(len(fields)) {
+		return ""
+	}
+	return fields[ord]
+}
+
+// Flags of process_text.
+const (
+	processTextNoCombining = 1 << iota
+	processTextStripHTML
+)
+
+// sqlProcessText implements "process_text(text, flags)", which strips HTML
+// from text and removes its accents, as selected by flags.
+func sqlProcessText(text string, flags int64) string {
+	if flags&processTextStripHTML != 0 {
+		text = stripHTML(text)
+	}
+	if flags&processTextNoCombining != 0 {
+		text = withoutCombining(text)
+	}
+	return text
+}
+
+// sqlExtractCustomData implements "extract_custom_data(data, key)", which
+// returns the value of a custom data entry of a card, as set by custom
+// scheduling code, or NULL if there is none, for use with coalesce.
+func sqlExtractCustomData(data, key string) any {
+	var custom string
+	if !parseCardData(data).get("cd", &custom) {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(custom), &m); err != nil {
+		return nil
+	}
+	v, ok := m[key]
+	if !ok {
+		return nil
+	}
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	return string(v)
+}
+
+// sqlExtractFSRSVariable implements "extract_fsrs_variable(data, key)", which
+// returns the stability ("s"), difficulty ("d"), desired retention ("dr") or
+// decay ("decay") held in the data of a card, or NULL if it is not set, for
+// use with coalesce.
+func sqlExtractFSRSVariable(data, key string) any {
+	switch key {
+	case "s", "d", "dr", "decay":
+	default:
+		return nil
+	}
+	var v float64
+	if !parseCardData(data).get(key, &v) {
+		return nil
+	}
+	return v
+}
+
+// maxCachedRegexps bounds the number of regular expressions kept compiled.
+const maxCachedRegexps = 64
+
+var (
+	regexpCacheMu sync.Mutex
+	regexpCache   = make(map[string]*regexp.Regexp)
+)
+
+// cachedRegexp compiles a regular expression, reusing the result for the
+// rows that follow, since a query evaluates REGEXP with the same expression
+// for each of them.
+func cachedRegexp(re string) (*regexp.Regexp, error) {
+	regexpCacheMu.Lock()
+	defer regexpCacheMu.Unlock()
+
+	if r, ok := regexpCache[re]; ok {
+		return r, nil
+	}
+	r, err := regexp.Compile(re)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexpCache) >= maxCachedRegexps {
+		clear(regexpCache)
+	}
+	regexpCache[re] = r
+	return r, nil
+}
+
+// readOnlyDSN returns a data source name that opens the database at path
+// read-only, as an immutable file.
+func readOnlyDSN(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	u := url.URL{Scheme: "file", Path: p, RawQuery: "mode=ro&immutable=1"}
+	return u.String(), nil
+}
+
+// sqlite3Busy reports whether err is due to a lock held on the database by
+// another connection.
+func sqlite3Busy(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
 // sqlite3Open opens a new database connection using the custom driver.
 func sqlite3Open(dataSourceName string) (*sql.DB, error) {
 	return sql.Open("sqlite3_ext", dataSourceName)
 }
+
+// sqlite3OpenMemory opens a private in-memory database. Such a database
+// lives in a single connection, which the pool hands out to every caller, so
+// statements never wait for one another and rows can be updated while they
+// are iterated, as with a database file in WAL mode.
+func sqlite3OpenMemory() (*sql.DB, error) {
+	dc, err := sqlite3Driver.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&memoryConnector{conn: dc.(*sqlite3.SQLiteConn)}), nil
+}
+
+// memoryConnector connects to the single connection of an in-memory
+// database, and closes it along with the database.
+type memoryConnector struct {
+	conn *sqlite3.SQLiteConn
+}
+
+func (m *memoryConnector) Connect(context.Context) (driver.Conn, error) {
+	return memoryConn{m.conn}, nil
+}
+
+func (m *memoryConnector) Driver() driver.Driver {
+	return sqlite3Driver
+}
+
+func (m *memoryConnector) Close() error {
+	return m.conn.Close()
+}
+
+// memoryConn is a handle on the connection of an in-memory database, which
+// stays open when the handle is closed.
+type memoryConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (memoryConn) Close() error {
+	return nil
+}
+
+// sqlite3Raw runs fn with the driver connection underlying conn.
+func sqlite3Raw(conn *sql.Conn, fn func(c *sqlite3.SQLiteConn) error) error {
+	return conn.Raw(func(dc any) error {
+		if m, ok := dc.(memoryConn); ok {
+			return fn(m.SQLiteConn)
+		}
+		return fn(dc.(*sqlite3.SQLiteConn))
+	})
+}
+
+// sqlite3Serialize returns the content of the main database of conn, as it
+// would be stored in a file.
+func sqlite3Serialize(conn *sql.Conn) ([]byte, error) {
+	var b []byte
+	err := sqlite3Raw(conn, func(c *sqlite3.SQLiteConn) error {
+		var err error
+		b, err = c.Serialize("main")
+		return err
+	})
+	return b, err
+}
+
+// sqlite3Deserialize replaces the main database of a private in-memory
+// database, opened with sqlite3OpenMemory, with the content of a database
+// file.
+func sqlite3Deserialize(db *sql.DB, b []byte) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	// A database in WAL mode cannot be read from memory, so the file is
+	// switched back to rollback journal mode, as in its header.
+	if len(b) > 19 && b[18] == 2 && b[19] == 2 {
+		b[18], b[19] = 1, 1
+	}
+	return sqlite3Raw(conn, func(c *sqlite3.SQLiteConn) error {
+		return c.Deserialize(b, "main")
+	})
+}
+
+// sqlite3Backup copies the main database of src to the main database of dst.
+func sqlite3Backup(dst, src *sql.Conn) error {
+	return sqlite3Raw(dst, func(d *sqlite3.SQLiteConn) error {
+		return sqlite3Raw(src, func(s *sqlite3.SQLiteConn) error {
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			if _, err = b.Step(-1); err != nil {
+				_ = b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
@@ -0,0 +1,49 @@
+package anki
+
+import "testing"
+
+// TestSQLFuncs tests the functions registered on the connections.
+func TestSQLFuncs(t *testing.T) {
+	col, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer col.Close() //nolint:errcheck
+
+	tests := []struct {
+		query string
+		args  []any
+		want  string
+	}{
+		{query: "SELECT 'abc' REGEXP '^a.c$'", want: "1"},
+		{query: "SELECT 'abc' REGEXP '^b'", want: "0"},
+		{query: "SELECT regexp_fields('^b$', ?)", args: []any{"a\x1fb"}, want: "1"},
+		{query: "SELECT regexp_fields('^b$', ?, 0)", args: []any{"a\x1fb"}, want: "0"},
+		{query: "SELECT regexp_fields('^b$', ?, 0, 1)", args: []any{"a\x1fb"}, want: "1"},
+		{query: "SELECT field_at_index(?, 1)", args: []any{"a\x1fb"}, want: "b"},
+		{query: "SELECT field_at_index(?, 2)", args: []any{"a\x1fb"}, want: ""},
+		{query: "SELECT process_text('<b>Été</b>', 1)", want: "<b>Ete</b>"},
+		{query: "SELECT process_text('<b>Été</b>', 2)", want: "Été"},
+		{query: "SELECT process_text('<b>Été</b>', 3)", want: "Ete"},
+		{query: "SELECT process_text(?, 1)", args: []any{"Tiếng Việt"}, want: "Tieng Viet"},
+		{query: "SELECT process_text(?, 1)", args: []any{"ἀθάνατος й"}, want: "αθανατος и"},
+		{query: "SELECT process_text(?, 1)", args: []any{"øđł"}, want: "øđł"},
+		{query: "SELECT process_text(?, 1)", args: []any{"कि a\u20dd"}, want: "क a"},
+		{query: "SELECT coalesce(extract_custom_data(?, 'r'), 'none')", args: []any{`{"cd":"{\"r\":\"x\",\"n\":2}"}`}, want: "x"},
+		{query: "SELECT coalesce(extract_custom_data(?, 'n'), 'none')", args: []any{`{"cd":"{\"r\":\"x\",\"n\":2}"}`}, want: "2"},
+		{query: "SELECT coalesce(extract_custom_data(?, 'm'), 'none')", args: []any{`{"cd":"{\"r\":\"x\"}"}`}, want: "none"},
+		{query: "SELECT coalesce(extract_custom_data('', 'r'), 'none')", want: "none"},
+		{query: "SELECT coalesce(extract_fsrs_variable(?, 's'), 0)", args: []any{`{"s":12.5,"d":5.1}`}, want: "12.5"},
+		{query: "SELECT coalesce(extract_fsrs_variable(?, 'dr'), 0.9)", args: []any{`{"s":12.5,"d":5.1}`}, want: "0.9"},
+		{query: "SELECT coalesce(extract_fsrs_variable(?, 'pos'), 0)", args: []any{`{"pos":3}`}, want: "0"},
+	}
+	for _, tt := range tests {
+		var got string
+		if err := col.db.QueryRow(tt.query, tt.args...).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
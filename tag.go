@@ -1,6 +1,7 @@
 package anki
 
 import (
+	"database/sql"
 	"iter"
 )
 
@@ -13,12 +14,16 @@ type Tag struct {
 
 // SetTag adds or updates a tag.
 func (c *Collection) SetTag(tag *Tag) error {
-	return sqlExecute(c.db, setTagQuery, tag.Name, tag.USN, !tag.Expanded)
+	return c.transact(func(tx *sql.Tx) error {
+		return sqlExecute(tx, setTagQuery, tag.Name, tag.USN, !tag.Expanded)
+	})
 }
 
 // DeleteTag deletes a tag by name.
 func (c *Collection) DeleteTag(name string) error {
-	return sqlExecute(c.db, deleteTagQuery, name)
+	return c.transact(func(tx *sql.Tx) error {
+		return sqlExecute(tx, deleteTagQuery, name)
+	})
 }
 
 // GetTag gets a tag by name.
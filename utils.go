@@ -1,6 +1,12 @@
 package anki
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,6 +31,43 @@ func randomGUID() (string, error) {
 	return u.String(), nil
 }
 
+// guidNamespace is the namespace of the GUIDs derived by derivedGUID.
+var guidNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/lftk/anki"))
+
+// derivedGUID derives a GUID from the ID, notetype and fields of a note.
+func derivedGUID(note *Note) string {
+	name := fmt.Sprintf("%d\x1f%d\x1f%s", note.ID, note.NotetypeID, joinFields(note.Fields))
+	return uuid.NewSHA1(guidNamespace, []byte(name)).String()
+}
+
+// sha1Hex returns the hex-encoded SHA-1 hash of the content of r.
+func sha1Hex(r io.Reader) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// contextReader is a reader that fails once its context is done, so that
+// long copies can be cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// readerWithContext wraps r so that reading fails once ctx is done.
+func readerWithContext(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
 // scanValue scans a single value from a database row.
 func scanValue[T any](_ sqlQueryer, row sqlRow) (T, error) {
 	var val T
@@ -40,3 +83,11 @@ func sliceMap[Slice ~[]E, E, T any](s Slice, f func(E) T) []T {
 	}
 	return vals
 }
+
+// errorSeq returns a sequence that yields err only.
+func errorSeq[T any](err error) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield(zero, err)
+	}
+}
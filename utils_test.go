@@ -1,6 +1,10 @@
 package anki
 
 import (
+	"context"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -33,3 +37,18 @@ func TestTimeUnix(t *testing.T) {
 		})
 	}
 }
+
+// TestReaderWithContext tests the readerWithContext function.
+func TestReaderWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := readerWithContext(ctx, strings.NewReader("hello"))
+
+	buf := make([]byte, 2)
+	if n, err := r.Read(buf); n != 2 || err != nil {
+		t.Fatalf("Read() = %v, %v, want 2, nil", n, err)
+	}
+	cancel()
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAll() error = %v, want %v", err, context.Canceled)
+	}
+}
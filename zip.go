@@ -3,10 +3,34 @@ package anki
 import (
 	"archive/zip"
 	"io"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 )
 
+// zipWriter is a zip archive being written.
+type zipWriter struct {
+	*zip.Writer
+	// deterministic makes the archive depend on the content of its files
+	// only: headers carry a fixed time, and zstd compression is configured
+	// so that the same content always compresses to the same bytes.
+	deterministic bool
+}
+
+// zipEpoch is the time recorded in the headers of deterministic archives,
+// the earliest one the zip format can represent.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// stableZstdOptions pins the zstd encoder configuration of deterministic
+// archives. Compressing on a single goroutine keeps the blocks independent of
+// the number of CPUs.
+var stableZstdOptions = []zstd.EOption{
+	zstd.WithEncoderLevel(zstd.SpeedDefault),
+	zstd.WithEncoderConcurrency(1),
+	zstd.WithWindowSize(8 << 20),
+	zstd.WithEncoderCRC(true),
+}
+
 // zipOpen opens a file from a zip archive, with optional zstd decompression.
 func zipOpen(r *zip.Reader, name string, dcomp bool) (io.ReadCloser, error) {
 	f, err := r.Open(name)
@@ -14,22 +38,47 @@ func zipOpen(r *zip.Reader, name string, dcomp bool) (io.ReadCloser, error) {
 		return nil, err
 	}
 	if dcomp {
-		dec, err := zstd.NewReader(f)
-		if err != nil {
-			return nil, err
-		}
-		return dec.IOReadCloser(), nil
+		return zstdReadCloser(f)
 	}
 	return f, nil
 }
 
+// zstdReadCloser wraps a reader with zstd decompression. Closing the returned
+// reader also closes r, as does a failure to create the decoder.
+func zstdReadCloser(r io.ReadCloser) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return &zstdReader{Decoder: dec, r: r}, nil
+}
+
+// zstdReader is a zstd decoder that closes its underlying reader.
+type zstdReader struct {
+	*zstd.Decoder
+	r io.Closer
+}
+
+func (z *zstdReader) Close() error {
+	z.Decoder.Close()
+	return z.r.Close()
+}
+
 // zipCreate creates a file in a zip archive, with optional zstd compression.
-func zipCreate(w *zip.Writer, name string, comp bool) (io.WriteCloser, error) {
-	zw, err := w.Create(name)
+func zipCreate(w *zipWriter, name string, comp bool) (io.WriteCloser, error) {
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if w.deterministic {
+		fh.Modified = zipEpoch
+	}
+	zw, err := w.CreateHeader(fh)
 	if err != nil {
 		return nil, err
 	}
 	if comp {
+		if w.deterministic {
+			return zstd.NewWriter(zw, stableZstdOptions...)
+		}
 		return zstd.NewWriter(zw)
 	}
 	return nopWriteCloser{zw}, nil
@@ -64,7 +113,7 @@ func zipReadAll(r *zip.Reader, name string, dcomp bool) ([]byte, error) {
 }
 
 // zipWrite writes data to a file in a zip archive.
-func zipWrite(w *zip.Writer, name string, comp bool, data []byte) error {
+func zipWrite(w *zipWriter, name string, comp bool, data []byte) error {
 	zw, err := zipCreate(w, name, comp)
 	if err != nil {
 		return err